@@ -1,27 +1,22 @@
 /**
- * Formats a log string for display.
- * - Newest lines appear first
- * - If more than 100 lines, shows: 50 newest + "..." + 50 oldest
+ * Formats one entry of the merged log as a text line:
+ * time, level, [journal] for journal entries, message and key=value fields.
  */
-export function buildLogForDisplay(value) {
-  if (!value) {
-    return '';
-  }
-
-  const normalized = value.replaceAll('\r\n', '\n');
-  const lines = normalized.split('\n');
-
-  // drop trailing empty line from final newline
-  while (lines.length > 0 && lines[lines.length - 1] === '') lines.pop();
-
-  // newest first
-  lines.reverse();
+export function formatLogEntry(entry) {
+  const time = new Date(entry.time).toLocaleString();
+  const source = entry.source === 'journal' ? ' [journal]' : '';
+  const fields = (entry.fields || [])
+    .map(({ key, value }) => (/[\s"=]/.test(value) || value === '' ? `${key}=${JSON.stringify(value)}` : `${key}=${value}`))
+    .join(' ');
+  return `${time} ${entry.level.toUpperCase().padEnd(5)}${source} ${entry.message}${fields ? ' ' + fields : ''}`;
+}
 
-  if (lines.length > 100) {
-    const head = lines.slice(0, 50);
-    const tail = lines.slice(-50);
-    return [...head, '...', ...tail].join('\n');
+/**
+ * Formats log entries, as returned newest first by QueryLog, for display.
+ */
+export function buildLogForDisplay(entries) {
+  if (!entries || entries.length === 0) {
+    return '';
   }
-
-  return lines.join('\n');
+  return entries.map(formatLogEntry).join('\n');
 }
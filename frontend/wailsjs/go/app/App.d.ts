@@ -1,18 +1,45 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {service} from '../models';
+
+export function CancelJob(arg1:string):Promise<service.CancelResult>;
+
+export function CancelRun(arg1:string):Promise<service.CancelResult>;
+
+export function FollowJobOutput(arg1:string):Promise<void>;
 
 export function GetLogPath():Promise<string>;
 
+export function GetRunnerStatus():Promise<service.RunnerStatus>;
+
 export function GetServiceStatus():Promise<string>;
 
 export function InstallService():Promise<string>;
 
 export function InstallSystemService():Promise<string>;
 
+export function JobTypes():Promise<Array<service.JobType>>;
+
+export function PauseScheduling(arg1:string):Promise<service.PauseState>;
+
+export function PreviewSchedule(arg1:string,arg2:number):Promise<service.SchedulePreview>;
+
+export function QueryLog(arg1:service.LogQuery):Promise<service.LogPage>;
+
+export function QueryRunHistory(arg1:service.HistoryQuery):Promise<Array<service.RunRecord>>;
+
 export function ReadLog():Promise<string>;
 
+export function ResumeScheduling(arg1:string):Promise<service.PauseState>;
+
+export function RunJob(arg1:string):Promise<string>;
+
+export function SetLogLevel(arg1:string):Promise<service.LogLevelState>;
+
 export function StartService():Promise<string>;
 
+export function StopFollowingOutput():Promise<void>;
+
 export function StopService():Promise<string>;
 
 export function UninstallService():Promise<string>;
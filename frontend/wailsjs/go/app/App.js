@@ -2,10 +2,26 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CancelJob(arg1) {
+  return window['go']['app']['App']['CancelJob'](arg1);
+}
+
+export function CancelRun(arg1) {
+  return window['go']['app']['App']['CancelRun'](arg1);
+}
+
+export function FollowJobOutput(arg1) {
+  return window['go']['app']['App']['FollowJobOutput'](arg1);
+}
+
 export function GetLogPath() {
   return window['go']['app']['App']['GetLogPath']();
 }
 
+export function GetRunnerStatus() {
+  return window['go']['app']['App']['GetRunnerStatus']();
+}
+
 export function GetServiceStatus() {
   return window['go']['app']['App']['GetServiceStatus']();
 }
@@ -18,14 +34,50 @@ export function InstallSystemService() {
   return window['go']['app']['App']['InstallSystemService']();
 }
 
+export function JobTypes() {
+  return window['go']['app']['App']['JobTypes']();
+}
+
+export function PauseScheduling(arg1) {
+  return window['go']['app']['App']['PauseScheduling'](arg1);
+}
+
+export function PreviewSchedule(arg1,arg2) {
+  return window['go']['app']['App']['PreviewSchedule'](arg1,arg2);
+}
+
+export function QueryLog(arg1) {
+  return window['go']['app']['App']['QueryLog'](arg1);
+}
+
+export function QueryRunHistory(arg1) {
+  return window['go']['app']['App']['QueryRunHistory'](arg1);
+}
+
 export function ReadLog() {
   return window['go']['app']['App']['ReadLog']();
 }
 
+export function ResumeScheduling(arg1) {
+  return window['go']['app']['App']['ResumeScheduling'](arg1);
+}
+
+export function RunJob(arg1) {
+  return window['go']['app']['App']['RunJob'](arg1);
+}
+
+export function SetLogLevel(arg1) {
+  return window['go']['app']['App']['SetLogLevel'](arg1);
+}
+
 export function StartService() {
   return window['go']['app']['App']['StartService']();
 }
 
+export function StopFollowingOutput() {
+  return window['go']['app']['App']['StopFollowingOutput']();
+}
+
 export function StopService() {
   return window['go']['app']['App']['StopService']();
 }
@@ -0,0 +1,497 @@
+export namespace service {
+	
+	export class CancelResult {
+	    job: string;
+	    cancelled: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CancelResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.job = source["job"];
+	        this.cancelled = source["cancelled"];
+	    }
+	}
+	export class HistoryQuery {
+	    job?: string;
+	    status?: string;
+	    // Go type: time
+	    since?: any;
+	    // Go type: time
+	    until?: any;
+	    limit?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new HistoryQuery(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.job = source["job"];
+	        this.status = source["status"];
+	        this.since = this.convertValues(source["since"], null);
+	        this.until = this.convertValues(source["until"], null);
+	        this.limit = source["limit"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class JobStatus {
+	    name: string;
+	    type: string;
+	    schedule: string;
+	    running: boolean;
+	    overlap: string;
+	    active: number;
+	    pending: number;
+	    skipped: number;
+	    paused: boolean;
+	    // Go type: time
+	    next_run?: any;
+	    // Go type: time
+	    last_start?: any;
+	    // Go type: time
+	    last_end?: any;
+	    last_error?: string;
+	    last_status?: string;
+	    last_run_id?: string;
+	    run_count: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new JobStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.type = source["type"];
+	        this.schedule = source["schedule"];
+	        this.running = source["running"];
+	        this.overlap = source["overlap"];
+	        this.active = source["active"];
+	        this.pending = source["pending"];
+	        this.skipped = source["skipped"];
+	        this.paused = source["paused"];
+	        this.next_run = this.convertValues(source["next_run"], null);
+	        this.last_start = this.convertValues(source["last_start"], null);
+	        this.last_end = this.convertValues(source["last_end"], null);
+	        this.last_error = source["last_error"];
+	        this.last_status = source["last_status"];
+	        this.last_run_id = source["last_run_id"];
+	        this.run_count = source["run_count"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class JobType {
+	    name: string;
+	    description: string;
+	    path?: string;
+	    params: JobTypeParam[];
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new JobType(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.description = source["description"];
+	        this.path = source["path"];
+	        this.params = this.convertValues(source["params"], JobTypeParam);
+	        this.error = source["error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class JobTypeParam {
+	    name: string;
+	    type: string;
+	    description: string;
+	    required: boolean;
+	    default?: string;
+	    choices?: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new JobTypeParam(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.type = source["type"];
+	        this.description = source["description"];
+	        this.required = source["required"];
+	        this.default = source["default"];
+	        this.choices = source["choices"];
+	    }
+	}
+	export class LogField {
+	    key: string;
+	    value: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogField(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.value = source["value"];
+	    }
+	}
+	export class LogLevelState {
+	    level: string;
+	    format: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogLevelState(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.level = source["level"];
+	        this.format = source["format"];
+	    }
+	}
+	export class LogPage {
+	    entries: LogStreamEntry[];
+	    next?: string;
+	    journal?: string;
+	    journal_error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogPage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.entries = this.convertValues(source["entries"], LogStreamEntry);
+	        this.next = source["next"];
+	        this.journal = source["journal"];
+	        this.journal_error = source["journal_error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class LogQuery {
+	    before?: string;
+	    limit?: number;
+	    level?: string;
+	    job?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogQuery(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.before = source["before"];
+	        this.limit = source["limit"];
+	        this.level = source["level"];
+	        this.job = source["job"];
+	    }
+	}
+	export class LogSinkStatus {
+	    name: string;
+	    written: number;
+	    dropped: number;
+	    failed: number;
+	    last_error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogSinkStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.written = source["written"];
+	        this.dropped = source["dropped"];
+	        this.failed = source["failed"];
+	        this.last_error = source["last_error"];
+	    }
+	}
+	export class LogStreamEntry {
+	    // Go type: time
+	    time: any;
+	    level: string;
+	    source: string;
+	    message: string;
+	    fields?: LogField[];
+	
+	    static createFrom(source: any = {}) {
+	        return new LogStreamEntry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = this.convertValues(source["time"], null);
+	        this.level = source["level"];
+	        this.source = source["source"];
+	        this.message = source["message"];
+	        this.fields = this.convertValues(source["fields"], LogField);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PauseState {
+	    all: boolean;
+	    jobs: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new PauseState(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.all = source["all"];
+	        this.jobs = source["jobs"];
+	    }
+	}
+	export class PoolStatus {
+	    workers: number;
+	    active: number;
+	    queued: number;
+	    queue_size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PoolStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.workers = source["workers"];
+	        this.active = source["active"];
+	        this.queued = source["queued"];
+	        this.queue_size = source["queue_size"];
+	    }
+	}
+	export class RunRecord {
+	    id: string;
+	    job: string;
+	    trigger: string;
+	    attempt: number;
+	    retry_of?: string;
+	    parent_id?: string;
+	    // Go type: time
+	    start: any;
+	    // Go type: time
+	    end: any;
+	    status: string;
+	    exit_code?: number;
+	    error?: string;
+	    stdout?: string;
+	    stderr?: string;
+	    output_truncated?: boolean;
+	    trigger_paths?: string[];
+	    trigger_event?: string;
+	    limit_exceeded?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RunRecord(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.job = source["job"];
+	        this.trigger = source["trigger"];
+	        this.attempt = source["attempt"];
+	        this.retry_of = source["retry_of"];
+	        this.parent_id = source["parent_id"];
+	        this.start = this.convertValues(source["start"], null);
+	        this.end = this.convertValues(source["end"], null);
+	        this.status = source["status"];
+	        this.exit_code = source["exit_code"];
+	        this.error = source["error"];
+	        this.stdout = source["stdout"];
+	        this.stderr = source["stderr"];
+	        this.output_truncated = source["output_truncated"];
+	        this.trigger_paths = source["trigger_paths"];
+	        this.trigger_event = source["trigger_event"];
+	        this.limit_exceeded = source["limit_exceeded"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class RunnerStatus {
+	    version: string;
+	    protocol_version: number;
+	    pid: number;
+	    // Go type: time
+	    started_at: any;
+	    uptime_seconds: number;
+	    // Go type: time
+	    last_heartbeat?: any;
+	    config_path: string;
+	    paused: boolean;
+	    pool: PoolStatus;
+	    jobs: JobStatus[];
+	    log_level: string;
+	    log_write_failures: number;
+	    log_sinks: LogSinkStatus[];
+	
+	    static createFrom(source: any = {}) {
+	        return new RunnerStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.version = source["version"];
+	        this.protocol_version = source["protocol_version"];
+	        this.pid = source["pid"];
+	        this.started_at = this.convertValues(source["started_at"], null);
+	        this.uptime_seconds = source["uptime_seconds"];
+	        this.last_heartbeat = this.convertValues(source["last_heartbeat"], null);
+	        this.config_path = source["config_path"];
+	        this.paused = source["paused"];
+	        this.pool = this.convertValues(source["pool"], PoolStatus);
+	        this.jobs = this.convertValues(source["jobs"], JobStatus);
+	        this.log_level = source["log_level"];
+	        this.log_write_failures = source["log_write_failures"];
+	        this.log_sinks = this.convertValues(source["log_sinks"], LogSinkStatus);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SchedulePreview {
+	    job: string;
+	    schedule: string;
+	    time_zone: string;
+	    jitter?: string;
+	    blackout?: string[];
+	    times: any[];
+	
+	    static createFrom(source: any = {}) {
+	        return new SchedulePreview(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.job = source["job"];
+	        this.schedule = source["schedule"];
+	        this.time_zone = source["time_zone"];
+	        this.jitter = source["jitter"];
+	        this.blackout = source["blackout"];
+	        this.times = source["times"];
+	    }
+	}
+
+}
+
@@ -2,17 +2,42 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 
 	"go-toy/internal/service"
 	"go-toy/internal/shared"
 )
 
+// Events emitted to the frontend while following job output.
+const (
+	// jobOutputEvent carries a service.OutputEvent.
+	jobOutputEvent = "job-output"
+	// jobOutputClosedEvent carries an error message, empty if the stream
+	// ended normally.
+	jobOutputClosedEvent = "job-output-closed"
+)
+
+// How much of the log ReadLog shows.
+const (
+	readLogLines = 100
+	readLogBytes = 128 * 1024
+)
+
 // App struct
 type App struct {
-	ctx context.Context
-	svc service.Service
+	ctx     context.Context
+	svc     service.Service
+	control *service.ControlClient
+
+	followMu   sync.Mutex
+	stopFollow context.CancelFunc
 }
 
 // New creates a new App application struct
@@ -24,6 +49,9 @@ func New() *App {
 func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
 	a.svc = service.NewService()
+	if control, err := service.NewControlClient(); err == nil {
+		a.control = control
+	}
 }
 
 // GetServiceStatus returns the current status of the service
@@ -35,13 +63,154 @@ func (a *App) GetServiceStatus() string {
 	return status
 }
 
+// GetRunnerStatus returns the live internal state of the running runner,
+// queried over its control socket.
+func (a *App) GetRunnerStatus() (*service.RunnerStatus, error) {
+	if a.control == nil {
+		return nil, service.ErrRunnerNotRunning
+	}
+	return a.control.Status()
+}
+
+// QueryRunHistory returns recorded job runs matching q, newest first. When
+// the runner is not running the history file is read directly.
+func (a *App) QueryRunHistory(q service.HistoryQuery) ([]service.RunRecord, error) {
+	if a.control != nil {
+		records, err := a.control.History(q)
+		if !errors.Is(err, service.ErrRunnerNotRunning) {
+			return records, err
+		}
+	}
+	return service.ReadHistory(q)
+}
+
+// PauseScheduling suspends automatic runs of job, or of every job if job is
+// empty. When the runner is not running the change is saved for its next
+// start.
+func (a *App) PauseScheduling(job string) (service.PauseState, error) {
+	return a.setPaused(job, true)
+}
+
+// ResumeScheduling undoes PauseScheduling for job, or lifts the global pause
+// if job is empty.
+func (a *App) ResumeScheduling(job string) (service.PauseState, error) {
+	return a.setPaused(job, false)
+}
+
+func (a *App) setPaused(job string, paused bool) (service.PauseState, error) {
+	if a.control != nil {
+		var st service.PauseState
+		var err error
+		if paused {
+			st, err = a.control.Pause(job)
+		} else {
+			st, err = a.control.Resume(job)
+		}
+		if !errors.Is(err, service.ErrRunnerNotRunning) {
+			return st, err
+		}
+	}
+	return service.UpdatePauseState(job, paused)
+}
+
+// PreviewSchedule returns the next count activation times of job as
+// configured in runner.conf, whether or not the runner has loaded it yet.
+func (a *App) PreviewSchedule(job string, count int) (*service.SchedulePreview, error) {
+	return service.PreviewSchedule(job, count)
+}
+
+// JobTypes returns the job types provided by tasks and plugins, with their
+// parameters. The plugins directory is rescanned on every call.
+func (a *App) JobTypes() []service.JobType {
+	return service.JobTypes()
+}
+
+// RunJob starts a run of job immediately and returns its run ID.
+func (a *App) RunJob(job string) (string, error) {
+	if a.control == nil {
+		return "", service.ErrRunnerNotRunning
+	}
+	return a.control.RunJob(job)
+}
+
+// CancelRun cancels the queued or running run with the given ID.
+func (a *App) CancelRun(id string) (service.CancelResult, error) {
+	if a.control == nil {
+		return service.CancelResult{}, service.ErrRunnerNotRunning
+	}
+	return a.control.CancelRun(id)
+}
+
+// SetLogLevel changes the log level of the running runner until it restarts.
+func (a *App) SetLogLevel(level string) (service.LogLevelState, error) {
+	if a.control == nil {
+		return service.LogLevelState{}, service.ErrRunnerNotRunning
+	}
+	return a.control.SetLogLevel(level)
+}
+
+// CancelJob cancels every queued and running run of job.
+func (a *App) CancelJob(job string) (service.CancelResult, error) {
+	if a.control == nil {
+		return service.CancelResult{}, service.ErrRunnerNotRunning
+	}
+	return a.control.CancelJob(job)
+}
+
+// FollowJobOutput streams the live stdout and stderr of job's runs (of all
+// jobs if job is empty) to the frontend as "job-output" events, replacing
+// any stream started before. A "job-output-closed" event follows when the
+// stream ends other than through StopFollowingOutput.
+func (a *App) FollowJobOutput(job string) error {
+	if a.control == nil {
+		return service.ErrRunnerNotRunning
+	}
+	a.StopFollowingOutput()
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	done, err := a.control.Follow(ctx, job, "", func(ev service.OutputEvent) {
+		runtime.EventsEmit(a.ctx, jobOutputEvent, ev)
+	})
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	a.followMu.Lock()
+	a.stopFollow = cancel
+	a.followMu.Unlock()
+
+	go func() {
+		err := <-done
+		if ctx.Err() != nil {
+			return
+		}
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		runtime.EventsEmit(a.ctx, jobOutputClosedEvent, msg)
+	}()
+	return nil
+}
+
+// StopFollowingOutput ends the stream started by FollowJobOutput.
+func (a *App) StopFollowingOutput() {
+	a.followMu.Lock()
+	defer a.followMu.Unlock()
+	if a.stopFollow != nil {
+		a.stopFollow()
+		a.stopFollow = nil
+	}
+}
+
 // InstallService installs the service with user privileges.
 func (a *App) InstallService() string {
 	err := a.svc.Install()
 	if err != nil {
 		return "Failed to install: " + err.Error()
 	}
-	return "Service installed successfully"
+	return "Service installed successfully" + apiTokenNote()
 }
 
 // InstallSystemService installs the service system-wide (Linux: /etc/systemd/system).
@@ -57,7 +226,16 @@ func (a *App) InstallSystemService() string {
 	if err := si.InstallSystem(); err != nil {
 		return "Failed to install system service: " + err.Error()
 	}
-	return "System service installed successfully"
+	return "System service installed successfully" + apiTokenNote()
+}
+
+// apiTokenNote creates the runner's HTTP API token on install and reports
+// problems doing so.
+func apiTokenNote() string {
+	if _, err := service.EnsureAPIToken(); err != nil {
+		return " (warning: could not create API token: " + err.Error() + ")"
+	}
+	return ""
 }
 
 // UninstallService uninstalls the service
@@ -92,17 +270,46 @@ func (a *App) GetLogPath() string {
 	return shared.GetLogPath()
 }
 
-// ReadLog reads the last few lines of the log file
+// QueryLog returns a page of the runner log merged with the journal of its
+// systemd unit, which has what the runner could not log itself, such as
+// crashes and restarts. Pass the Next cursor of a page as q.Before to get
+// the page of older entries.
+func (a *App) QueryLog(q service.LogQuery) (*service.LogPage, error) {
+	return service.ReadLogStream(q)
+}
+
+// ReadLog returns the last lines of the log file as text lines, whichever
+// format they were written in. Lines it cannot parse, such as those of
+// multi-line messages, are shown as they are.
 func (a *App) ReadLog() string {
 	logPath := shared.GetLogPath()
-	data, err := os.ReadFile(logPath)
+	f, err := os.Open(logPath)
 	if err != nil {
 		return fmt.Sprintf("Could not read log: %v", err)
 	}
+	defer f.Close()
 
-	// Return last 2000 characters
-	if len(data) > 2000 {
-		return string(data[len(data)-2000:])
+	// Read enough of the end of the file for readLogLines JSON lines.
+	var offset int64
+	if info, err := f.Stat(); err == nil && info.Size() > readLogBytes {
+		offset = info.Size() - readLogBytes
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, readLogBytes))
+	if err != nil {
+		return fmt.Sprintf("Could not read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 {
+		// The first line is cut.
+		lines = lines[1:]
+	}
+	if len(lines) > readLogLines {
+		lines = lines[len(lines)-readLogLines:]
+	}
+	for i, line := range lines {
+		if e, ok := shared.ParseLogLine(line); ok {
+			lines[i] = shared.FormatLogEntry(e)
+		}
 	}
-	return string(data)
+	return strings.Join(lines, "\n") + "\n"
 }
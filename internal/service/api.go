@@ -0,0 +1,378 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// The HTTP API lets local tools drive the runner without the GUI. It only
+// listens on the loopback interface or a Unix socket, and every request must
+// carry the bearer token stored in the runner directory.
+
+const (
+	apiTokenFileName = "api.token"
+	defaultAPIListen = "127.0.0.1:8765"
+	apiUnixPrefix    = "unix:"
+
+	apiShutdownTimeout = 5 * time.Second
+)
+
+// APIOptions configures the HTTP API ([api] section).
+type APIOptions struct {
+	Enabled bool
+	// Listen is host:port on a loopback address, or unix:<path>.
+	Listen string
+}
+
+// parseAPIListen splits a listen setting into a network and address for
+// net.Listen, rejecting anything reachable from other hosts.
+func parseAPIListen(listen string) (network, address string, err error) {
+	if path, ok := strings.CutPrefix(listen, apiUnixPrefix); ok {
+		if !filepath.IsAbs(path) {
+			return "", "", fmt.Errorf("unix socket path must be absolute, got %q", path)
+		}
+		return "unix", path, nil
+	}
+
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", "", fmt.Errorf("want host:port or unix:<path>, got %q", listen)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q", port)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return "", "", fmt.Errorf("must listen on a loopback address such as 127.0.0.1, got %q", host)
+		}
+	}
+	return "tcp", listen, nil
+}
+
+// EnsureAPIToken creates the API token file with a fresh random token unless
+// it already exists, and returns its path. The file is readable by the owner
+// only.
+func EnsureAPIToken() (string, error) {
+	path, err := getAPITokenPath()
+	if err != nil {
+		return "", err
+	}
+	if err := shared.EnsureLogDir(); err != nil {
+		return "", fmt.Errorf("failed to create runner dir: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Tighten permissions of a token written by hand.
+		if runtime.GOOS != "windows" {
+			if err := os.Chmod(path, 0600); err != nil {
+				return "", fmt.Errorf("failed to restrict API token permissions: %w", err)
+			}
+		}
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	if _, err := f.WriteString(hex.EncodeToString(b[:]) + "\n"); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write API token: %w", err)
+	}
+	return path, f.Close()
+}
+
+func readAPIToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", path)
+	}
+	return token, nil
+}
+
+type apiServer struct {
+	r       *runner
+	opts    APIOptions
+	token   string
+	network string
+	address string
+	server  *http.Server
+}
+
+// startAPI starts serving the HTTP API as configured by opts.
+func (r *runner) startAPI(opts APIOptions) (*apiServer, error) {
+	network, address, err := parseAPIListen(opts.Listen)
+	if err != nil {
+		return nil, err
+	}
+	tokenPath, err := EnsureAPIToken()
+	if err != nil {
+		return nil, err
+	}
+	token, err := readAPIToken(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if _, err := os.Stat(address); err == nil {
+			if conn, err := net.DialTimeout("unix", address, time.Second); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("another process is already listening on %s", address)
+			}
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("failed to remove stale API socket: %w", err)
+			}
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", opts.Listen, err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to restrict API socket permissions: %w", err)
+		}
+	}
+
+	a := &apiServer{
+		r:       r,
+		opts:    opts,
+		token:   token,
+		network: network,
+		address: address,
+	}
+	a.server = &http.Server{
+		Handler:           a.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.log.Error("HTTP API stopped", "error", err)
+		}
+	}()
+	return a, nil
+}
+
+// Close stops the server, letting requests in progress finish briefly.
+func (a *apiServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	err := a.server.Shutdown(ctx)
+	if a.network == "unix" {
+		_ = os.Remove(a.address)
+	}
+	return err
+}
+
+func (a *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", a.handleStatus)
+	mux.HandleFunc("GET /v1/jobs", a.handleJobs)
+	mux.HandleFunc("GET /v1/jobs/{name}", a.handleJob)
+	mux.HandleFunc("POST /v1/jobs/{name}/run", a.handleRunJob)
+	mux.HandleFunc("POST /v1/jobs/{name}/cancel", a.handleCancelJob)
+	mux.HandleFunc("POST /v1/jobs/{name}/pause", a.handlePause(true))
+	mux.HandleFunc("POST /v1/jobs/{name}/resume", a.handlePause(false))
+	mux.HandleFunc("POST /v1/pause", a.handlePause(true))
+	mux.HandleFunc("POST /v1/resume", a.handlePause(false))
+	mux.HandleFunc("GET /v1/runs/{id}", a.handleRun)
+	mux.HandleFunc("POST /v1/runs/{id}/cancel", a.handleCancelRun)
+	mux.HandleFunc("GET /v1/history", a.handleHistory)
+	return a.authenticate(mux)
+}
+
+func (a *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-toy"`)
+			writeAPIError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (a *apiServer) handleStatus(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, a.r.status())
+}
+
+func (a *apiServer) handleJobs(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, a.r.scheduler.Jobs())
+}
+
+func (a *apiServer) handleJob(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	for _, job := range a.r.scheduler.Jobs() {
+		if job.Name == name {
+			writeJSON(w, http.StatusOK, job)
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, fmt.Errorf("unknown job %q", name))
+}
+
+// handleRunJob starts a run in the background and returns its ID, which can
+// be polled through /v1/runs/{id}.
+func (a *apiServer) handleRunJob(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	if _, err := a.r.scheduler.entry(name); err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	id, err := a.r.scheduler.Fire(name, triggerAPI, nil, "")
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"job": name, "run_id": id})
+}
+
+func (a *apiServer) handleCancelJob(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	n, err := a.r.scheduler.CancelJob(name)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"job": name, "cancelled": n})
+}
+
+// handlePause pauses or resumes the job in the path, or everything on the
+// routes without one.
+func (a *apiServer) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		st, err := a.r.setPaused(req.PathValue("name"), paused)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	}
+}
+
+// handleRun returns every recorded attempt of a run, oldest first.
+func (a *apiServer) handleRun(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	attempts := a.r.history.Attempts(id)
+	if len(attempts) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("run %q not found; it may still be queued or running", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, attempts)
+}
+
+func (a *apiServer) handleCancelRun(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	job, err := a.r.scheduler.Cancel(id)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"job": job, "run_id": id})
+}
+
+func (a *apiServer) handleHistory(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	q := HistoryQuery{
+		Job:    params.Get("job"),
+		Status: params.Get("status"),
+	}
+	var err error
+	if q.Since, err = timeParam(params.Get("since")); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("since: %w", err))
+		return
+	}
+	if q.Until, err = timeParam(params.Get("until")); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("until: %w", err))
+		return
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("limit must be a positive integer, got %q", v))
+			return
+		}
+		q.Limit = n
+	}
+	writeJSON(w, http.StatusOK, a.r.history.Query(q))
+}
+
+func timeParam(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("want an RFC 3339 time, got %q", v)
+	}
+	return &t, nil
+}
+
+// applyAPI starts, stops or restarts the HTTP API to match opts. Failures
+// are logged; the runner keeps working without the API.
+func (r *runner) applyAPI(opts APIOptions) {
+	r.apiMu.Lock()
+	defer r.apiMu.Unlock()
+
+	if r.api != nil {
+		if r.api.opts == opts {
+			return
+		}
+		if err := r.api.Close(); err != nil {
+			r.log.Error("Failed to stop HTTP API", "error", err)
+		}
+		r.api = nil
+		r.log.Info("HTTP API stopped")
+	}
+	if !opts.Enabled {
+		return
+	}
+
+	api, err := r.startAPI(opts)
+	if err != nil {
+		r.log.Error("Failed to start HTTP API", "listen", opts.Listen, "error", err)
+		return
+	}
+	r.api = api
+	r.log.Info("HTTP API listening", "listen", opts.Listen)
+}
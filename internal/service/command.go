@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+const (
+	jobTypeCommand = "command"
+
+	defaultKillGrace     = 10 * time.Second
+	defaultMaxOutputKB   = 64
+	commandEnvJobName    = "GOTOY_JOB_NAME"
+	commandEnvRunID      = "GOTOY_RUN_ID"
+	commandEnvRunTrigger = "GOTOY_RUN_TRIGGER"
+
+	// Set for runs started by a filesystem watch.
+	commandEnvTriggerPath  = "GOTOY_TRIGGER_PATH"
+	commandEnvTriggerPaths = "GOTOY_TRIGGER_PATHS"
+	commandEnvTriggerEvent = "GOTOY_TRIGGER_EVENT"
+
+	// minPipeWaitDelay is the least time Wait gives the pipes to close after
+	// the process exits, however short the kill grace.
+	minPipeWaitDelay = time.Second
+)
+
+// commandSpec describes how to launch a command job. Exactly one of shell
+// and argv is set.
+type commandSpec struct {
+	shell     string
+	argv      []string
+	dir       string
+	env       []string
+	timeout   time.Duration
+	killGrace time.Duration
+	maxOutput int
+	limits    ResourceLimits
+}
+
+func commandSpecFromConfig(jc JobConfig) commandSpec {
+	return commandSpec{
+		shell:     jc.Command,
+		argv:      jc.Exec,
+		dir:       jc.WorkDir,
+		env:       jc.Env,
+		timeout:   jc.Timeout,
+		killGrace: jc.KillGrace,
+		maxOutput: jc.MaxOutputKB * 1024,
+		limits:    jc.Limits,
+	}
+}
+
+func (s commandSpec) args() []string {
+	if s.shell != "" {
+		if runtime.GOOS == "windows" {
+			return []string{"cmd", "/C", s.shell}
+		}
+		return []string{"/bin/sh", "-c", s.shell}
+	}
+	return s.argv
+}
+
+// newCmd builds the command for a run of job, wrapped in whatever applies
+// its resource limits.
+func (s commandSpec) newCmd(job string) (*exec.Cmd, error) {
+	argv, err := s.limits.wrap(job, s.args())
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(argv[0], argv[1:]...), nil
+}
+
+// runCommand executes spec and fills in the exit code and captured output of
+// rec. Output is also published to live, if set, as it arrives. On timeout or
+// cancellation the process group gets SIGTERM, then SIGKILL once the grace
+// period has elapsed.
+func runCommand(ctx context.Context, spec commandSpec, rec *RunRecord, live *outputHub) error {
+	runCtx := ctx
+	if spec.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, spec.timeout)
+		defer cancel()
+	}
+
+	stdout := newTailBuffer(spec.maxOutput)
+	stderr := newTailBuffer(spec.maxOutput)
+
+	cmd, err := spec.newCmd(rec.Job)
+	if err != nil {
+		rec.Status = runStatusFailed
+		return err
+	}
+	cmd.Dir = spec.dir
+	cmd.Env = append(os.Environ(), spec.env...)
+	cmd.Env = append(cmd.Env,
+		commandEnvJobName+"="+rec.Job,
+		commandEnvRunID+"="+rec.ID,
+		commandEnvRunTrigger+"="+rec.Trigger,
+	)
+	if n := len(rec.TriggerPaths); n > 0 {
+		cmd.Env = append(cmd.Env,
+			commandEnvTriggerPath+"="+rec.TriggerPaths[n-1],
+			commandEnvTriggerPaths+"="+strings.Join(rec.TriggerPaths, "\n"),
+			commandEnvTriggerEvent+"="+rec.TriggerEvent,
+		)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if live != nil {
+		liveOut, liveErr := live.started(rec)
+		cmd.Stdout = io.MultiWriter(stdout, liveOut)
+		cmd.Stderr = io.MultiWriter(stderr, liveErr)
+	}
+	// Do not let a backgrounded grandchild holding our pipes block Wait forever.
+	cmd.WaitDelay = max(spec.killGrace, minPipeWaitDelay)
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		rec.Status = runStatusFailed
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	killed := false
+	select {
+	case err = <-waitErr:
+	case <-runCtx.Done():
+		_ = terminateProcessGroup(cmd.Process)
+		grace := time.NewTimer(spec.killGrace)
+		select {
+		case err = <-waitErr:
+			grace.Stop()
+		case <-grace.C:
+			_ = killProcessGroup(cmd.Process)
+			killed = true
+			err = <-waitErr
+		}
+	}
+
+	// Captured output is kept in the run history, so hide secrets in it.
+	rec.Stdout, rec.Stderr = shared.Redact(stdout.String()), shared.Redact(stderr.String())
+	rec.OutputTruncated = stdout.Truncated() || stderr.Truncated()
+	if cmd.ProcessState != nil {
+		code := cmd.ProcessState.ExitCode()
+		rec.ExitCode = &code
+	}
+
+	switch {
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		rec.Status = runStatusTimeout
+		return fmt.Errorf("timed out after %s", spec.timeout)
+	case ctx.Err() != nil:
+		rec.Status = runStatusCancelled
+		return fmt.Errorf("cancelled: %w", ctx.Err())
+	case err != nil:
+		if limit := spec.limits.exceeded(cmd.ProcessState, killed); limit != "" {
+			rec.Status = runStatusFailed
+			rec.LimitExceeded = limit
+			return fmt.Errorf("%s: %w", spec.limits.limitDescription(limit), err)
+		}
+		rec.Status = runStatusFailed
+		return err
+	}
+	rec.Status = runStatusSuccess
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu        sync.Mutex
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		n := copy(b.buf, b.buf[over:])
+		b.buf = b.buf[:n]
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+func (b *tailBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated
+}
+
+// splitArgs splits an exec line into arguments. Single quotes preserve
+// everything literally; double quotes allow backslash escapes; outside quotes
+// whitespace separates arguments and a backslash escapes the next character.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range s {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
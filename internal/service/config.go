@@ -0,0 +1,633 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+const (
+	runnerConfigFileName   = "runner.conf"
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// Config is the declarative runner configuration read from runner.conf.
+type Config struct {
+	Path      string
+	Runner    RunnerOptions
+	Log       LogOptions
+	History   HistoryOptions
+	API       APIOptions
+	Metrics   MetricsOptions
+	Notify    NotifyOptions
+	Channels  []ChannelConfig
+	Jobs      []JobConfig
+	Workflows []WorkflowConfig
+}
+
+// RunnerOptions holds process-wide runner settings ([runner] section).
+type RunnerOptions struct {
+	// ShutdownTimeout bounds how long in-flight jobs may take to return
+	// after a shutdown signal.
+	ShutdownTimeout time.Duration
+	// Workers is the number of jobs that may run at once; QueueSize bounds
+	// how many due runs may wait for a free worker. Both apply on restart.
+	Workers   int
+	QueueSize int
+}
+
+// LogOptions controls the level, format and rotation of the runner log
+// file ([log] section).
+type LogOptions struct {
+	Level  shared.Level
+	Format shared.LogFormat
+
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+
+	Forward LogForwardOptions
+}
+
+// LogForwardOptions selects the local log daemons that get a copy of every
+// log entry besides the log file.
+type LogForwardOptions struct {
+	Syslog         bool
+	SyslogSocket   string
+	SyslogFacility string
+	Journal        bool
+	JournalSocket  string
+}
+
+// rotation returns the options that concern rotation only.
+func (o LogOptions) rotation() LogOptions {
+	return LogOptions{MaxSizeMB: o.MaxSizeMB, MaxBackups: o.MaxBackups, MaxAgeDays: o.MaxAgeDays, Compress: o.Compress}
+}
+
+// JobConfig is one [job "<name>"] section.
+type JobConfig struct {
+	Name     string
+	Type     string
+	Schedule string
+	Enabled  bool
+	Line     int
+	Retry    RetryPolicy
+	// Timing sets the time zone, jitter and blackout windows of the
+	// schedule.
+	Timing ScheduleOptions
+
+	// Overlap is the policy for runs that come due while the job is busy;
+	// MaxQueued bounds the waiting runs under the queue policy.
+	Overlap   string
+	MaxQueued int
+
+	// Misfire handles scheduled runs missed while the runner was stopped or
+	// the machine was asleep.
+	Misfire MisfirePolicy
+
+	// Watch triggers the job on filesystem changes, in addition to or
+	// instead of its schedule.
+	Watch WatchConfig
+
+	// Notify routes failure and recovery notifications of the job.
+	Notify NotifyRoute
+
+	// DependsOn lists upstream jobs that must succeed first when the job
+	// runs as part of a workflow.
+	DependsOn []string
+
+	// Command job settings. Exactly one of Command (a shell string) and
+	// Exec (an argument vector) is set.
+	Command     string
+	Exec        []string
+	WorkDir     string
+	Env         []string
+	Timeout     time.Duration
+	KillGrace   time.Duration
+	MaxOutputKB int
+	Limits      ResourceLimits
+
+	// Task job settings: the registered task or plugin and its "name=value"
+	// parameters. Jobs whose type is a task name run that task.
+	Task   string
+	Params []string
+
+	schedule      Schedule
+	dependsOnLine int
+}
+
+const jobTypeHeartbeat = "heartbeat"
+
+// defaultConfigContent is written to the runner directory on first start so
+// users have a documented starting point. It mirrors DefaultConfig.
+const defaultConfigContent = `# go-toy task runner configuration.
+# Changes are picked up on restart or when the runner receives SIGHUP.
+
+[runner]
+shutdown_timeout = 30s
+# At most this many jobs run at once; further due runs wait in a queue of
+# queue_size and are skipped when it is full. Changes apply on restart.
+workers = 4
+queue_size = 64
+
+# Optional HTTP API for local tools, on a loopback address or unix:<path>.
+# Requests need "Authorization: Bearer <token>" with the token from api.token.
+[api]
+enabled = false
+listen = 127.0.0.1:8765
+
+# Prometheus metrics at http://<listen>/metrics. The endpoint needs no token;
+# only listen on other interfaces if job names are not sensitive.
+[metrics]
+enabled = false
+listen = 127.0.0.1:9765
+
+# level is debug, info, warn or error; format is text, logfmt or json. The
+# level can also be changed while the runner runs: go-toy log-level debug.
+# Entries can also be forwarded to the local syslog daemon (RFC 5424) and to
+# the systemd journal, with job and run IDs as structured fields.
+[log]
+level = info
+format = text
+max_size_mb = 5
+max_backups = 3
+max_age_days = 28
+compress = true
+syslog = false
+syslog_facility = daemon
+journal = false
+
+# Every job run is recorded in history.jsonl. 0 disables a limit; max_age
+# counts from the end of a run.
+[history]
+max_runs = 5000
+max_age = 720h
+max_output_kb = 8
+
+# Notifications about failing jobs. Each channel sends at most rate_limit
+# notifications per hour, and an identical notification is sent once per
+# dedup window. repeat re-sends the failure while a job keeps failing.
+[notify]
+# channels = desktop
+rate_limit = 20
+dedup = 10m
+# repeat = 6h
+
+# [channel "ops-mail"]
+# type = smtp
+# server = smtp.example.com:587
+# tls = starttls
+# username = runner
+# password = ${secret:smtp-password}
+# from = runner@example.com
+# to = ops@example.com
+#
+# [channel "chat"]
+# type = webhook
+# url = https://hooks.example.com/go-toy
+# header = Authorization: Bearer ${secret:hook-token}
+# timeout = 10s
+#
+# [channel "desktop"]
+# type = desktop
+
+[job "heartbeat"]
+type = heartbeat
+schedule = @every 10s
+# param = message=Staying alive
+
+# Task jobs run a task built into the runner or provided by a plugin in the
+# plugins directory next to this file; go-toy job-types lists them and their
+# parameters. Every task is also a job type of its own, so heartbeat jobs
+# are task jobs running the heartbeat task.
+# [job "beat"]
+# type = task
+# task = heartbeat
+# schedule = @hourly
+# param = message=Still here
+# timeout = 1m
+
+# Command jobs run a shell string (command) or an argument vector (exec).
+# [job "disk-usage"]
+# type = command
+# schedule = 0 */15 * * * *
+# command = df -h / | tail -1
+# workdir = /tmp
+# env = LC_ALL=C
+# env = DB_PASSWORD=${secret:db-password}
+# timeout = 1m
+# kill_grace = 10s
+# max_output_kb = 64
+#
+# Command jobs can be limited in CPU time, address space and open files, and
+# run at a lower CPU or I/O priority. Under systemd, memory_max and cpu_quota
+# put each run in a transient scope with MemoryMax= and CPUQuota=.
+# limit_cpu = 10m
+# limit_address_space_mb = 2048
+# limit_open_files = 1024
+# nice = 10
+# ionice = idle
+# memory_max = 512M
+# cpu_quota = 50%
+#
+# Any job can retry failed runs with exponential backoff.
+# retry_attempts = 3
+# retry_initial_backoff = 5s
+# retry_max_backoff = 5m
+# retry_multiplier = 2
+# retry_jitter = 0.2
+# retry_exit_codes = 75, 111
+#
+# Failure notifications go to the [notify] channels unless notify names
+# others (or none). notify_after sets how many failed runs in a row count as
+# a failure; a success afterwards sends a recovery.
+# notify = ops-mail
+# notify_on = failure, recovery
+# notify_after = 3
+#
+# Jobs can also be triggered by files appearing or changing. The paths are
+# passed in GOTOY_TRIGGER_PATH (last) and GOTOY_TRIGGER_PATHS (all).
+# watch = /home/me/inbox
+# watch_pattern = *.csv, *.tsv
+# watch_recursive = true
+# watch_events = create, modify
+# watch_debounce = 1s
+#
+# When a job comes due while its previous run is still going, overlap
+# decides: skip the new run (default), allow both, queue it (up to
+# max_queued runs), or cancel the previous run.
+# overlap = queue
+# max_queued = 1
+#
+# Scheduled runs missed while the runner was stopped or the machine was
+# asleep are skipped (default), made up once, or all made up, one after
+# another, up to misfire_limit runs.
+# misfire = once
+# misfire_limit = 10
+#
+# Schedules follow the system time zone unless timezone names another one.
+# jitter delays each scheduled run by a random amount, and no scheduled run
+# starts inside a blackout window (repeatable; optional weekdays, and a range
+# that ends before it starts runs past midnight).
+# timezone = Europe/Paris
+# jitter = 30s
+# blackout = Mon-Fri 09:00-17:00
+
+# Workflows run a set of jobs as a dependency graph. A job starts once every
+# job it depends_on has succeeded; jobs downstream of a failure are skipped.
+# [workflow "etl"]
+# schedule = @daily
+# jobs = fetch, transform, archive
+#
+# [job "transform"]
+# type = command
+# exec = ./transform.sh
+# depends_on = fetch
+`
+
+// DefaultConfig returns the configuration used when no runner.conf exists.
+func DefaultConfig() *Config {
+	return &Config{
+		Runner: RunnerOptions{
+			ShutdownTimeout: defaultShutdownTimeout,
+			Workers:         defaultWorkers,
+			QueueSize:       defaultQueueSize,
+		},
+		Log: LogOptions{
+			Level:      shared.LevelInfo,
+			Format:     shared.LogFormatText,
+			MaxSizeMB:  logMaxSizeMB,
+			MaxBackups: logMaxBackups,
+			MaxAgeDays: logMaxAgeDays,
+			Compress:   true,
+			Forward: LogForwardOptions{
+				SyslogSocket:   shared.DefaultSyslogSocket,
+				SyslogFacility: defaultSyslogFacility,
+				JournalSocket:  shared.DefaultJournalSocket,
+			},
+		},
+		API: APIOptions{
+			Listen: defaultAPIListen,
+		},
+		Metrics: MetricsOptions{
+			Listen: defaultMetricsListen,
+		},
+		Notify: NotifyOptions{
+			RateLimit: defaultNotifyRateLimit,
+			Dedup:     defaultNotifyDedup,
+		},
+		History: HistoryOptions{
+			MaxRuns:     defaultHistoryMaxRuns,
+			MaxAge:      defaultHistoryMaxAge,
+			MaxOutputKB: defaultHistoryMaxOutputKB,
+		},
+		Jobs: []JobConfig{{
+			Name:     heartbeatJobName,
+			Type:     jobTypeHeartbeat,
+			Task:     heartbeatTaskName,
+			Schedule: fmt.Sprintf("@every %s", heartbeatInterval),
+			Enabled:  true,
+			Overlap:  overlapSkip,
+			Misfire:  MisfirePolicy{Mode: misfireSkip, Limit: defaultMisfireLimit},
+			schedule: everySchedule{interval: heartbeatInterval},
+		}},
+	}
+}
+
+// LoadConfig reads and validates the configuration at path. A missing file
+// yields DefaultConfig. Job types of plugins are checked against the last
+// scanPlugins of this process; plugins are not started.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		cfg := DefaultConfig()
+		cfg.Path = path
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+	return parseConfig(path, f)
+}
+
+func parseConfig(path string, r io.Reader) (*Config, error) {
+	sections, err := parseConf(path, r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	cfg.Path = path
+	cfg.Jobs = nil
+
+	var errs []error
+	seen := make(map[string]int)
+	jobLines := make(map[string]int)
+	for _, sec := range sections {
+		key := sec.kind + "\x00" + sec.name
+		if first, dup := seen[key]; dup && sec.kind != "job" {
+			errs = append(errs, &ConfigError{Path: path, Line: sec.line, Msg: fmt.Sprintf("duplicate section [%s] (first on line %d)", sec.kind, first)})
+			continue
+		}
+		seen[key] = sec.line
+
+		d := newSectionDecoder(path, sec)
+		switch sec.kind {
+		case "runner":
+			if sec.name != "" {
+				d.errorf(sec.line, "[runner] section does not take a name")
+			}
+			d.duration("shutdown_timeout", &cfg.Runner.ShutdownTimeout)
+			d.integer("workers", &cfg.Runner.Workers, 1)
+			d.integer("queue_size", &cfg.Runner.QueueSize, 1)
+		case "log":
+			if sec.name != "" {
+				d.errorf(sec.line, "[log] section does not take a name")
+			}
+			var level, format string
+			if line, ok := d.str("level", &level); ok {
+				if cfg.Log.Level, err = shared.ParseLevel(level); err != nil {
+					d.errorf(line, "%v", err)
+				}
+			}
+			d.choice("format", &format, string(shared.LogFormatText), string(shared.LogFormatLogfmt), string(shared.LogFormatJSON))
+			if format != "" {
+				cfg.Log.Format = shared.LogFormat(format)
+			}
+			d.integer("max_size_mb", &cfg.Log.MaxSizeMB, 1)
+			d.integer("max_backups", &cfg.Log.MaxBackups, 0)
+			d.integer("max_age_days", &cfg.Log.MaxAgeDays, 0)
+			d.boolean("compress", &cfg.Log.Compress)
+			fwd := &cfg.Log.Forward
+			d.boolean("syslog", &fwd.Syslog)
+			d.absPath("syslog_socket", &fwd.SyslogSocket)
+			d.choice("syslog_facility", &fwd.SyslogFacility, shared.SyslogFacilities()...)
+			d.boolean("journal", &fwd.Journal)
+			d.absPath("journal_socket", &fwd.JournalSocket)
+		case "api":
+			if sec.name != "" {
+				d.errorf(sec.line, "[api] section does not take a name")
+			}
+			d.boolean("enabled", &cfg.API.Enabled)
+			if line, ok := d.str("listen", &cfg.API.Listen); ok {
+				if _, _, err := parseAPIListen(cfg.API.Listen); err != nil {
+					d.errorf(line, "invalid listen: %v", err)
+				}
+			}
+		case "metrics":
+			if sec.name != "" {
+				d.errorf(sec.line, "[metrics] section does not take a name")
+			}
+			d.boolean("enabled", &cfg.Metrics.Enabled)
+			if line, ok := d.str("listen", &cfg.Metrics.Listen); ok {
+				if err := checkMetricsListen(cfg.Metrics.Listen); err != nil {
+					d.errorf(line, "invalid listen: %v", err)
+				}
+			}
+		case "history":
+			if sec.name != "" {
+				d.errorf(sec.line, "[history] section does not take a name")
+			}
+			d.integer("max_runs", &cfg.History.MaxRuns, 0)
+			d.duration("max_age", &cfg.History.MaxAge)
+			d.integer("max_output_kb", &cfg.History.MaxOutputKB, 0)
+		case "notify":
+			if sec.name != "" {
+				d.errorf(sec.line, "[notify] section does not take a name")
+			}
+			decodeNotifyOptions(d, &cfg.Notify)
+		case "channel":
+			if sec.name == "" {
+				errs = append(errs, &ConfigError{Path: path, Line: sec.line, Msg: `channel section needs a name: [channel "<name>"]`})
+				continue
+			}
+			if ch, ok := decodeChannel(d); ok {
+				cfg.Channels = append(cfg.Channels, ch)
+			}
+		case "job":
+			// Keys of an unnamed or duplicate job are not checked further.
+			if sec.name == "" {
+				errs = append(errs, &ConfigError{Path: path, Line: sec.line, Msg: `job section needs a name: [job "<name>"]`})
+				continue
+			}
+			if first, dup := jobLines[sec.name]; dup {
+				errs = append(errs, &ConfigError{Path: path, Line: sec.line, Msg: fmt.Sprintf("duplicate job %q (first defined on line %d)", sec.name, first)})
+				continue
+			}
+			jobLines[sec.name] = sec.line
+			if job, ok := decodeJob(d); ok {
+				cfg.Jobs = append(cfg.Jobs, job)
+			}
+		case "workflow":
+			if sec.name == "" {
+				errs = append(errs, &ConfigError{Path: path, Line: sec.line, Msg: `workflow section needs a name: [workflow "<name>"]`})
+				continue
+			}
+			if wf, ok := decodeWorkflow(d); ok {
+				cfg.Workflows = append(cfg.Workflows, wf)
+			}
+		default:
+			errs = append(errs, &ConfigError{Path: path, Line: sec.line, Msg: fmt.Sprintf("unknown section [%s]", sec.kind)})
+			continue
+		}
+		errs = append(errs, d.finish()...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if errs := validateWorkflows(cfg); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if errs := validateNotify(cfg); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+func decodeJob(d *sectionDecoder) (JobConfig, bool) {
+	job := JobConfig{
+		Name:      d.sec.name,
+		Enabled:   true,
+		Line:      d.sec.line,
+		Overlap:   overlapSkip,
+		MaxQueued: 1,
+	}
+	before := len(d.errs)
+
+	typeLine := d.required("type", &job.Type)
+	switch job.Type {
+	case "":
+	case jobTypeTask:
+		decodeTaskJob(d, &job)
+	case jobTypeCommand:
+		decodeCommandJob(d, &job)
+	default:
+		// Every task, built in or provided by a plugin, is a job type of its
+		// own: type = heartbeat is short for type = task, task = heartbeat.
+		if _, ok := lookupTask(job.Type); ok {
+			job.Task = job.Type
+			decodeTaskJob(d, &job)
+		} else if len(PluginErrors()) > 0 {
+			d.errorf(typeLine, "unknown job type %q (some plugins failed to load; run go-toy job-types for details)", job.Type)
+		} else {
+			d.errorf(typeLine, "unknown job type %q", job.Type)
+		}
+	}
+
+	// Jobs that only run as part of a workflow or on filesystem events need
+	// no schedule of their own.
+	scheduleLine, _ := d.str("schedule", &job.Schedule)
+	if job.Schedule != "" {
+		schedule, err := ParseSchedule(job.Schedule)
+		if err != nil {
+			d.errorf(scheduleLine, "invalid schedule: %v", err)
+		}
+		job.schedule = schedule
+	}
+	job.schedule = decodeScheduleOptions(d, &job.Timing, job.schedule)
+
+	d.boolean("enabled", &job.Enabled)
+	d.choice("overlap", &job.Overlap, overlapSkip, overlapAllow, overlapQueue, overlapCancel)
+	d.integer("max_queued", &job.MaxQueued, 1)
+	decodeRetry(d, &job.Retry)
+	decodeMisfire(d, &job.Misfire)
+	decodeWatch(d, &job.Watch)
+	decodeNotifyRoute(d, &job.Notify)
+	job.dependsOnLine = d.nameList("depends_on", &job.DependsOn)
+
+	return job, len(d.errs) == before
+}
+
+func decodeRetry(d *sectionDecoder, p *RetryPolicy) {
+	*p = RetryPolicy{
+		MaxAttempts:    1,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Multiplier:     defaultRetryMultiplier,
+		Jitter:         defaultRetryJitter,
+	}
+	d.integer("retry_attempts", &p.MaxAttempts, 1)
+	d.duration("retry_initial_backoff", &p.InitialBackoff)
+	d.duration("retry_max_backoff", &p.MaxBackoff)
+	d.float("retry_multiplier", &p.Multiplier, 1, 100)
+	d.float("retry_jitter", &p.Jitter, 0, 1)
+	d.intList("retry_exit_codes", &p.ExitCodes)
+}
+
+func decodeCommandJob(d *sectionDecoder, job *JobConfig) {
+	job.KillGrace = defaultKillGrace
+	job.MaxOutputKB = defaultMaxOutputKB
+
+	_, hasCommand := d.str("command", &job.Command)
+	var execLine string
+	if line, ok := d.str("exec", &execLine); ok {
+		args, err := splitArgs(execLine)
+		switch {
+		case err != nil:
+			d.errorf(line, "invalid exec: %v", err)
+		case len(args) == 0:
+			d.errorf(line, "exec must name a program")
+		default:
+			job.Exec = args
+		}
+		if hasCommand {
+			d.errorf(line, "set either command or exec, not both")
+		}
+	} else if !hasCommand || job.Command == "" {
+		d.errorf(d.sec.line, "%s: command jobs need a command or exec key", d.describe())
+	}
+
+	d.str("workdir", &job.WorkDir)
+	for _, e := range d.all("env") {
+		name, _, ok := strings.Cut(e.value, "=")
+		if !ok || name == "" {
+			d.errorf(e.line, "env must be NAME=value, got %q", e.value)
+			continue
+		}
+		if err := checkSecretRefs(e.value); err != nil {
+			d.errorf(e.line, "env %s: %v", name, err)
+			continue
+		}
+		job.Env = append(job.Env, e.value)
+	}
+	d.duration("timeout", &job.Timeout)
+	d.duration("kill_grace", &job.KillGrace)
+	d.integer("max_output_kb", &job.MaxOutputKB, 1)
+	decodeLimits(d, &job.Limits)
+}
+
+// fingerprint identifies a job definition so reloads can tell unchanged
+// jobs apart from edited ones.
+func (j JobConfig) fingerprint() string {
+	j.Line = 0
+	j.schedule = nil
+	j.dependsOnLine = 0
+	// Notification routing does not affect how the job runs.
+	j.Notify = NotifyRoute{}
+	return fmt.Sprintf("%+v", j)
+}
+
+// writeDefaultConfig creates runner.conf with the documented defaults unless
+// a file already exists.
+func writeDefaultConfig(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(defaultConfigContent); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The runner configuration uses a small INI dialect, close to systemd unit
+// files:
+//
+//	# comment
+//	[log]
+//	max_size_mb = 5
+//
+//	[job "backup"]
+//	schedule = 0 3 * * *
+//
+// Every section and key remembers its line so validation errors can point
+// at the offending line.
+
+// ConfigError is a configuration problem located at a line of the file.
+type ConfigError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+type confEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+type confSection struct {
+	kind    string
+	name    string
+	line    int
+	entries []confEntry
+}
+
+func parseConf(path string, r io.Reader) ([]*confSection, error) {
+	var (
+		sections  []*confSection
+		current   *confSection
+		badHeader bool
+		errs      []error
+		lineNo    int
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			sec, err := parseConfHeader(line)
+			if err != nil {
+				errs = append(errs, &ConfigError{Path: path, Line: lineNo, Msg: err.Error()})
+				current, badHeader = nil, true
+				continue
+			}
+			sec.line = lineNo
+			sections = append(sections, sec)
+			current, badHeader = sec, false
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			errs = append(errs, &ConfigError{Path: path, Line: lineNo, Msg: fmt.Sprintf("expected key = value, got %q", line)})
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if key == "" {
+			errs = append(errs, &ConfigError{Path: path, Line: lineNo, Msg: "missing key before '='"})
+			continue
+		}
+		// A value that is entirely one quoted string is unquoted; anything
+		// else (e.g. an exec line mixing quoted and bare words) is kept as is.
+		if strings.HasPrefix(value, `"`) {
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		if current == nil {
+			// Keys under an invalid header were already reported with it.
+			if !badHeader {
+				errs = append(errs, &ConfigError{Path: path, Line: lineNo, Msg: fmt.Sprintf("key %q outside of any section", key)})
+			}
+			continue
+		}
+		current.entries = append(current.entries, confEntry{key: key, value: value, line: lineNo})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return sections, nil
+}
+
+func parseConfHeader(line string) (*confSection, error) {
+	if !strings.HasSuffix(line, "]") {
+		return nil, fmt.Errorf("unterminated section header %q", line)
+	}
+	inner := strings.TrimSpace(line[1 : len(line)-1])
+	kind, rest, _ := strings.Cut(inner, " ")
+	sec := &confSection{kind: strings.ToLower(kind)}
+	if sec.kind == "" {
+		return nil, fmt.Errorf("empty section header")
+	}
+
+	rest = strings.TrimSpace(rest)
+	if rest != "" {
+		name, err := strconv.Unquote(rest)
+		if err != nil {
+			return nil, fmt.Errorf("section name must be quoted, got %s", rest)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty section name")
+		}
+		sec.name = name
+	}
+	return sec, nil
+}
+
+// sectionDecoder reads typed values out of a section and reports anything
+// malformed, missing or unknown as ConfigErrors.
+type sectionDecoder struct {
+	path string
+	sec  *confSection
+	used map[string]bool
+	errs []error
+}
+
+func newSectionDecoder(path string, sec *confSection) *sectionDecoder {
+	return &sectionDecoder{path: path, sec: sec, used: make(map[string]bool)}
+}
+
+func (d *sectionDecoder) errorf(line int, format string, args ...any) {
+	d.errs = append(d.errs, &ConfigError{Path: d.path, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// lookup returns the last value for key, and whether it was present.
+func (d *sectionDecoder) lookup(key string) (confEntry, bool) {
+	d.used[key] = true
+	var found confEntry
+	ok := false
+	for _, e := range d.sec.entries {
+		if e.key == key {
+			if ok {
+				d.errorf(e.line, "duplicate key %q (first set on line %d)", key, found.line)
+			}
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// all returns every entry for a repeatable key, in file order.
+func (d *sectionDecoder) all(key string) []confEntry {
+	d.used[key] = true
+	var out []confEntry
+	for _, e := range d.sec.entries {
+		if e.key == key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (d *sectionDecoder) str(key string, dst *string) (int, bool) {
+	e, ok := d.lookup(key)
+	if ok {
+		*dst = e.value
+	}
+	return e.line, ok
+}
+
+func (d *sectionDecoder) required(key string, dst *string) int {
+	line, ok := d.str(key, dst)
+	if !ok || *dst == "" {
+		d.errorf(d.sec.line, "%s: missing required key %q", d.describe(), key)
+		return d.sec.line
+	}
+	return line
+}
+
+// choice reads a value that must be one of choices.
+func (d *sectionDecoder) choice(key string, dst *string, choices ...string) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	if !slices.Contains(choices, e.value) {
+		d.errorf(e.line, "%s must be one of %s, got %q", key, strings.Join(choices, ", "), e.value)
+		return
+	}
+	*dst = e.value
+}
+
+func (d *sectionDecoder) integer(key string, dst *int, min int) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(e.value)
+	if err != nil {
+		d.errorf(e.line, "%s must be an integer, got %q", key, e.value)
+		return
+	}
+	if n < min {
+		d.errorf(e.line, "%s must be at least %d, got %d", key, min, n)
+		return
+	}
+	*dst = n
+}
+
+func (d *sectionDecoder) float(key string, dst *float64, min, max float64) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	f, err := strconv.ParseFloat(e.value, 64)
+	if err != nil {
+		d.errorf(e.line, "%s must be a number, got %q", key, e.value)
+		return
+	}
+	if f < min || f > max {
+		d.errorf(e.line, "%s must be between %g and %g, got %g", key, min, max, f)
+		return
+	}
+	*dst = f
+}
+
+func (d *sectionDecoder) intList(key string, dst *[]int) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	var out []int
+	for _, part := range strings.Split(e.value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			d.errorf(e.line, "%s must be a comma-separated list of integers, got %q", key, e.value)
+			return
+		}
+		out = append(out, n)
+	}
+	*dst = out
+}
+
+// nameList reads a comma-separated list of names and returns its line.
+func (d *sectionDecoder) nameList(key string, dst *[]string) int {
+	e, ok := d.lookup(key)
+	if !ok {
+		return 0
+	}
+	var out []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(e.value, ",") {
+		name := strings.TrimSpace(part)
+		if name == "" {
+			d.errorf(e.line, "%s contains an empty name", key)
+			return e.line
+		}
+		if seen[name] {
+			d.errorf(e.line, "%s lists %q twice", key, name)
+			return e.line
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	*dst = out
+	return e.line
+}
+
+func (d *sectionDecoder) boolean(key string, dst *bool) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	switch strings.ToLower(e.value) {
+	case "true", "yes", "on", "1":
+		*dst = true
+	case "false", "no", "off", "0":
+		*dst = false
+	default:
+		d.errorf(e.line, "%s must be a boolean, got %q", key, e.value)
+	}
+}
+
+func (d *sectionDecoder) duration(key string, dst *time.Duration) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	v, err := time.ParseDuration(e.value)
+	if err != nil {
+		d.errorf(e.line, "%s must be a duration like 30s or 5m, got %q", key, e.value)
+		return
+	}
+	if v < 0 {
+		d.errorf(e.line, "%s must not be negative", key)
+		return
+	}
+	*dst = v
+}
+
+func (d *sectionDecoder) absPath(key string, dst *string) {
+	e, ok := d.lookup(key)
+	if !ok {
+		return
+	}
+	if !filepath.IsAbs(e.value) {
+		d.errorf(e.line, "%s must be an absolute path, got %q", key, e.value)
+		return
+	}
+	*dst = e.value
+}
+
+func (d *sectionDecoder) describe() string {
+	if d.sec.name != "" {
+		return fmt.Sprintf("[%s %q]", d.sec.kind, d.sec.name)
+	}
+	return fmt.Sprintf("[%s]", d.sec.kind)
+}
+
+// finish reports unknown keys and returns all collected errors.
+func (d *sectionDecoder) finish() []error {
+	for _, e := range d.sec.entries {
+		if !d.used[e.key] {
+			d.errorf(e.line, "unknown key %q in %s", e.key, d.describe())
+		}
+	}
+	return d.errs
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConf(t *testing.T) {
+	const conf = `# comment
+; also a comment
+
+[log]
+Max_Size_MB = 5
+compress=yes
+
+[job "backup"]
+schedule = 0 3 * * *
+command = "tar czf /tmp/home.tgz $HOME"
+exec = sh -c "echo hi"
+`
+	sections, err := parseConf("runner.conf", strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(sections))
+	}
+	log, job := sections[0], sections[1]
+	if log.kind != "log" || log.name != "" || log.line != 4 {
+		t.Errorf("log section = %+v", log)
+	}
+	if got := log.entries[0]; got != (confEntry{key: "max_size_mb", value: "5", line: 5}) {
+		t.Errorf("keys are not lower-cased and trimmed: %+v", got)
+	}
+	if job.kind != "job" || job.name != "backup" || job.line != 8 {
+		t.Errorf("job section = %+v", job)
+	}
+	want := []confEntry{
+		{key: "schedule", value: "0 3 * * *", line: 9},
+		{key: "command", value: "tar czf /tmp/home.tgz $HOME", line: 10},
+		// Only values that are one quoted string are unquoted.
+		{key: "exec", value: `sh -c "echo hi"`, line: 11},
+	}
+	for i, e := range want {
+		if job.entries[i] != e {
+			t.Errorf("entry %d = %+v, want %+v", i, job.entries[i], e)
+		}
+	}
+}
+
+func TestParseConfErrors(t *testing.T) {
+	tests := []struct {
+		conf string
+		want []string
+	}{
+		{"key = value\n", []string{`runner.conf:1: key "key" outside of any section`}},
+		{"[log]\nnot a pair\n", []string{`runner.conf:2: expected key = value, got "not a pair"`}},
+		{"[log]\n= 5\n", []string{"runner.conf:2: missing key before '='"}},
+		{"[log\n", []string{`runner.conf:1: unterminated section header "[log"`}},
+		{"[]\n", []string{"runner.conf:1: empty section header"}},
+		{"[job backup]\n", []string{"runner.conf:1: section name must be quoted, got backup"}},
+		{`[job ""]` + "\n", []string{"runner.conf:1: empty section name"}},
+		// Keys under a bad header are not reported again.
+		{"[job backup]\nschedule = @daily\n[log\n", []string{
+			"runner.conf:1: section name must be quoted",
+			"runner.conf:3: unterminated section header",
+		}},
+	}
+	for _, tt := range tests {
+		_, err := parseConf("runner.conf", strings.NewReader(tt.conf))
+		if err == nil {
+			t.Errorf("parseConf(%q) succeeded", tt.conf)
+			continue
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(tt.want) {
+			t.Errorf("parseConf(%q) errors = %q, want %d", tt.conf, lines, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.HasPrefix(lines[i], want) {
+				t.Errorf("parseConf(%q) error %d = %q, want %q", tt.conf, i, lines[i], want)
+			}
+		}
+	}
+}
+
+func decodeTestSection(t *testing.T, conf string) *sectionDecoder {
+	t.Helper()
+	sections, err := parseConf("runner.conf", strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSectionDecoder("runner.conf", sections[0])
+}
+
+func TestSectionDecoder(t *testing.T) {
+	d := decodeTestSection(t, `[test "x"]
+n = 3
+f = 0.5
+b = off
+dur = 90s
+mode = queue
+codes = 1, 2 ,3
+names = a, b
+path = /var/run/x.sock
+rep = one
+rep = two
+`)
+	var (
+		n     int
+		f     float64
+		b     = true
+		dur   time.Duration
+		mode  string
+		codes []int
+		names []string
+		path  string
+	)
+	d.integer("n", &n, 1)
+	d.float("f", &f, 0, 1)
+	d.boolean("b", &b)
+	d.duration("dur", &dur)
+	d.choice("mode", &mode, overlapSkip, overlapQueue)
+	d.intList("codes", &codes)
+	d.nameList("names", &names)
+	d.absPath("path", &path)
+	reps := d.all("rep")
+	if errs := d.finish(); len(errs) > 0 {
+		t.Fatal(errors.Join(errs...))
+	}
+
+	if n != 3 || f != 0.5 || b || dur != 90*time.Second || mode != overlapQueue || path != "/var/run/x.sock" {
+		t.Errorf("decoded n=%d f=%g b=%t dur=%s mode=%q path=%q", n, f, b, dur, mode, path)
+	}
+	if len(codes) != 3 || codes[0] != 1 || codes[1] != 2 || codes[2] != 3 {
+		t.Errorf("codes = %v", codes)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("names = %v", names)
+	}
+	if len(reps) != 2 || reps[0].value != "one" || reps[1].value != "two" {
+		t.Errorf("rep = %+v", reps)
+	}
+}
+
+func TestSectionDecoderErrors(t *testing.T) {
+	d := decodeTestSection(t, `[test "x"]
+n = 0
+n = 1
+f = 2
+b = maybe
+dur = -1s
+mode = sometimes
+codes = 1,x
+names = a,,b
+path = relative/x.sock
+typo = 1
+`)
+	var (
+		n, reqd int
+		f       float64
+		b       bool
+		dur     time.Duration
+		mode    string
+		codes   []int
+		names   []string
+		path    string
+		missing string
+	)
+	d.integer("n", &n, 1)
+	d.integer("reqd", &reqd, 0)
+	d.float("f", &f, 0, 1)
+	d.boolean("b", &b)
+	d.duration("dur", &dur)
+	d.choice("mode", &mode, overlapSkip, overlapQueue)
+	d.intList("codes", &codes)
+	d.nameList("names", &names)
+	d.absPath("path", &path)
+	d.required("missing", &missing)
+
+	var got []string
+	for _, err := range d.finish() {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`runner.conf:3: duplicate key "n" (first set on line 2)`,
+		"runner.conf:4: f must be between 0 and 1, got 2",
+		`runner.conf:5: b must be a boolean, got "maybe"`,
+		"runner.conf:6: dur must not be negative",
+		`runner.conf:7: mode must be one of skip, queue, got "sometimes"`,
+		`runner.conf:8: codes must be a comma-separated list of integers, got "1,x"`,
+		"runner.conf:9: names contains an empty name",
+		`runner.conf:10: path must be an absolute path, got "relative/x.sock"`,
+		`runner.conf:1: [test "x"]: missing required key "missing"`,
+		`runner.conf:11: unknown key "typo" in [test "x"]`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// The last value of a duplicated key wins.
+	if n != 1 {
+		t.Errorf("n = %d, want 1", n)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	const conf = `[runner]
+workers = 2
+
+[job "backup"]
+type = command
+schedule = 0 3 * * 1-7
+exec = tar czf /tmp/home.tgz /home
+timeout = 1h
+`
+	cfg, err := parseConfig("runner.conf", strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Runner.Workers != 2 {
+		t.Errorf("workers = %d, want 2", cfg.Runner.Workers)
+	}
+	if len(cfg.Jobs) != 1 {
+		t.Fatalf("got %d jobs, want 1", len(cfg.Jobs))
+	}
+	job := cfg.Jobs[0]
+	if job.Name != "backup" || job.Type != jobTypeCommand || job.Line != 4 || job.Timeout != time.Hour || job.KillGrace != defaultKillGrace {
+		t.Errorf("job = %+v", job)
+	}
+	if strings.Join(job.Exec, " ") != "tar czf /tmp/home.tgz /home" {
+		t.Errorf("exec = %q", job.Exec)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	const conf = `[runner]
+workers = 0
+
+[runner]
+
+[job "a"]
+type = command
+schedule = 0 3 * * 5-8
+
+[job "b"]
+type = nonsense
+`
+	_, err := parseConfig("runner.conf", strings.NewReader(conf))
+	if err == nil {
+		t.Fatal("parseConfig succeeded")
+	}
+	for _, want := range []string{
+		"runner.conf:2: workers must be at least 1, got 0",
+		"runner.conf:4: duplicate section [runner] (first on line 1)",
+		"runner.conf:6: [job \"a\"]: command jobs need a command or exec key",
+		"runner.conf:8: invalid schedule: day of week field",
+		`runner.conf:11: unknown job type "nonsense"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("errors lack %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// The control socket speaks line-delimited JSON: each request is one JSON
+// object on its own line and gets exactly one response line. Requests carry
+// the protocol version the client speaks; the server rejects versions it does
+// not know so old GUIs and new runners fail loudly instead of misreading
+// each other.
+//
+// Stream commands, such as "follow", are the exception: they answer with a
+// series of response lines, each carrying one event, until the stream ends
+// or the client closes the connection.
+
+const (
+	controlSocketFileName  = "control.sock"
+	controlProtocolVersion = 1
+	controlIdleTimeout     = 30 * time.Second
+	controlMaxRequestBytes = 1 << 20
+)
+
+type controlRequest struct {
+	Version int             `json:"version"`
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+type controlResponse struct {
+	Version int             `json:"version"`
+	OK      bool            `json:"ok"`
+	Error   string          `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// controlHandler serves one command. args is the raw "args" object, which may
+// be empty. The returned value is marshalled into the response's "result".
+type controlHandler func(args json.RawMessage) (any, error)
+
+// controlStreamHandler serves a stream command, calling send for every event
+// until it is done or stop is closed (the client went away or the server is
+// closing). A returned error ends the stream with an error response.
+type controlStreamHandler func(args json.RawMessage, send func(any) error, stop <-chan struct{}) error
+
+type controlServer struct {
+	path string
+	log  *shared.Logger
+
+	mu       sync.Mutex
+	handlers map[string]controlHandler
+	streams  map[string]controlStreamHandler
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+func newControlServer(path string, log *shared.Logger) *controlServer {
+	return &controlServer{
+		path:     path,
+		log:      log,
+		handlers: make(map[string]controlHandler),
+		streams:  make(map[string]controlStreamHandler),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle registers the handler for command. It must be called before Start.
+func (s *controlServer) Handle(command string, h controlHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// HandleStream registers the handler for stream command. It must be called
+// before Start.
+func (s *controlServer) HandleStream(command string, h controlStreamHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[command] = h
+}
+
+// Start listens on the socket path, replacing a stale socket left behind by
+// a crashed runner. It refuses to start if another runner is answering.
+func (s *controlServer) Start() error {
+	if _, err := os.Stat(s.path); err == nil {
+		if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("another runner is already listening on %s", s.path)
+		}
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.acceptLoop(listener)
+	}()
+	return nil
+}
+
+// Close stops accepting connections, closes open ones and removes the socket.
+func (s *controlServer) Close() error {
+	s.mu.Lock()
+	listener := s.listener
+	s.listener = nil
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+	err := listener.Close()
+	s.wg.Wait()
+	_ = os.Remove(s.path)
+	return err
+}
+
+func (s *controlServer) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Error("Control socket accept failed", "error", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *controlServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), controlMaxRequestBytes)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(controlIdleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+
+		req, errMsg := parseControlRequest(scanner.Bytes())
+		if errMsg == "" {
+			s.mu.Lock()
+			stream, ok := s.streams[req.Command]
+			s.mu.Unlock()
+			if ok {
+				// The stream owns the connection until it ends.
+				s.serveStream(conn, encoder, stream, req.Args)
+				return
+			}
+		}
+
+		resp := controlResponse{Version: controlProtocolVersion, Error: errMsg}
+		if errMsg == "" {
+			resp = s.dispatch(req)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// parseControlRequest decodes one request line, returning an error message
+// for malformed requests and unsupported protocol versions.
+func parseControlRequest(line []byte) (controlRequest, string) {
+	var req controlRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return req, fmt.Sprintf("malformed request: %v", err)
+	}
+	if req.Version < 1 || req.Version > controlProtocolVersion {
+		return req, fmt.Sprintf("unsupported protocol version %d (runner speaks %d)", req.Version, controlProtocolVersion)
+	}
+	return req, ""
+}
+
+// serveStream runs a stream handler on conn. Nothing more is read from the
+// client; its closing the connection stops the stream.
+func (s *controlServer) serveStream(conn net.Conn, encoder *json.Encoder, h controlStreamHandler, args json.RawMessage) {
+	conn.SetReadDeadline(time.Time{})
+	stop := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(stop)
+	}()
+
+	send := func(v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		conn.SetWriteDeadline(time.Now().Add(controlIdleTimeout))
+		return encoder.Encode(controlResponse{Version: controlProtocolVersion, OK: true, Result: data})
+	}
+	if err := h(args, send, stop); err != nil {
+		conn.SetWriteDeadline(time.Now().Add(controlIdleTimeout))
+		_ = encoder.Encode(controlResponse{Version: controlProtocolVersion, Error: err.Error()})
+	}
+}
+
+func (s *controlServer) dispatch(req controlRequest) controlResponse {
+	resp := controlResponse{Version: controlProtocolVersion}
+
+	s.mu.Lock()
+	handler, ok := s.handlers[req.Command]
+	s.mu.Unlock()
+	if !ok {
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+		return resp
+	}
+
+	result, err := handler(req.Args)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = fmt.Sprintf("failed to encode result: %v", err)
+			return resp
+		}
+		resp.Result = data
+	}
+	resp.OK = true
+	return resp
+}
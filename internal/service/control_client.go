@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const controlClientTimeout = 5 * time.Second
+
+// ErrRunnerNotRunning is returned by ControlClient when nothing is listening
+// on the control socket.
+var ErrRunnerNotRunning = errors.New("runner is not running")
+
+// ControlClient talks to a running runner over its control socket. Each call
+// uses its own short-lived connection.
+type ControlClient struct {
+	path    string
+	timeout time.Duration
+}
+
+// NewControlClient returns a client for the current user's runner.
+func NewControlClient() (*ControlClient, error) {
+	path, err := getControlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	return &ControlClient{path: path, timeout: controlClientTimeout}, nil
+}
+
+// Status returns the runner's live state.
+func (c *ControlClient) Status() (*RunnerStatus, error) {
+	var st RunnerStatus
+	if err := c.call("status", nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Jobs returns the status of every job known to the runner.
+func (c *ControlClient) Jobs() ([]JobStatus, error) {
+	var jobs []JobStatus
+	if err := c.call("jobs", nil, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// LastRun returns the record of the most recent run of job, or nil if it
+// has not run since the runner started.
+func (c *ControlClient) LastRun(job string) (*RunRecord, error) {
+	var rec *RunRecord
+	if err := c.call("last-run", jobArgs{Job: job}, &rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// History returns runs matching q, newest first.
+func (c *ControlClient) History(q HistoryQuery) ([]RunRecord, error) {
+	var records []RunRecord
+	if err := c.call("history", q, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Pause suspends automatic runs of job, or of every job if job is empty, and
+// returns the resulting state.
+func (c *ControlClient) Pause(job string) (PauseState, error) {
+	var st PauseState
+	err := c.call("pause", jobArgs{Job: job}, &st)
+	return st, err
+}
+
+// Resume undoes Pause for job, or lifts the global pause if job is empty.
+func (c *ControlClient) Resume(job string) (PauseState, error) {
+	var st PauseState
+	err := c.call("resume", jobArgs{Job: job}, &st)
+	return st, err
+}
+
+// LogLevel returns the level and format of the runner log.
+func (c *ControlClient) LogLevel() (LogLevelState, error) {
+	var st LogLevelState
+	err := c.call("log-level", nil, &st)
+	return st, err
+}
+
+// SetLogLevel changes the level of the runner log until the runner restarts.
+func (c *ControlClient) SetLogLevel(level string) (LogLevelState, error) {
+	var st LogLevelState
+	err := c.call("log-level", logLevelArgs{Level: level}, &st)
+	return st, err
+}
+
+// RunJob starts a run of job in the background and returns its run ID.
+func (c *ControlClient) RunJob(job string) (string, error) {
+	var ref runRef
+	if err := c.call("run", jobArgs{Job: job}, &ref); err != nil {
+		return "", err
+	}
+	return ref.RunID, nil
+}
+
+// CancelRun cancels the queued or running run with the given ID.
+func (c *ControlClient) CancelRun(id string) (CancelResult, error) {
+	var res CancelResult
+	err := c.call("cancel", runArgs{RunID: id}, &res)
+	return res, err
+}
+
+// CancelJob cancels every queued and running run of job.
+func (c *ControlClient) CancelJob(job string) (CancelResult, error) {
+	var res CancelResult
+	err := c.call("cancel", runArgs{Job: job}, &res)
+	return res, err
+}
+
+// Follow streams live command output to fn: of the run runID if set, until
+// it ends, otherwise of every run of job (or of all jobs if job is empty).
+// It returns once the stream is set up; the stream then runs in the
+// background until it ends or ctx is cancelled, and done receives the error
+// that ended it, or nil.
+func (c *ControlClient) Follow(ctx context.Context, job, runID string, fn func(OutputEvent)) (<-chan error, error) {
+	conn, err := net.DialTimeout("unix", c.path, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRunnerNotRunning, err)
+	}
+
+	data, err := json.Marshal(runArgs{Job: job, RunID: runID})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to encode follow arguments: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := json.NewEncoder(conn).Encode(controlRequest{Version: controlProtocolVersion, Command: "follow", Args: data}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send follow request: %w", err)
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	next := func() (OutputEvent, error) {
+		var resp controlResponse
+		var ev OutputEvent
+		if err := decoder.Decode(&resp); err != nil {
+			return ev, err
+		}
+		if !resp.OK {
+			return ev, fmt.Errorf("runner rejected follow: %s", resp.Error)
+		}
+		if err := json.Unmarshal(resp.Result, &ev); err != nil {
+			return ev, fmt.Errorf("failed to decode follow event: %w", err)
+		}
+		return ev, nil
+	}
+	if _, err := next(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Events may be far apart; only ctx ends the wait.
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go func() {
+		defer stop()
+		defer conn.Close()
+		for {
+			ev, err := next()
+			if err != nil {
+				if errors.Is(err, io.EOF) || ctx.Err() != nil {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			fn(ev)
+		}
+	}()
+	return done, nil
+}
+
+// call sends one request and decodes its result into result (if non-nil).
+func (c *ControlClient) call(command string, args any, result any) error {
+	conn, err := net.DialTimeout("unix", c.path, c.timeout)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRunnerNotRunning, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	req := controlRequest{Version: controlProtocolVersion, Command: command}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s arguments: %w", command, err)
+		}
+		req.Args = data
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send %s request: %w", command, err)
+	}
+
+	var resp controlResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read %s response: %w", command, err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("runner rejected %s: %s", command, resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", command, err)
+		}
+	}
+	return nil
+}
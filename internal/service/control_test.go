@@ -0,0 +1,180 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoArgs struct {
+	Text string `json:"text"`
+}
+
+// startTestControl serves echo, fail and a count stream on a socket in a
+// temporary directory.
+func startTestControl(t *testing.T) (*controlServer, *ControlClient) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), controlSocketFileName)
+	srv := newControlServer(path, nil)
+	srv.Handle("echo", func(args json.RawMessage) (any, error) {
+		var a echoArgs
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		return a, nil
+	})
+	srv.Handle("fail", func(json.RawMessage) (any, error) {
+		return nil, errors.New("it failed")
+	})
+	srv.HandleStream("count", func(args json.RawMessage, send func(any) error, stop <-chan struct{}) error {
+		for i := range 3 {
+			if err := send(i); err != nil {
+				return err
+			}
+		}
+		return errors.New("stream over")
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv, &ControlClient{path: path, timeout: controlClientTimeout}
+}
+
+// rawControl sends request lines on one connection and returns the response
+// lines.
+func rawControl(t *testing.T, path string, requests ...string) []controlResponse {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var out []controlResponse
+	for _, req := range requests {
+		fmt.Fprintln(conn, req)
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp controlResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("response %q: %v", line, err)
+		}
+		out = append(out, resp)
+	}
+	return out
+}
+
+func TestControlRoundTrip(t *testing.T) {
+	_, client := startTestControl(t)
+	var got echoArgs
+	if err := client.call("echo", echoArgs{Text: "hello"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "hello" {
+		t.Errorf("echo = %q, want hello", got.Text)
+	}
+	if err := client.call("fail", nil, nil); err == nil || !strings.Contains(err.Error(), "runner rejected fail: it failed") {
+		t.Errorf("fail error = %v", err)
+	}
+	if err := client.call("nonsense", nil, nil); err == nil || !strings.Contains(err.Error(), `unknown command "nonsense"`) {
+		t.Errorf("unknown command error = %v", err)
+	}
+}
+
+func TestControlProtocolErrors(t *testing.T) {
+	srv, _ := startTestControl(t)
+	// One connection serves several requests; errors do not end it.
+	resps := rawControl(t, srv.path,
+		`{"version":2,"command":"echo"}`,
+		`{"command":"echo"}`,
+		`not json`,
+		`{"version":1,"command":"echo","args":{"text":"still here"}}`,
+	)
+	want := []string{
+		"unsupported protocol version 2 (runner speaks 1)",
+		"unsupported protocol version 0 (runner speaks 1)",
+		"malformed request",
+	}
+	for i, w := range want {
+		if resps[i].OK || !strings.HasPrefix(resps[i].Error, w) {
+			t.Errorf("response %d = %+v, want error %q", i, resps[i], w)
+		}
+		if resps[i].Version != controlProtocolVersion {
+			t.Errorf("response %d has version %d", i, resps[i].Version)
+		}
+	}
+	if last := resps[3]; !last.OK || string(last.Result) != `{"text":"still here"}` {
+		t.Errorf("response after errors = %+v", last)
+	}
+}
+
+func TestControlStream(t *testing.T) {
+	srv, _ := startTestControl(t)
+	conn, err := net.Dial("unix", srv.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintln(conn, `{"version":1,"command":"count"}`)
+
+	decoder := json.NewDecoder(conn)
+	for i := range 3 {
+		var resp controlResponse
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.OK || string(resp.Result) != fmt.Sprint(i) {
+			t.Errorf("event %d = %+v", i, resp)
+		}
+	}
+	var resp controlResponse
+	if err := decoder.Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.OK || resp.Error != "stream over" {
+		t.Errorf("end of stream = %+v", resp)
+	}
+}
+
+func TestControlStartRefusesRunningServer(t *testing.T) {
+	srv, _ := startTestControl(t)
+	second := newControlServer(srv.path, nil)
+	if err := second.Start(); err == nil || !strings.Contains(err.Error(), "already listening") {
+		second.Close()
+		t.Errorf("second Start error = %v", err)
+	}
+}
+
+func TestControlStartReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), controlSocketFileName)
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv := newControlServer(path, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Close left the socket behind: %v", err)
+	}
+}
+
+func TestControlClientNotRunning(t *testing.T) {
+	client := &ControlClient{path: filepath.Join(t.TempDir(), controlSocketFileName), timeout: time.Second}
+	if _, err := client.Status(); !errors.Is(err, ErrRunnerNotRunning) {
+		t.Errorf("Status error = %v, want ErrRunnerNotRunning", err)
+	}
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes the next activation time strictly after t.
+// A zero time means the schedule will never fire again.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// cronSchedule is a parsed cron expression. Each field is a bitset of the
+// allowed values; starBit marks fields that were given as "*" or "?".
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+}
+
+// everySchedule fires at a fixed interval, aligned to whole seconds.
+type everySchedule struct {
+	interval time.Duration
+}
+
+const starBit = 1 << 63
+
+type cronField struct {
+	name     string
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Day of week runs to 7 so that ranges such as "1-7" end on Sunday;
+	// parseCronField folds 7 into 0.
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseSchedule parses a cron expression. It accepts the classic five-field
+// form (minute precision), a six-field form with a leading seconds field,
+// the @yearly/@monthly/@weekly/@daily/@hourly descriptors and "@every <duration>".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if strings.HasPrefix(spec, "@every") {
+		arg := strings.TrimSpace(strings.TrimPrefix(spec, "@every"))
+		interval, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid @every duration %q: %w", arg, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("@every interval must be at least 1s, got %s", interval)
+		}
+		return everySchedule{interval: interval.Truncate(time.Second)}, nil
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := cronDescriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule descriptor %q", spec)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields, got %d in %q", len(fields), spec)
+	}
+
+	var s cronSchedule
+	var err error
+	specs := []struct {
+		dst   *uint64
+		field cronField
+	}{
+		{&s.second, secondField},
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	}
+	for i, f := range specs {
+		if *f.dst, err = parseCronField(fields[i], f.field); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+func parseCronField(expr string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		b, err := parseCronRange(part, field)
+		if err != nil {
+			return 0, fmt.Errorf("%s field %q: %w", field.name, expr, err)
+		}
+		bits |= b
+	}
+	if field.name == dowField.name && bits&(1<<7) != 0 {
+		bits = bits&^(1<<7) | 1<<0
+	}
+	return bits, nil
+}
+
+func parseCronRange(expr string, field cronField) (uint64, error) {
+	rangeAndStep := strings.SplitN(expr, "/", 2)
+	lowAndHigh := strings.SplitN(rangeAndStep[0], "-", 2)
+
+	var start, end uint
+	var extra uint64
+	if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+		if len(lowAndHigh) > 1 {
+			return 0, fmt.Errorf("cannot combine * with a range")
+		}
+		start, end = field.min, field.max
+		extra = starBit
+	} else {
+		var err error
+		if start, err = parseCronValue(lowAndHigh[0], field); err != nil {
+			return 0, err
+		}
+		end = start
+		if len(lowAndHigh) == 2 {
+			if end, err = parseCronValue(lowAndHigh[1], field); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	step := uint(1)
+	if len(rangeAndStep) == 2 {
+		n, err := strconv.ParseUint(rangeAndStep[1], 10, 8)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid step %q", rangeAndStep[1])
+		}
+		step = uint(n)
+		// "N/step" means "N-max/step".
+		if len(lowAndHigh) == 1 && extra == 0 {
+			end = field.max
+		}
+		// A stepped star no longer matches every value.
+		if step > 1 {
+			extra = 0
+		}
+	}
+
+	if start < field.min || end > field.max {
+		return 0, fmt.Errorf("value out of range [%d-%d]", field.min, field.max)
+	}
+	if start > end {
+		return 0, fmt.Errorf("range start %d is after end %d", start, end)
+	}
+
+	var bits uint64
+	for v := start; v <= end; v += step {
+		bits |= 1 << v
+	}
+	return bits | extra, nil
+}
+
+func parseCronValue(s string, field cronField) (uint, error) {
+	if v, ok := field.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return uint(n), nil
+}
+
+// Next returns the next time matching the expression after t, or the zero
+// time if none exists within five years.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+
+	// Start at the next whole second.
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+
+	added := false
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// Midnight may not exist (or may repeat) across a DST transition.
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for 1<<uint(t.Second())&s.second == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+// dayMatches follows Vixie cron: if either day field is restricted, a day
+// matches when it satisfies either restricted field.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&s.dom > 0
+	dowMatch := 1<<uint(t.Weekday())&s.dow > 0
+	if s.dom&starBit > 0 || s.dow&starBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (e everySchedule) Next(t time.Time) time.Time {
+	return t.Add(e.interval - time.Duration(t.Nanosecond())*time.Nanosecond)
+}
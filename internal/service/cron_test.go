@@ -0,0 +1,135 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{"", "empty schedule"},
+		{"* * * *", "expected 5 or 6 fields"},
+		{"* * * * * * *", "expected 5 or 6 fields"},
+		{"@fortnightly", "unknown schedule descriptor"},
+		{"@every 500ms", "at least 1s"},
+		{"@every soon", "invalid @every duration"},
+		{"60 * * * *", "out of range"},
+		{"* 24 * * *", "out of range"},
+		{"* * 0 * *", "out of range"},
+		{"* * * 13 *", "out of range"},
+		{"* * * * 8", "out of range"},
+		{"5-1 * * * *", "range start 5 is after end 1"},
+		{"*/0 * * * *", "invalid step"},
+		{"*-5 * * * *", "cannot combine * with a range"},
+		{"x * * * *", `invalid value "x"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseSchedule(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseSchedule(%q) error = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestParseScheduleDayOfWeek(t *testing.T) {
+	const (
+		sun = 1 << 0
+		mon = 1 << 1
+		fri = 1 << 5
+		sat = 1 << 6
+	)
+	tests := []struct {
+		field string
+		want  uint64
+	}{
+		{"0", sun},
+		{"7", sun},
+		{"sun", sun},
+		{"1-7", 0x7f},
+		{"5-7", fri | sat | sun},
+		{"mon,7", mon | sun},
+		{"0-7/7", sun},
+		{"fri-sat", fri | sat},
+		{"*", 0x7f | starBit},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule("0 0 * * " + tt.field)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.field, err)
+			continue
+		}
+		if got := s.(*cronSchedule).dow; got != tt.want {
+			t.Errorf("day of week %q = %#x, want %#x", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// 2024-03-15 is a Friday.
+	from := time.Date(2024, 3, 15, 10, 30, 15, 500, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * * *", time.Date(2024, 3, 15, 10, 30, 16, 0, time.UTC)},
+		{"*/5 * * * *", time.Date(2024, 3, 15, 10, 35, 0, 0, time.UTC)},
+		{"30 10 * * * *", time.Date(2024, 3, 15, 11, 10, 30, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 3, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 6-7", time.Date(2024, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either restricted day field matches.
+		{"0 0 1 * mon", time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 3, 15, 10, 31, 45, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNextNever(t *testing.T) {
+	s, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want zero time", got)
+	}
+}
+
+// Job schedules run on the wall clock of their zone (see zonedSchedule): a
+// run in the hour skipped when the clocks go forward happens an hour later.
+func TestJobScheduleDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	const conf = `[job "nightly"]
+type = command
+exec = true
+schedule = 30 2 * * *
+timezone = Europe/Berlin
+`
+	cfg, err := parseConfig("runner.conf", strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 02:30 does not exist on 2024-03-31 in Berlin.
+	got := cfg.Jobs[0].schedule.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, loc))
+	if want := time.Date(2024, 3, 31, 3, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
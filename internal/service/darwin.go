@@ -364,9 +364,9 @@ func (d *darwinService) stopSystem() error {
 func (d *darwinService) Status() (string, error) {
 	switch d.preferredScope() {
 	case darwinScopeUser:
-		return d.statusUser()
+		return withRunnerPID(d.statusUser())
 	case darwinScopeSystem:
-		return d.statusSystem()
+		return withRunnerPID(d.statusSystem())
 	default:
 		return "Not installed", nil
 	}
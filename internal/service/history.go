@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The run history is an append-only file of JSON lines, one RunRecord per
+// line, fsynced after every append. A crash can at worst leave a torn final
+// line, which is dropped on the next open. Retention is enforced by rewriting
+// the file to a temporary sibling and renaming it into place, so the history
+// on disk is always either the old or the new version.
+
+const (
+	historyFileName = "history.jsonl"
+
+	defaultHistoryMaxRuns     = 5000
+	defaultHistoryMaxAge      = 30 * 24 * time.Hour
+	defaultHistoryMaxOutputKB = 8
+
+	defaultHistoryQueryLimit = 100
+	maxHistoryQueryLimit     = 1000
+)
+
+// HistoryOptions controls run history retention ([history] section).
+type HistoryOptions struct {
+	MaxRuns int
+	// MaxAge drops runs that ended longer ago.
+	MaxAge      time.Duration
+	MaxOutputKB int
+}
+
+// HistoryQuery selects runs from the history. Zero fields do not filter.
+type HistoryQuery struct {
+	Job    string     `json:"job,omitempty"`
+	Status string     `json:"status,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Limit  int        `json:"limit,omitempty"`
+}
+
+// HistoryStore is the durable record of job runs.
+type HistoryStore struct {
+	path string
+
+	mu      sync.Mutex
+	opts    HistoryOptions
+	file    *os.File
+	records []RunRecord // oldest first
+}
+
+// OpenHistoryStore loads the history at path, repairing a torn tail and
+// applying retention, and opens it for appending.
+func OpenHistoryStore(path string, opts HistoryOptions) (*HistoryStore, error) {
+	records, clean, err := readHistoryFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HistoryStore{path: path, opts: opts, records: records}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.trimLocked(time.Now()) || !clean {
+		if err := h.rewriteLocked(); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	if err := h.openAppendLocked(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ReadHistory queries the history file directly, without a running runner.
+func ReadHistory(q HistoryQuery) ([]RunRecord, error) {
+	path, err := getHistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := readHistoryFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return queryRecords(records, q), nil
+}
+
+// readHistoryFile returns the records in path, oldest first. clean is false
+// when malformed lines were skipped and the file should be rewritten.
+func readHistoryFile(path string) (records []RunRecord, clean bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open run history: %w", err)
+	}
+	defer f.Close()
+
+	clean = true
+	reader := bufio.NewReader(f)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec RunRecord
+			// A line without its newline is a torn write from a crash.
+			if line[len(line)-1] != '\n' || json.Unmarshal(line, &rec) != nil {
+				clean = false
+			} else {
+				records = append(records, rec)
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, false, fmt.Errorf("failed to read run history: %w", readErr)
+		}
+	}
+	return records, clean, nil
+}
+
+// Record appends rec to the history, durably.
+func (h *HistoryStore) Record(rec *RunRecord) error {
+	stored := *rec
+	limit := h.options().MaxOutputKB * 1024
+	var cut bool
+	if stored.Stdout, cut = truncateTail(stored.Stdout, limit); cut {
+		stored.OutputTruncated = true
+	}
+	if stored.Stderr, cut = truncateTail(stored.Stderr, limit); cut {
+		stored.OutputTruncated = true
+	}
+
+	line, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode run record: %w", err)
+	}
+	line = append(line, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.file == nil {
+		return fmt.Errorf("run history is closed")
+	}
+	if _, err := h.file.Write(line); err != nil {
+		return fmt.Errorf("failed to append run record: %w", err)
+	}
+	if err := h.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync run history: %w", err)
+	}
+	h.records = append(h.records, stored)
+
+	if h.trimLocked(time.Now()) {
+		return h.rewriteLocked()
+	}
+	return nil
+}
+
+// Query returns matching runs, newest first.
+func (h *HistoryStore) Query(q HistoryQuery) []RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return queryRecords(h.records, q)
+}
+
+// Attempts returns the run with the given ID and its retries, oldest first.
+func (h *HistoryStore) Attempts(id string) []RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var out []RunRecord
+	for _, rec := range h.records {
+		if rec.ID == id || rec.RetryOf == id {
+			out = append(out, rec)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Attempt < out[j].Attempt })
+	return out
+}
+
+// SetOptions applies new retention settings, trimming immediately if needed.
+func (h *HistoryStore) SetOptions(opts HistoryOptions) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.opts = opts
+	if h.trimLocked(time.Now()) {
+		return h.rewriteLocked()
+	}
+	return nil
+}
+
+func (h *HistoryStore) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+func (h *HistoryStore) options() HistoryOptions {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.opts
+}
+
+// trimLocked drops records beyond the retention limits and reports whether
+// the file needs rewriting. To avoid rewriting on every append once the store
+// is full, the run count may overshoot MaxRuns by 10% before trimming. Age
+// is measured from the end of a run: records are appended as runs finish,
+// so they are in order of End, while a long run starts before shorter ones
+// recorded ahead of it.
+func (h *HistoryStore) trimLocked(now time.Time) bool {
+	drop := 0
+	if h.opts.MaxAge > 0 {
+		cutoff := now.Add(-h.opts.MaxAge)
+		for drop < len(h.records) && h.records[drop].End.Before(cutoff) {
+			drop++
+		}
+	}
+	if h.opts.MaxRuns > 0 {
+		slack := h.opts.MaxRuns / 10
+		if over := len(h.records) - h.opts.MaxRuns; over > slack && over > drop {
+			drop = over
+		}
+	}
+	if drop == 0 {
+		return false
+	}
+	h.records = append([]RunRecord(nil), h.records[drop:]...)
+	return true
+}
+
+// rewriteLocked atomically replaces the file with the in-memory records and
+// reopens it for appending.
+func (h *HistoryStore) rewriteLocked() error {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), historyFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create run history temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for i := range h.records {
+		if err := enc.Encode(&h.records[i]); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode run history: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set run history permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync run history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close run history temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		return fmt.Errorf("failed to replace run history: %w", err)
+	}
+	syncDir(filepath.Dir(h.path))
+
+	return h.openAppendLocked()
+}
+
+func (h *HistoryStore) openAppendLocked() error {
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open run history: %w", err)
+	}
+	h.file = f
+	return nil
+}
+
+func queryRecords(records []RunRecord, q HistoryQuery) []RunRecord {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultHistoryQueryLimit
+	}
+	if limit > maxHistoryQueryLimit {
+		limit = maxHistoryQueryLimit
+	}
+
+	var out []RunRecord
+	for i := len(records) - 1; i >= 0 && len(out) < limit; i-- {
+		rec := records[i]
+		if q.Job != "" && rec.Job != q.Job {
+			continue
+		}
+		if q.Status != "" && rec.Status != q.Status {
+			continue
+		}
+		if q.Since != nil && rec.Start.Before(*q.Since) {
+			continue
+		}
+		if q.Until != nil && !rec.Start.Before(*q.Until) {
+			continue
+		}
+		out = append(out, rec)
+	}
+	// Records are appended in completion order; present them by start time.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start.After(out[j].Start) })
+	return out
+}
+
+// truncateTail keeps the last max bytes of s.
+func truncateTail(s string, max int) (string, bool) {
+	if max <= 0 || len(s) <= max {
+		return s, false
+	}
+	return s[len(s)-max:], true
+}
+
+// syncDir makes a rename in dir durable. Errors are ignored: not every
+// platform supports syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryTrimsByEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := OpenHistoryStore(path, HistoryOptions{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	now := time.Now()
+	// Records are appended as runs end.
+	records := []RunRecord{
+		{ID: "old", Job: "a", Start: now.Add(-3 * time.Hour), End: now.Add(-2 * time.Hour)},
+		// A long run that started before the cutoff but ended within it.
+		{ID: "long", Job: "b", Start: now.Add(-5 * time.Hour), End: now.Add(-30 * time.Minute)},
+		{ID: "recent", Job: "c", Start: now.Add(-10 * time.Minute), End: now.Add(-5 * time.Minute)},
+	}
+	for i := range records {
+		if err := h.Record(&records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	for _, r := range h.Query(HistoryQuery{}) {
+		ids = append(ids, r.ID)
+	}
+	if len(ids) != 2 || ids[0] != "recent" || ids[1] != "long" {
+		t.Errorf("kept %v, want [recent long]", ids)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const runnerPIDFileName = "runner.pid"
+
+// errLocked is returned by the platform lock functions when another process
+// holds the lock.
+var errLocked = errors.New("file is locked")
+
+// instanceLock keeps a single runner per runner directory. The PID file is
+// held under an exclusive OS lock for the runner's lifetime; the OS drops the
+// lock when the process dies, so a PID left behind by a crash is recognised
+// as stale and taken over.
+type instanceLock struct {
+	f *os.File
+}
+
+// acquireInstanceLock locks path and writes the current PID into it. It
+// returns the PID of a runner that died without releasing the lock, if any.
+func acquireInstanceLock(path string) (lock *instanceLock, stalePID int, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open PID file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		pid := readPID(f)
+		f.Close()
+		if errors.Is(err, errLocked) {
+			if pid > 0 {
+				return nil, 0, fmt.Errorf("another runner is already running (PID %d, lock %s)", pid, path)
+			}
+			return nil, 0, fmt.Errorf("another runner is already running (lock %s)", path)
+		}
+		return nil, 0, fmt.Errorf("failed to lock PID file: %w", err)
+	}
+
+	stalePID = readPID(f)
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return &instanceLock{f: f}, stalePID, nil
+}
+
+// release empties the PID file and drops the lock. The file itself stays:
+// removing it would let a runner starting concurrently lock an unlinked file.
+func (l *instanceLock) release() error {
+	err := l.f.Truncate(0)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readPID returns the PID stored in f, or 0.
+func readPID(f *os.File) int {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 64))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+// RunnerPID returns the PID of the runner holding the instance lock, or 0 if
+// no runner is running.
+func RunnerPID() int {
+	path, err := getRunnerPIDPath()
+	if err != nil {
+		return 0
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if !fileLocked(f) {
+		return 0
+	}
+	return readPID(f)
+}
+
+// withRunnerPID appends the PID of the running runner to a service status.
+func withRunnerPID(status string, err error) (string, error) {
+	if err != nil {
+		return status, err
+	}
+	if pid := RunnerPID(); pid > 0 {
+		return fmt.Sprintf("%s, PID %d", status, pid), nil
+	}
+	return status, nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows byte-range locks are mandatory, so the lock covers a byte far past
+// the PID and readers can still see it.
+const lockOffset = 0xFFFFFFFF
+
+func lockRange(f *os.File, flags uint32) error {
+	ol := &windows.Overlapped{Offset: lockOffset}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+// lockFile takes an exclusive lock on f without blocking.
+func lockFile(f *os.File) error {
+	return lockRange(f, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+// fileLocked reports whether another process holds an exclusive lock on f.
+func fileLocked(f *os.File) bool {
+	if err := lockRange(f, 0); err != nil {
+		return errors.Is(err, errLocked)
+	}
+	ol := &windows.Overlapped{Offset: lockOffset}
+	_ = windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+	return false
+}
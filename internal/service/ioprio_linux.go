@@ -0,0 +1,30 @@
+package service
+
+import (
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	ioniceRealtime:   1,
+	ioniceBestEffort: 2,
+	ioniceIdle:       3,
+}
+
+// setIOPriority sets the I/O scheduling class and level of the calling
+// process, like ionice(1).
+func setIOPriority(class string, level int) error {
+	prio := ioprioClasses[class]<<ioprioClassShift | level
+	if class == ioniceIdle {
+		prio = ioprioClasses[class] << ioprioClassShift
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build darwin
+
+package service
+
+import "fmt"
+
+// setIOPriority is Linux only; I/O scheduling classes do not exist elsewhere.
+func setIOPriority(class string, level int) error {
+	return fmt.Errorf("ionice is only supported on Linux")
+}
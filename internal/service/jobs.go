@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (r *runner) buildJob(jc JobConfig, secrets map[string]string) (Job, error) {
+	job := Job{
+		Name:         jc.Name,
+		Type:         jc.Type,
+		ScheduleSpec: jc.Schedule,
+		Schedule:     jc.schedule,
+		Fingerprint:  jc.fingerprint(),
+		Retry:        jc.Retry,
+		Overlap:      jc.Overlap,
+		MaxQueued:    jc.MaxQueued,
+		Misfire:      jc.Misfire,
+		Jitter:       jc.Timing.Jitter,
+	}
+	if job.ScheduleSpec == "" && len(jc.Watch.Paths) > 0 {
+		job.ScheduleSpec = "watch " + strings.Join(jc.Watch.Paths, ", ")
+	}
+	switch {
+	case jc.Task != "":
+		run, dedicated, digest, err := r.taskRun(jc, secrets)
+		if err != nil {
+			return Job{}, fmt.Errorf("job %q: %w", jc.Name, err)
+		}
+		job.Run, job.Dedicated = run, dedicated
+		job.Fingerprint += " secrets=" + digest
+	case jc.Type == jobTypeCommand:
+		spec := commandSpecFromConfig(jc)
+		env, digest, err := expandSecrets(spec.env, secrets)
+		if err != nil {
+			return Job{}, fmt.Errorf("job %q: %w", jc.Name, err)
+		}
+		spec.env = env
+		job.Fingerprint += " secrets=" + digest
+		if spec.limits.scopeLimits() && !underSystemd() {
+			r.log.Warn("memory_max and cpu_quota only apply when the runner runs under systemd", "job", jc.Name)
+		}
+		job.Run = func(ctx context.Context, rec *RunRecord) error {
+			return runCommand(ctx, spec, rec, r.output)
+		}
+	default:
+		return Job{}, fmt.Errorf("job %q: unknown type %q", jc.Name, jc.Type)
+	}
+	return job, nil
+}
+
+// taskRun creates the task of a task job and returns the function running
+// it; plugin tasks start their executable for every run. Runs of the
+// heartbeat task are counted for the status API.
+func (r *runner) taskRun(jc JobConfig, secrets map[string]string) (run func(ctx context.Context, rec *RunRecord) error, dedicated bool, digest string, err error) {
+	def, ok := lookupTask(jc.Task)
+	if !ok {
+		return nil, false, "", fmt.Errorf("unknown task %q", jc.Task)
+	}
+	raw, digest, err := expandSecrets(jc.Params, secrets)
+	if err != nil {
+		return nil, false, "", err
+	}
+	params, err := def.newParams(raw)
+	if err != nil {
+		return nil, false, "", err
+	}
+
+	if def.Path != "" {
+		// A plugin that changed its schema, and so perhaps its defaults,
+		// restarts its jobs on reload.
+		digest += fmt.Sprintf(" plugin=%+v", def.Params)
+		run = func(ctx context.Context, rec *RunRecord) error {
+			return runPlugin(ctx, def, params, jc.Timeout, rec, r.output, r.log)
+		}
+		return run, false, digest, nil
+	}
+
+	task := def.New(TaskEnv{Job: jc.Name, Log: r.log.With("job", jc.Name)})
+	beat := def.Name == heartbeatTaskName
+	run = func(ctx context.Context, rec *RunRecord) error {
+		if err := runTask(ctx, task, params, jc.Timeout, rec); err != nil {
+			return err
+		}
+		if beat {
+			r.lastHeartbeat.Store(time.Now().UnixNano())
+			r.heartbeats.Add(1)
+		}
+		return nil
+	}
+	return run, def.Dedicated, digest, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limitExecCommand is the hidden CLI command the runner re-executes itself
+// with to apply resource limits to a job's process before it starts.
+const limitExecCommand = "limit-exec"
+
+// Limits recorded in RunRecord.LimitExceeded.
+const (
+	limitCPU    = "cpu"
+	limitMemory = "memory"
+)
+
+// I/O scheduling classes for ionice.
+const (
+	ioniceRealtime   = "realtime"
+	ioniceBestEffort = "best-effort"
+	ioniceIdle       = "idle"
+)
+
+var (
+	memoryMaxPattern = regexp.MustCompile(`^(\d+[KMGT]?|\d+(\.\d+)?%|infinity)$`)
+	cpuQuotaPattern  = regexp.MustCompile(`^\d+(\.\d+)?%$`)
+)
+
+// ResourceLimits constrains the processes of a command job. Zero values mean
+// no limit.
+type ResourceLimits struct {
+	// CPUTime bounds the CPU time of the process (RLIMIT_CPU), in whole
+	// seconds.
+	CPUTime time.Duration
+	// AddressSpaceMB bounds the virtual memory of each process (RLIMIT_AS).
+	AddressSpaceMB int
+	// OpenFiles bounds the open file descriptors per process (RLIMIT_NOFILE).
+	OpenFiles int
+	// Nice is the scheduling priority, from -20 (highest) to 19, applied
+	// when SetNice is true.
+	Nice    int
+	SetNice bool
+	// IOClass and IOLevel set the I/O scheduling class and, except for
+	// ioniceIdle, its priority from 0 (highest) to 7 (Linux only).
+	IOClass string
+	IOLevel int
+
+	// MemoryMax and CPUQuota are applied through a transient systemd scope
+	// when the runner runs under systemd.
+	MemoryMax string
+	CPUQuota  string
+}
+
+func decodeLimits(d *sectionDecoder, l *ResourceLimits) {
+	*l = ResourceLimits{}
+	d.duration("limit_cpu", &l.CPUTime)
+	if l.CPUTime > 0 && l.CPUTime < time.Second {
+		l.CPUTime = time.Second
+	}
+	d.integer("limit_address_space_mb", &l.AddressSpaceMB, 1)
+	d.integer("limit_open_files", &l.OpenFiles, 1)
+
+	if e, ok := d.lookup("nice"); ok {
+		n, err := strconv.Atoi(e.value)
+		if err != nil || n < -20 || n > 19 {
+			d.errorf(e.line, "nice must be an integer from -20 to 19, got %q", e.value)
+		} else {
+			l.Nice, l.SetNice = n, true
+		}
+	}
+
+	if e, ok := d.lookup("ionice"); ok {
+		class, level, hasLevel := strings.Cut(e.value, ":")
+		l.IOClass, l.IOLevel = class, 4
+		switch {
+		case class != ioniceRealtime && class != ioniceBestEffort && class != ioniceIdle:
+			d.errorf(e.line, "ionice must be idle, best-effort[:level] or realtime[:level], got %q", e.value)
+		case hasLevel && class == ioniceIdle:
+			d.errorf(e.line, "the idle I/O class takes no level")
+		case hasLevel:
+			n, err := strconv.Atoi(level)
+			if err != nil || n < 0 || n > 7 {
+				d.errorf(e.line, "ionice level must be from 0 to 7, got %q", level)
+			}
+			l.IOLevel = n
+		}
+	}
+
+	if line, ok := d.str("memory_max", &l.MemoryMax); ok && !memoryMaxPattern.MatchString(l.MemoryMax) {
+		d.errorf(line, "memory_max must be bytes with an optional K, M, G or T suffix, a percentage or infinity, got %q", l.MemoryMax)
+	}
+	if line, ok := d.str("cpu_quota", &l.CPUQuota); ok && !cpuQuotaPattern.MatchString(l.CPUQuota) {
+		d.errorf(line, "cpu_quota must be a percentage such as 50%% or 200%%, got %q", l.CPUQuota)
+	}
+}
+
+// processLimits reports whether l needs the limit-exec helper.
+func (l ResourceLimits) processLimits() bool {
+	return l.CPUTime > 0 || l.AddressSpaceMB > 0 || l.OpenFiles > 0 || l.SetNice || l.IOClass != ""
+}
+
+// scopeLimits reports whether l needs a systemd scope.
+func (l ResourceLimits) scopeLimits() bool {
+	return l.MemoryMax != "" || l.CPUQuota != ""
+}
+
+// underSystemd reports whether the runner was started by systemd, which
+// sets INVOCATION_ID for every unit it runs.
+func underSystemd() bool {
+	return os.Getenv("INVOCATION_ID") != ""
+}
+
+// wrap returns argv prefixed with the commands that apply l: a transient
+// systemd scope for the cgroup limits, then the limit-exec helper for the
+// per-process ones.
+func (l ResourceLimits) wrap(job string, argv []string) ([]string, error) {
+	if l.processLimits() {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate runner executable for resource limits: %w", err)
+		}
+		helper := []string{self, limitExecCommand}
+		if l.CPUTime > 0 {
+			helper = append(helper, "-cpu", strconv.Itoa(int((l.CPUTime+time.Second-1)/time.Second)))
+		}
+		if l.AddressSpaceMB > 0 {
+			helper = append(helper, "-as-mb", strconv.Itoa(l.AddressSpaceMB))
+		}
+		if l.OpenFiles > 0 {
+			helper = append(helper, "-nofile", strconv.Itoa(l.OpenFiles))
+		}
+		if l.SetNice {
+			helper = append(helper, "-nice", strconv.Itoa(l.Nice))
+		}
+		if l.IOClass != "" {
+			helper = append(helper, "-ionice", fmt.Sprintf("%s:%d", l.IOClass, l.IOLevel))
+		}
+		argv = append(append(helper, "--"), argv...)
+	}
+
+	if l.scopeLimits() && underSystemd() {
+		scope := []string{"systemd-run", "--scope", "--quiet", "--collect", "--description=go-toy job " + job}
+		if os.Getuid() != 0 {
+			scope = append(scope, "--user")
+		}
+		if l.MemoryMax != "" {
+			scope = append(scope, "--property=MemoryMax="+l.MemoryMax)
+		}
+		if l.CPUQuota != "" {
+			scope = append(scope, "--property=CPUQuota="+l.CPUQuota)
+		}
+		argv = append(append(scope, "--"), argv...)
+	}
+	return argv, nil
+}
+
+// limitDescription explains an exceeded limit for the run's error.
+func (l ResourceLimits) limitDescription(limit string) string {
+	switch limit {
+	case limitCPU:
+		return fmt.Sprintf("exceeded CPU time limit of %s", l.CPUTime)
+	case limitMemory:
+		return fmt.Sprintf("killed at memory limit %s", l.MemoryMax)
+	}
+	return "exceeded a resource limit"
+}
+
+// runLimitExec implements the limit-exec helper: it applies the limits given
+// as flags to itself and then executes the command after "--" in its place.
+func runLimitExec(args []string) {
+	fs := flag.NewFlagSet(limitExecCommand, flag.ContinueOnError)
+	var l processLimitFlags
+	fs.IntVar(&l.cpuSeconds, "cpu", 0, "CPU time limit in seconds")
+	fs.IntVar(&l.addressSpaceMB, "as-mb", 0, "address space limit in MiB")
+	fs.IntVar(&l.openFiles, "nofile", 0, "open file limit")
+	fs.StringVar(&l.nice, "nice", "", "scheduling priority")
+	fs.StringVar(&l.ionice, "ionice", "", "I/O class and level")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] -- program [args...]\n", limitExecCommand)
+		os.Exit(127)
+	}
+
+	argv := fs.Args()
+	path, err := exec.LookPath(argv[0])
+	// On Linux the nice value and I/O priority belong to the calling thread,
+	// and exec keeps only that thread. Stay on it from setting them until
+	// exec; the thread is never unlocked, as the helper execs or exits.
+	runtime.LockOSThread()
+	if err == nil {
+		err = applyProcessLimits(l)
+	}
+	if err == nil {
+		err = execProcess(path, argv)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", limitExecCommand, err)
+	os.Exit(127)
+}
+
+// processLimitFlags are the limits passed to the limit-exec helper.
+type processLimitFlags struct {
+	cpuSeconds     int
+	addressSpaceMB int
+	openFiles      int
+	nice           string
+	ionice         string
+}
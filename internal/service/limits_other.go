@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Resource limits are implemented for Linux and macOS only.
+
+func applyProcessLimits(l processLimitFlags) error {
+	return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+}
+
+func execProcess(path string, argv []string) error {
+	return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+}
+
+func (l ResourceLimits) exceeded(state *os.ProcessState, killedByRunner bool) string {
+	return ""
+}
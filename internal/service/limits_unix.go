@@ -0,0 +1,100 @@
+//go:build linux || darwin
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func applyProcessLimits(l processLimitFlags) error {
+	rlimits := []struct {
+		resource int
+		name     string
+		value    uint64
+		// slack is added to the hard limit, so the process first gets a
+		// catchable signal at the soft one.
+		slack uint64
+	}{
+		{syscall.RLIMIT_CPU, "CPU time", uint64(l.cpuSeconds), 5},
+		{syscall.RLIMIT_AS, "address space", uint64(l.addressSpaceMB) << 20, 0},
+		{syscall.RLIMIT_NOFILE, "open files", uint64(l.openFiles), 0},
+	}
+	for _, r := range rlimits {
+		if r.value == 0 {
+			continue
+		}
+		// Raising the hard limit needs privileges, so stay within the
+		// current one; the job cannot use more than that anyway.
+		var cur syscall.Rlimit
+		if err := syscall.Getrlimit(r.resource, &cur); err != nil {
+			return fmt.Errorf("failed to get %s limit: %w", r.name, err)
+		}
+		hard := min(r.value+r.slack, cur.Max)
+		lim := &syscall.Rlimit{Cur: min(r.value, hard), Max: hard}
+		if err := syscall.Setrlimit(r.resource, lim); err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", r.name, err)
+		}
+	}
+
+	if l.nice != "" {
+		n, err := strconv.Atoi(l.nice)
+		if err != nil {
+			return fmt.Errorf("invalid nice value %q", l.nice)
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, n); err != nil {
+			return fmt.Errorf("failed to set nice value %d: %w", n, err)
+		}
+	}
+
+	if l.ionice != "" {
+		class, level, _ := strings.Cut(l.ionice, ":")
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			return fmt.Errorf("invalid ionice value %q", l.ionice)
+		}
+		if err := setIOPriority(class, n); err != nil {
+			return fmt.Errorf("failed to set I/O priority %s: %w", l.ionice, err)
+		}
+	}
+	return nil
+}
+
+func execProcess(path string, argv []string) error {
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// exceeded tells which limit, if any, ended the run described by state.
+// killedByRunner is set when the runner itself killed the process group.
+// Only the CPU time and memory limits are recognised: the address space and
+// open file limits make calls fail inside the job, which its exit status
+// does not reveal.
+func (l ResourceLimits) exceeded(state *os.ProcessState, killedByRunner bool) string {
+	if state == nil {
+		return ""
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+	killed := status.Signaled() && status.Signal() == syscall.SIGKILL && !killedByRunner
+
+	if l.CPUTime > 0 {
+		// The soft limit sends SIGXCPU, which a shell in between reports as
+		// an exit code; the hard limit a little later sends SIGKILL.
+		used := state.UserTime() + state.SystemTime()
+		if (status.Signaled() && status.Signal() == syscall.SIGXCPU) ||
+			status.ExitStatus() == 128+int(syscall.SIGXCPU) ||
+			(killed && used >= l.CPUTime) {
+			return limitCPU
+		}
+	}
+	// Inside the scope, the OOM killer is the only other sender of SIGKILL.
+	if l.MemoryMax != "" && underSystemd() && killed {
+		return limitMemory
+	}
+	return ""
+}
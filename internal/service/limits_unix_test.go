@@ -0,0 +1,53 @@
+//go:build linux || darwin
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestApplyProcessLimitsWithinHardLimit sets limits in a child process, as
+// limit-exec would, so the test binary keeps its own.
+func TestApplyProcessLimitsWithinHardLimit(t *testing.T) {
+	if os.Getenv("GOTOY_TEST_APPLY_LIMITS") == "1" {
+		var before syscall.Rlimit
+		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &before)
+		// Lower the hard limit first, so raising it would need privileges.
+		hard := min(before.Max, 4096)
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: min(before.Cur, hard), Max: hard}); err != nil {
+			fmt.Println("setup:", err)
+			os.Exit(1)
+		}
+		if err := applyProcessLimits(processLimitFlags{openFiles: 1 << 20, cpuSeconds: 3600}); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		var files, cpu syscall.Rlimit
+		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &files)
+		syscall.Getrlimit(syscall.RLIMIT_CPU, &cpu)
+		fmt.Printf("files %d/%d want %d, cpu %d/%d\n", files.Cur, files.Max, hard, cpu.Cur, cpu.Max)
+		os.Exit(0)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApplyProcessLimitsWithinHardLimit$")
+	cmd.Env = append(os.Environ(), "GOTOY_TEST_APPLY_LIMITS=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	var filesCur, filesMax, want, cpuCur, cpuMax uint64
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "files %d/%d want %d, cpu %d/%d", &filesCur, &filesMax, &want, &cpuCur, &cpuMax); err != nil {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if filesCur != want || filesMax != want {
+		t.Errorf("open files limit = %d/%d, want %d/%d", filesCur, filesMax, want, want)
+	}
+	if cpuCur != 3600 || cpuMax > 3605 {
+		t.Errorf("CPU limit = %d/%d, want 3600/3605 or less", cpuCur, cpuMax)
+	}
+}
@@ -75,9 +75,9 @@ func (l *linuxService) Stop() error {
 func (l *linuxService) Status() (string, error) {
 	switch l.preferredScope() {
 	case scopeUser:
-		return l.statusUser()
+		return withRunnerPID(l.statusUser())
 	case scopeSystem:
-		return l.statusSystem()
+		return withRunnerPID(l.statusSystem())
 	default:
 		return "Not installed", nil
 	}
@@ -102,14 +102,16 @@ Description=Task Runner Service
 After=network.target
 
 [Service]
-Type=simple
+Type=notify
 ExecStart=%s run
+ExecReload=/bin/kill -HUP $MAINPID
+WatchdogSec=%d
 Restart=on-failure
 RestartSec=10
 
 [Install]
 WantedBy=default.target
-`, execPath)
+`, execPath, int(watchdogUnitInterval.Seconds()))
 
 	if err := os.WriteFile(serviceFile, []byte(serviceContent), 0644); err != nil {
 		return fmt.Errorf("failed to write user service file: %w", err)
@@ -154,16 +156,18 @@ Description=Task Runner Service
 After=network.target
 
 [Service]
-Type=simple
+Type=notify
 User=%s
 Environment="HOME=%s"
 ExecStart=%s run
+ExecReload=/bin/kill -HUP $MAINPID
+WatchdogSec=%d
 Restart=on-failure
 RestartSec=10
 
 [Install]
 WantedBy=multi-user.target
-`, currentUser, homeDir, execPath)
+`, currentUser, homeDir, execPath, int(watchdogUnitInterval.Seconds()))
 
 	// Write service file (requires sudo)
 	serviceFile := getSystemServicePath()
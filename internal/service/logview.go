@@ -0,0 +1,458 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+const (
+	// Sources of merged log entries.
+	logSourceFile    = "file"
+	logSourceJournal = "journal"
+
+	defaultLogPageSize = 100
+	maxLogPageSize     = 1000
+
+	journalReadTimeout = 10 * time.Second
+	// journalMaxBatches bounds how many times a page reads further back in
+	// the journal to make up for the runner's own entries it skips.
+	journalMaxBatches = 5
+)
+
+// LogQuery selects a page of the merged log, newest entries first.
+type LogQuery struct {
+	// Before is the Next cursor of the previous page; empty starts with the
+	// newest entries.
+	Before string `json:"before,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	// Level is the least severe level included.
+	Level string `json:"level,omitempty"`
+	// Job keeps the entries of one job only. Journal entries carry no job,
+	// so none are included.
+	Job string `json:"job,omitempty"`
+}
+
+// LogPage is one page of the merged log.
+type LogPage struct {
+	Entries []LogStreamEntry `json:"entries"`
+	// Next is the cursor of the following, older page, or empty after the
+	// oldest entry.
+	Next string `json:"next,omitempty"`
+	// Journal is the scope of the journal read, "user" or "system", or empty
+	// when the runner is not installed as a systemd unit.
+	Journal string `json:"journal,omitempty"`
+	// JournalError says why the journal could not be read; the page then
+	// holds log file entries only.
+	JournalError string `json:"journal_error,omitempty"`
+}
+
+// LogStreamEntry is an entry of the runner log file or a journal entry of
+// the runner's unit, such as a line it wrote to stderr or a restart.
+type LogStreamEntry struct {
+	Time    time.Time  `json:"time"`
+	Level   string     `json:"level"`
+	Source  string     `json:"source"`
+	Message string     `json:"message"`
+	Fields  []LogField `json:"fields,omitempty"`
+}
+
+// LogField is a key-value pair of a log entry.
+type LogField struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// logCursor is the position after the last entry of a page: entries before
+// Time, and those at Time after the first Skip of them.
+type logCursor struct {
+	Time time.Time
+	Skip int
+}
+
+func (c logCursor) String() string {
+	return fmt.Sprintf("%d:%d", c.Time.UnixNano(), c.Skip)
+}
+
+func parseLogCursor(s string) (logCursor, error) {
+	ns, skip, ok := strings.Cut(s, ":")
+	t, err1 := strconv.ParseInt(ns, 10, 64)
+	n, err2 := strconv.Atoi(skip)
+	if !ok || err1 != nil || err2 != nil || n < 0 {
+		return logCursor{}, fmt.Errorf("invalid log cursor %q", s)
+	}
+	return logCursor{Time: time.Unix(0, t), Skip: n}, nil
+}
+
+// ReadLogStream returns a page of the runner log file, rotated backups
+// included, merged by time with the journal of the runner's systemd unit.
+// The log file has times to the second in the text format, so entries of
+// the same second may interleave out of order.
+func ReadLogStream(q LogQuery) (*LogPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLogPageSize
+	}
+	q.Limit = min(q.Limit, maxLogPageSize)
+	minLevel := shared.LevelDebug
+	if q.Level != "" {
+		var err error
+		if minLevel, err = shared.ParseLevel(q.Level); err != nil {
+			return nil, err
+		}
+	}
+	var cursor logCursor
+	if q.Before != "" {
+		var err error
+		if cursor, err = parseLogCursor(q.Before); err != nil {
+			return nil, err
+		}
+	}
+
+	keep := func(e LogStreamEntry) bool {
+		if !cursor.Time.IsZero() && e.Time.After(cursor.Time) {
+			return false
+		}
+		if level, _ := shared.ParseLevel(e.Level); level < minLevel {
+			return false
+		}
+		return q.Job == "" || slices.Contains(e.Fields, LogField{Key: "job", Value: q.Job})
+	}
+	// Each source contributes at most the entries the page needs, counting
+	// those at the cursor time that were on the previous page, and one more
+	// to tell whether there is a next page.
+	need := q.Limit + cursor.Skip + 1
+
+	entries, err := readLogFiles(need, keep)
+	if err != nil {
+		return nil, err
+	}
+	page := &LogPage{}
+	if q.Job == "" {
+		scope := journalScope()
+		page.Journal = scope
+		if scope != "" {
+			journal, err := readJournal(scope, cursor.Time, need, minLevel, keep)
+			if err != nil {
+				page.JournalError = err.Error()
+			}
+			entries = append(entries, journal...)
+		}
+	}
+
+	// Both sources are newest first; a stable sort keeps file entries ahead
+	// of journal entries of the same time.
+	slices.SortStableFunc(entries, func(a, b LogStreamEntry) int { return b.Time.Compare(a.Time) })
+	skipped := 0
+	for skipped < cursor.Skip && skipped < len(entries) && entries[skipped].Time.Equal(cursor.Time) {
+		skipped++
+	}
+	entries = entries[skipped:]
+	if len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+		last := entries[len(entries)-1].Time
+		next := logCursor{Time: last}
+		if last.Equal(cursor.Time) {
+			next.Skip = skipped
+		}
+		for _, e := range entries {
+			if e.Time.Equal(last) {
+				next.Skip++
+			}
+		}
+		page.Next = next.String()
+	}
+	page.Entries = entries
+	if page.Entries == nil {
+		page.Entries = []LogStreamEntry{}
+	}
+	return page, nil
+}
+
+// readLogFiles returns up to limit entries accepted by keep, newest first,
+// reading the log file and then its rotated backups from newest to oldest.
+func readLogFiles(limit int, keep func(LogStreamEntry) bool) ([]LogStreamEntry, error) {
+	files, err := logFiles(shared.GetLogPath())
+	if err != nil {
+		return nil, err
+	}
+	var out []LogStreamEntry
+	for _, path := range files {
+		entries, err := readLogFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := fileLogEntry(entries[i])
+			if !keep(e) {
+				continue
+			}
+			out = append(out, e)
+			if len(out) == limit {
+				return out, nil
+			}
+		}
+	}
+	return out, nil
+}
+
+// logFiles returns the log file followed by the backups lumberjack rotated
+// it to, named toy-service-<time>.log and compressed to .log.gz, newest
+// first.
+func logFiles(logPath string) ([]string, error) {
+	dir := filepath.Dir(logPath)
+	ext := filepath.Ext(logPath)
+	prefix := strings.TrimSuffix(filepath.Base(logPath), ext) + "-"
+	names, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list log files: %w", err)
+	}
+	var backups []string
+	for _, entry := range names {
+		name := entry.Name()
+		if strings.HasPrefix(name, prefix) && (strings.HasSuffix(name, ext) || strings.HasSuffix(name, ext+".gz")) {
+			backups = append(backups, name)
+		}
+	}
+	// The time in the name sorts the backups.
+	slices.Sort(backups)
+	slices.Reverse(backups)
+	files := []string{logPath}
+	for _, name := range backups {
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// readLogFile parses the entries in path, oldest first. Lines that do not
+// start an entry continue the message of the one before.
+func readLogFile(path string) ([]shared.LogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var entries []shared.LogEntry
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			if e, ok := shared.ParseLogLine(line); ok {
+				entries = append(entries, e)
+			} else if n := len(entries); n > 0 {
+				entries[n-1].Message += "\n" + line
+			}
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+}
+
+func fileLogEntry(e shared.LogEntry) LogStreamEntry {
+	out := LogStreamEntry{Time: e.Time, Level: e.Level.String(), Source: logSourceFile, Message: e.Message}
+	for _, f := range e.Fields {
+		out.Fields = append(out.Fields, LogField{Key: f.Key, Value: e.Field(f.Key)})
+	}
+	return out
+}
+
+// journalScope returns the scope of the runner's systemd unit, or "" if it
+// has none.
+func journalScope() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	switch (&linuxService{}).preferredScope() {
+	case scopeUser:
+		return "user"
+	case scopeSystem:
+		return "system"
+	}
+	return ""
+}
+
+// journalRecord holds the fields of a journalctl -o json entry used here.
+type journalRecord struct {
+	Cursor     string          `json:"__CURSOR"`
+	Realtime   string          `json:"__REALTIME_TIMESTAMP"`
+	Message    json.RawMessage `json:"MESSAGE"`
+	Priority   string          `json:"PRIORITY"`
+	Identifier string          `json:"SYSLOG_IDENTIFIER"`
+	Transport  string          `json:"_TRANSPORT"`
+	PID        string          `json:"_PID"`
+}
+
+// readJournal returns up to limit entries of the runner's unit accepted by
+// keep, newest first, at or before until if set. Entries the runner log
+// forwarded to the journal or syslog are left out: the log file has them.
+func readJournal(scope string, until time.Time, limit int, minLevel shared.Level, keep func(LogStreamEntry) bool) ([]LogStreamEntry, error) {
+	var out []LogStreamEntry
+	seen := map[string]bool{}
+	batch := max(limit, defaultLogPageSize)
+	for range journalMaxBatches {
+		records, err := journalctl(scope, until, batch, minLevel)
+		if err != nil {
+			return out, err
+		}
+		for _, rec := range records {
+			if seen[rec.Cursor] {
+				continue
+			}
+			seen[rec.Cursor] = true
+			if rec.Identifier == shared.LogIdentifier && (rec.Transport == "journal" || rec.Transport == "syslog") {
+				continue
+			}
+			e, ok := journalEntry(rec)
+			if !ok || !keep(e) {
+				continue
+			}
+			out = append(out, e)
+			if len(out) == limit {
+				return out, nil
+			}
+		}
+		if len(records) < batch {
+			break
+		}
+		// Read on from the oldest entry so far; it is skipped as seen.
+		last, ok := journalTime(records[len(records)-1])
+		if !ok {
+			break
+		}
+		until = last
+	}
+	return out, nil
+}
+
+// journalctl reads up to n entries of the runner's unit, newest first.
+func journalctl(scope string, until time.Time, n int, minLevel shared.Level) ([]journalRecord, error) {
+	args := []string{"-u", serviceName, "-o", "json", "--no-pager", "-r",
+		"-n", strconv.Itoa(n), "-p", strconv.Itoa(minLevel.SyslogSeverity())}
+	if scope == "user" {
+		args = append([]string{"--user"}, args...)
+	}
+	if !until.IsZero() {
+		args = append(args, "--until", fmt.Sprintf("@%d.%06d", until.Unix(), until.Nanosecond()/1000))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), journalReadTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to read the journal: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("failed to read the journal: %w", err)
+	}
+
+	var records []journalRecord
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse journal entry: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func journalTime(rec journalRecord) (time.Time, bool) {
+	us, err := strconv.ParseInt(rec.Realtime, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(us), true
+}
+
+// journalEntry converts rec. Lines the runner wrote to stdout or stderr in
+// a log format, when it could not write its log file, keep their level and
+// fields.
+func journalEntry(rec journalRecord) (LogStreamEntry, bool) {
+	t, ok := journalTime(rec)
+	if !ok {
+		return LogStreamEntry{}, false
+	}
+	e := LogStreamEntry{Time: t, Level: journalLevel(rec.Priority).String(), Source: logSourceJournal, Message: journalMessage(rec.Message)}
+	if rec.Identifier != "" {
+		e.Fields = append(e.Fields, LogField{Key: "identifier", Value: rec.Identifier})
+	}
+	if rec.PID != "" {
+		e.Fields = append(e.Fields, LogField{Key: "pid", Value: rec.PID})
+	}
+	if rec.Transport == "stdout" {
+		if parsed, ok := shared.ParseLogLine(e.Message); ok {
+			fields := fileLogEntry(parsed).Fields
+			e.Level, e.Message, e.Fields = parsed.Level.String(), parsed.Message, append(e.Fields, fields...)
+		}
+	}
+	return e, true
+}
+
+// journalMessage decodes MESSAGE, which journalctl writes as an array of
+// bytes when it is not valid UTF-8.
+func journalMessage(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var b []byte
+	var ints []int
+	if err := json.Unmarshal(raw, &ints); err == nil {
+		for _, c := range ints {
+			b = append(b, byte(c))
+		}
+	}
+	return strings.ToValidUTF8(string(b), "�")
+}
+
+// journalLevel maps a syslog PRIORITY to the nearest level.
+func journalLevel(priority string) shared.Level {
+	p, err := strconv.Atoi(priority)
+	switch {
+	case err != nil:
+		return shared.LevelInfo
+	case p <= 3:
+		return shared.LevelError
+	case p == 4:
+		return shared.LevelWarn
+	case p <= 6:
+		return shared.LevelInfo
+	}
+	return shared.LevelDebug
+}
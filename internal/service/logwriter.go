@@ -0,0 +1,60 @@
+package service
+
+import (
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// rotatingLog is the runner's log writer. It wraps a lumberjack logger that
+// can be swapped for a new one when rotation settings are reloaded.
+type rotatingLog struct {
+	mu       sync.Mutex
+	filename string
+	opts     LogOptions
+	logger   *lumberjack.Logger
+}
+
+func newRotatingLog(filename string, opts LogOptions) *rotatingLog {
+	r := &rotatingLog{filename: filename}
+	r.apply(opts)
+	return r
+}
+
+func (r *rotatingLog) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.logger.Write(p)
+}
+
+// Apply switches to new rotation settings. It reports whether any of them
+// changed.
+func (r *rotatingLog) Apply(opts LogOptions) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.logger != nil && opts.rotation() == r.opts.rotation() {
+		return false
+	}
+	r.apply(opts)
+	return true
+}
+
+func (r *rotatingLog) apply(opts LogOptions) {
+	if r.logger != nil {
+		_ = r.logger.Close()
+	}
+	r.opts = opts
+	r.logger = &lumberjack.Logger{
+		Filename:   r.filename,
+		MaxSize:    opts.MaxSizeMB,
+		MaxBackups: opts.MaxBackups,
+		MaxAge:     opts.MaxAgeDays,
+		Compress:   opts.Compress,
+	}
+}
+
+func (r *rotatingLog) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.logger.Close()
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// Metrics are exposed in the Prometheus text exposition format (version
+// 0.0.4). The format is simple enough that writing it by hand beats pulling
+// in the client library and its dependencies.
+
+const (
+	defaultMetricsListen = "127.0.0.1:9765"
+	metricsContentType   = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the job run duration
+// histogram. They span quick checks up to hour-long batch jobs.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+// MetricsOptions configures the metrics listener ([metrics] section).
+type MetricsOptions struct {
+	Enabled bool
+	Listen  string
+}
+
+// checkMetricsListen validates a host:port listen setting. Unlike the HTTP
+// API, metrics may be served on any interface so remote scrapers can reach
+// them.
+func checkMetricsListen(listen string) error {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("want host:port, got %q", listen)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// jobMetrics accumulates the runs of one job.
+type jobMetrics struct {
+	runs     map[string]uint64 // by status
+	failures uint64
+	buckets  []uint64 // one per durationBuckets entry, not cumulative
+	count    uint64
+	sum      float64
+	lastEnd  time.Time
+}
+
+// runMetrics records completed runs for the metrics endpoint. It is a
+// RunRecorder; counters survive reloads and are only reset by a restart.
+type runMetrics struct {
+	mu   sync.Mutex
+	jobs map[string]*jobMetrics
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{jobs: make(map[string]*jobMetrics)}
+}
+
+func (m *runMetrics) Record(rec *RunRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jm, ok := m.jobs[rec.Job]
+	if !ok {
+		jm = &jobMetrics{
+			runs:    make(map[string]uint64),
+			buckets: make([]uint64, len(durationBuckets)),
+		}
+		m.jobs[rec.Job] = jm
+	}
+	jm.runs[rec.Status]++
+	if rec.Status == runStatusFailed || rec.Status == runStatusTimeout {
+		jm.failures++
+	}
+	if rec.Status == runStatusSkipped {
+		return nil
+	}
+
+	seconds := rec.Duration().Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			jm.buckets[i]++
+			break
+		}
+	}
+	jm.count++
+	jm.sum += seconds
+	jm.lastEnd = rec.End
+	return nil
+}
+
+// metricsWriter writes metric families in the text format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels alternate names and values.
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	mw.w.WriteString(name)
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+			fmt.Fprintf(mw.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		mw.w.WriteByte('}')
+	}
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	mw.w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// writeMetrics renders every runner metric to w.
+func (r *runner) writeMetrics(w io.Writer) error {
+	mw := metricsWriter{w: bufio.NewWriter(w)}
+	now := time.Now()
+
+	mw.family("gotoy_build_info", "gauge", "Runner version.")
+	mw.sample("gotoy_build_info", 1, "version", shared.Version, "goversion", runtime.Version())
+
+	mw.family("gotoy_runner_start_time_seconds", "gauge", "Unix time the runner started.")
+	mw.sample("gotoy_runner_start_time_seconds", float64(r.startedAt.UnixNano())/1e9)
+	mw.family("gotoy_runner_uptime_seconds", "gauge", "Seconds since the runner started.")
+	mw.sample("gotoy_runner_uptime_seconds", now.Sub(r.startedAt).Seconds())
+
+	mw.family("gotoy_heartbeats_total", "counter", "Heartbeats logged since start.")
+	mw.sample("gotoy_heartbeats_total", float64(r.heartbeats.Load()))
+	if ns := r.lastHeartbeat.Load(); ns != 0 {
+		mw.family("gotoy_last_heartbeat_timestamp_seconds", "gauge", "Unix time of the last heartbeat.")
+		mw.sample("gotoy_last_heartbeat_timestamp_seconds", float64(ns)/1e9)
+	}
+
+	pool := r.scheduler.Pool()
+	mw.family("gotoy_worker_pool_workers", "gauge", "Configured worker goroutines.")
+	mw.sample("gotoy_worker_pool_workers", float64(pool.Workers))
+	mw.family("gotoy_worker_pool_active", "gauge", "Workers currently running a job.")
+	mw.sample("gotoy_worker_pool_active", float64(pool.Active))
+	mw.family("gotoy_worker_pool_queued", "gauge", "Runs waiting for a free worker.")
+	mw.sample("gotoy_worker_pool_queued", float64(pool.Queued))
+	mw.family("gotoy_worker_pool_queue_size", "gauge", "Capacity of the worker queue.")
+	mw.sample("gotoy_worker_pool_queue_size", float64(pool.QueueSize))
+
+	jobs := r.scheduler.Jobs()
+	mw.family("gotoy_job_running", "gauge", "Attempts of the job currently running.")
+	for _, job := range jobs {
+		mw.sample("gotoy_job_running", float64(job.Active), "job", job.Name)
+	}
+	mw.family("gotoy_job_pending", "gauge", "Runs of the job accepted but not started.")
+	for _, job := range jobs {
+		mw.sample("gotoy_job_pending", float64(job.Pending), "job", job.Name)
+	}
+	mw.family("gotoy_job_paused", "gauge", "1 if automatic runs of the job are paused, globally or for the job.")
+	for _, job := range jobs {
+		paused := 0.0
+		if job.Paused {
+			paused = 1
+		}
+		mw.sample("gotoy_job_paused", paused, "job", job.Name)
+	}
+	mw.family("gotoy_job_overlap_skips_total", "counter", "Due runs dropped by the overlap policy or a full queue since the job was loaded.")
+	for _, job := range jobs {
+		mw.sample("gotoy_job_overlap_skips_total", float64(job.Skipped), "job", job.Name)
+	}
+
+	r.metrics.write(mw)
+
+	writeGoMetrics(mw)
+	return mw.w.Flush()
+}
+
+func (m *runMetrics) write(mw metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mw.family("gotoy_job_runs_total", "counter", "Completed job runs by final status.")
+	for _, name := range names {
+		jm := m.jobs[name]
+		statuses := make([]string, 0, len(jm.runs))
+		for status := range jm.runs {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			mw.sample("gotoy_job_runs_total", float64(jm.runs[status]), "job", name, "status", status)
+		}
+	}
+
+	mw.family("gotoy_job_failures_total", "counter", "Job runs that failed or timed out.")
+	for _, name := range names {
+		mw.sample("gotoy_job_failures_total", float64(m.jobs[name].failures), "job", name)
+	}
+
+	mw.family("gotoy_job_last_run_timestamp_seconds", "gauge", "Unix time the last run of the job ended.")
+	for _, name := range names {
+		if end := m.jobs[name].lastEnd; !end.IsZero() {
+			mw.sample("gotoy_job_last_run_timestamp_seconds", float64(end.UnixNano())/1e9, "job", name)
+		}
+	}
+
+	mw.family("gotoy_job_run_duration_seconds", "histogram", "Duration of job runs, skipped runs excluded.")
+	for _, name := range names {
+		jm := m.jobs[name]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += jm.buckets[i]
+			mw.sample("gotoy_job_run_duration_seconds_bucket", float64(cumulative), "job", name, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		mw.sample("gotoy_job_run_duration_seconds_bucket", float64(jm.count), "job", name, "le", "+Inf")
+		mw.sample("gotoy_job_run_duration_seconds_sum", jm.sum, "job", name)
+		mw.sample("gotoy_job_run_duration_seconds_count", float64(jm.count), "job", name)
+	}
+}
+
+// writeGoMetrics writes the Go runtime metrics under their conventional
+// names, so existing dashboards for Go services work unchanged.
+func writeGoMetrics(mw metricsWriter) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	mw.family("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	mw.sample("go_goroutines", float64(runtime.NumGoroutine()))
+	mw.family("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	mw.sample("go_memstats_alloc_bytes", float64(ms.Alloc))
+	mw.family("go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.")
+	mw.sample("go_memstats_heap_inuse_bytes", float64(ms.HeapInuse))
+	mw.family("go_memstats_heap_objects", "gauge", "Number of allocated objects.")
+	mw.sample("go_memstats_heap_objects", float64(ms.HeapObjects))
+	mw.family("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.")
+	mw.sample("go_memstats_sys_bytes", float64(ms.Sys))
+	mw.family("go_memstats_mallocs_total", "counter", "Total number of mallocs.")
+	mw.sample("go_memstats_mallocs_total", float64(ms.Mallocs))
+	mw.family("go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	mw.sample("go_gc_cycles_total", float64(ms.NumGC))
+	mw.family("go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.")
+	mw.sample("go_gc_pause_seconds_total", float64(ms.PauseTotalNs)/1e9)
+}
+
+type metricsServer struct {
+	opts   MetricsOptions
+	server *http.Server
+}
+
+// applyMetrics starts, stops or restarts the metrics listener to match opts.
+// Failures are logged; the runner keeps working without metrics.
+func (r *runner) applyMetrics(opts MetricsOptions) {
+	r.metricsMu.Lock()
+	defer r.metricsMu.Unlock()
+
+	if r.metricsServer != nil {
+		if r.metricsServer.opts == opts {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+		if err := r.metricsServer.server.Shutdown(ctx); err != nil {
+			r.log.Error("Failed to stop metrics listener", "error", err)
+		}
+		cancel()
+		r.metricsServer = nil
+		r.log.Info("Metrics listener stopped")
+	}
+	if !opts.Enabled {
+		return
+	}
+
+	listener, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		r.log.Error("Failed to start metrics listener", "listen", opts.Listen, "error", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		if err := r.writeMetrics(w); err != nil {
+			r.log.Warn("Failed to write metrics", "error", err)
+		}
+	})
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.log.Error("Metrics listener stopped", "error", err)
+		}
+	}()
+	r.metricsServer = &metricsServer{opts: opts, server: server}
+	r.log.Info("Serving metrics", "url", "http://"+opts.Listen+"/metrics")
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	scheduleStateFileName = "schedule.json"
+
+	defaultMisfireLimit = 10
+	// misfireThreshold is how late a scheduled run may start and still count
+	// as on time rather than missed.
+	misfireThreshold = time.Minute
+	// scheduleCheckInterval is how often watchClock compares the wall and
+	// monotonic clocks, and so how often the schedule state is flushed.
+	scheduleCheckInterval = 15 * time.Second
+	// clockJumpThreshold is how far the wall clock may run ahead of the
+	// monotonic clock between two checks before it is reported.
+	clockJumpThreshold = 5 * time.Second
+)
+
+// Misfire policies.
+const (
+	misfireSkip = "skip"
+	misfireOnce = "once"
+	misfireAll  = "all"
+)
+
+// MisfirePolicy decides what happens to scheduled runs that were missed
+// because the runner was stopped or the machine was asleep.
+type MisfirePolicy struct {
+	// Mode is misfireSkip, misfireOnce or misfireAll. Empty means skip.
+	Mode string
+	// Limit caps the runs made up under misfireAll.
+	Limit int
+}
+
+func decodeMisfire(d *sectionDecoder, p *MisfirePolicy) {
+	*p = MisfirePolicy{Mode: misfireSkip, Limit: defaultMisfireLimit}
+	d.choice("misfire", &p.Mode, misfireSkip, misfireOnce, misfireAll)
+	d.integer("misfire_limit", &p.Limit, 1)
+}
+
+// dueSlots returns the schedule slots after last and not after now, oldest
+// first, stopping after max slots. more reports whether slots were left out.
+func dueSlots(schedule Schedule, last, now time.Time, max int) (slots []time.Time, more bool) {
+	for t := schedule.Next(last); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		if len(slots) == max {
+			return slots, true
+		}
+		slots = append(slots, t)
+	}
+	return slots, false
+}
+
+// clockJump returns how much further the wall clock advanced than the
+// monotonic clock between two readings of time.Now. The monotonic clock
+// stops while the machine is suspended, so a large positive value means the
+// machine was asleep (or the clock was set forward).
+func clockJump(before, after time.Time) time.Duration {
+	return after.Round(0).Sub(before.Round(0)) - after.Sub(before)
+}
+
+// fireStore persists the last schedule slot handled for each job, so slots
+// missed while the runner was down can be found on the next start. Changes
+// are kept in memory and written by flush, which the scheduler calls every
+// scheduleCheckInterval, on a clock jump and on Stop; a crash loses at most
+// the slots handled since, which the next start then treats as missed.
+type fireStore struct {
+	path string
+
+	mu    sync.Mutex
+	fires map[string]time.Time
+	dirty bool
+}
+
+type fireStoreFile struct {
+	Jobs map[string]time.Time `json:"jobs"`
+}
+
+func openFireStore(path string) (*fireStore, error) {
+	st := &fireStore{path: path, fires: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("failed to read schedule state: %w", err)
+	}
+	var file fireStoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return st, fmt.Errorf("failed to parse schedule state %s: %w", path, err)
+	}
+	for job, t := range file.Jobs {
+		st.fires[job] = t
+	}
+	return st, nil
+}
+
+// last returns the last slot handled for job, or the zero time.
+func (f *fireStore) last(job string) time.Time {
+	if f == nil {
+		return time.Time{}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fires[job]
+}
+
+// set records t as the last slot handled for job.
+func (f *fireStore) set(job string, t time.Time) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fires[job] = t.Round(0)
+	f.dirty = true
+}
+
+// prune forgets the jobs not in keep.
+func (f *fireStore) prune(keep map[string]Job) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for job := range f.fires {
+		if _, ok := keep[job]; !ok {
+			delete(f.fires, job)
+			f.dirty = true
+		}
+	}
+}
+
+// flush saves the store if it changed since the last flush.
+func (f *fireStore) flush() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(fireStoreFile{Jobs: f.fires}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schedule state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), scheduleStateFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write schedule state: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write schedule state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write schedule state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write schedule state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write schedule state: %w", err)
+	}
+	f.dirty = false
+	return nil
+}
+
+// catchUp applies entry's misfire policy to the slots it missed. onTime
+// tells whether the latest slot is about to run normally. Under misfireAll
+// the make-up runs go one after another, so they do not trip the job's
+// overlap policy.
+func (s *Scheduler) catchUp(ctx context.Context, entry *scheduledJob, missed []time.Time, more, onTime bool) {
+	job := entry.job
+	count := fmt.Sprintf("%d", len(missed))
+	if more {
+		count = "more than " + count
+	}
+	log := s.log.With("job", job.Name, "missed", count, "since", missed[0])
+
+	switch job.Misfire.Mode {
+	case misfireOnce:
+		if onTime {
+			log.Info("Job missed runs, the current run covers them")
+			return
+		}
+		log.Info("Job missed runs, running once to catch up")
+		s.fire(entry, newRunRequest(ctx, triggerCatchUp, ""))
+
+	case misfireAll:
+		n := min(len(missed), job.Misfire.Limit)
+		log.Info("Job missed runs, catching up", "runs", n)
+		for range n {
+			result, err := s.dispatch(entry, newRunRequest(ctx, triggerCatchUp, ""))
+			if err != nil {
+				log.Warn("Job catch-up stopped", "error", err)
+				return
+			}
+			select {
+			case <-result:
+			case <-entry.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+
+	default:
+		log.Info("Job missed runs, skipping them")
+	}
+}
+
+// watchClock compares the wall clock with the monotonic clock every
+// scheduleCheckInterval. Timers run on the monotonic clock, which stands
+// still while the machine sleeps; when the two drift apart the schedule
+// loops are woken to recompute their next run from the wall clock. Each
+// check also saves the schedule state.
+func (s *Scheduler) watchClock(ctx context.Context) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.flushScheduleState()
+		now := time.Now()
+		jump := clockJump(last, now)
+		last = now
+		if jump.Abs() < clockJumpThreshold {
+			continue
+		}
+		s.log.Warn("Wall clock moved against the monotonic clock (suspend or clock change), rescheduling jobs", "jump", jump.Round(time.Second))
+		s.mu.Lock()
+		close(s.clockChanged)
+		s.clockChanged = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+// clockChange returns a channel that is closed on the next detected suspend
+// or clock change.
+func (s *Scheduler) clockChange() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clockChanged
+}
+
+// UseScheduleState makes the scheduler remember the last handled schedule
+// slot of each job in the file at path, so runs missed while the runner was
+// stopped are found on the next start. It must be called before Start.
+func (s *Scheduler) UseScheduleState(path string) error {
+	fires, err := openFireStore(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fires = fires
+	return err
+}
+
+// flushScheduleState saves the last handled schedule slots, if they changed.
+func (s *Scheduler) flushScheduleState() {
+	s.mu.Lock()
+	fires := s.fires
+	s.mu.Unlock()
+	if err := fires.flush(); err != nil {
+		s.log.Error("Failed to save schedule state", "error", err)
+	}
+}
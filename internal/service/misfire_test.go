@@ -0,0 +1,54 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFireStoreFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), scheduleStateFileName)
+	st, err := openFireStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	st.set("backup", slot)
+	st.set("report", slot.Add(time.Hour))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("set wrote the state before flush: %v", err)
+	}
+	if err := st.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	st.prune(map[string]Job{"backup": {}})
+	if err := st.flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openFireStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.last("backup"); !got.Equal(slot) {
+		t.Errorf("last(backup) = %s, want %s", got, slot)
+	}
+	if got := reopened.last("report"); !got.IsZero() {
+		t.Errorf("last(report) = %s after prune, want zero time", got)
+	}
+}
+
+func TestFireStoreFlushUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), scheduleStateFileName)
+	st, err := openFireStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("flush without changes wrote the state: %v", err)
+	}
+}
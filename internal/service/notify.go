@@ -0,0 +1,533 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+const (
+	defaultNotifyRateLimit = 20
+	defaultNotifyDedup     = 10 * time.Minute
+	// notifySendTimeout bounds one delivery to one channel.
+	notifySendTimeout = 30 * time.Second
+	// notifyShutdownWait is how long shutdown waits for deliveries in flight.
+	notifyShutdownWait = 10 * time.Second
+	// notifyOutputLines is how much of a failed run's stderr a notification
+	// quotes.
+	notifyOutputLines = 20
+	// notifyChannelNone in a job's notify key turns notifications off.
+	notifyChannelNone = "none"
+)
+
+// Notification events.
+const (
+	notifyEventFailure  = "failure"
+	notifyEventRecovery = "recovery"
+	notifyEventTest     = "test"
+)
+
+// NotifyOptions holds the notification defaults ([notify] section).
+type NotifyOptions struct {
+	// Channels receive the notifications of jobs without a notify key.
+	Channels []string
+	// RateLimit caps the notifications sent through one channel per hour.
+	// 0 means no limit.
+	RateLimit int
+	// Dedup drops a notification identical to one sent through the same
+	// channel within this window, such as a flapping job failing with the
+	// same error again and again.
+	Dedup time.Duration
+	// Repeat re-sends the failure notification while a job keeps failing.
+	// 0 notifies once per run of failures.
+	Repeat time.Duration
+
+	channelsLine int
+}
+
+// NotifyRoute picks the notifications of one job or workflow.
+type NotifyRoute struct {
+	// Channels overrides the [notify] channels. Nil means the defaults; an
+	// empty list (notify = none) turns notifications off.
+	Channels []string
+	// On lists the events to send: notifyEventFailure, notifyEventRecovery.
+	On []string
+	// After is the number of failed runs in a row that count as a failure.
+	After int
+
+	line int
+}
+
+// ChannelConfig is one [channel "<name>"] section.
+type ChannelConfig struct {
+	Name string
+	Type string
+	Line int
+
+	// SMTP channel: Server is host:port of the relay. TLS is smtpTLSStartTLS
+	// (required), smtpTLSImplicit or smtpTLSNone.
+	Server   string
+	Username string
+	Password string
+	From     string
+	To       []string
+	TLS      string
+
+	// Webhook channel: the notification is POSTed to URL as JSON with the
+	// extra "Name: value" Headers.
+	URL     string
+	Headers []string
+	Timeout time.Duration
+}
+
+// Channel types.
+const (
+	channelSMTP    = "smtp"
+	channelWebhook = "webhook"
+	channelDesktop = "desktop"
+)
+
+func decodeNotifyOptions(d *sectionDecoder, o *NotifyOptions) {
+	o.channelsLine = d.nameList("channels", &o.Channels)
+	d.integer("rate_limit", &o.RateLimit, 0)
+	d.duration("dedup", &o.Dedup)
+	d.duration("repeat", &o.Repeat)
+}
+
+func decodeNotifyRoute(d *sectionDecoder, r *NotifyRoute) {
+	*r = NotifyRoute{On: []string{notifyEventFailure, notifyEventRecovery}, After: 1}
+	r.line = d.nameList("notify", &r.Channels)
+	if slices.Contains(r.Channels, notifyChannelNone) {
+		if len(r.Channels) > 1 {
+			d.errorf(r.line, "notify = none cannot be combined with channels")
+		}
+		r.Channels = []string{}
+	}
+	if line := d.nameList("notify_on", &r.On); line > 0 {
+		for _, event := range r.On {
+			if event != notifyEventFailure && event != notifyEventRecovery {
+				d.errorf(line, "notify_on must list %s and/or %s, got %q", notifyEventFailure, notifyEventRecovery, event)
+			}
+		}
+	}
+	d.integer("notify_after", &r.After, 1)
+}
+
+func decodeChannel(d *sectionDecoder) (ChannelConfig, bool) {
+	ch := ChannelConfig{
+		Name:    d.sec.name,
+		Line:    d.sec.line,
+		TLS:     smtpTLSStartTLS,
+		Timeout: defaultWebhookTimeout,
+	}
+	before := len(d.errs)
+	if ch.Name == notifyChannelNone {
+		d.errorf(d.sec.line, "%q is reserved and cannot name a channel", notifyChannelNone)
+	}
+
+	typeLine := d.required("type", &ch.Type)
+	switch ch.Type {
+	case channelSMTP:
+		d.required("server", &ch.Server)
+		d.str("username", &ch.Username)
+		if line, ok := d.str("password", &ch.Password); ok {
+			if err := checkSecretRefs(ch.Password); err != nil {
+				d.errorf(line, "password: %v", err)
+			}
+		}
+		d.required("from", &ch.From)
+		if d.nameList("to", &ch.To); len(ch.To) == 0 && len(d.errs) == before {
+			d.errorf(d.sec.line, "%s: missing required key %q", d.describe(), "to")
+		}
+		d.choice("tls", &ch.TLS, smtpTLSStartTLS, smtpTLSImplicit, smtpTLSNone)
+	case channelWebhook:
+		if line := d.required("url", &ch.URL); ch.URL != "" {
+			if err := checkWebhookURL(ch.URL); err != nil {
+				d.errorf(line, "invalid url: %v", err)
+			}
+		}
+		for _, e := range d.all("header") {
+			name, _, ok := strings.Cut(e.value, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				d.errorf(e.line, "header must be Name: value, got %q", e.value)
+				continue
+			}
+			if err := checkSecretRefs(e.value); err != nil {
+				d.errorf(e.line, "header %s: %v", name, err)
+				continue
+			}
+			ch.Headers = append(ch.Headers, e.value)
+		}
+		d.duration("timeout", &ch.Timeout)
+	case channelDesktop, "":
+	default:
+		d.errorf(typeLine, "unknown channel type %q", ch.Type)
+	}
+	return ch, len(d.errs) == before
+}
+
+// validateNotify checks that the channels named by [notify] and by jobs and
+// workflows exist.
+func validateNotify(cfg *Config) []error {
+	var errs []error
+	known := make(map[string]bool, len(cfg.Channels))
+	for _, ch := range cfg.Channels {
+		known[ch.Name] = true
+	}
+	check := func(line int, owner string, channels []string) {
+		for _, name := range channels {
+			if !known[name] {
+				errs = append(errs, &ConfigError{Path: cfg.Path, Line: line, Msg: fmt.Sprintf("%s names unknown channel %q", owner, name)})
+			}
+		}
+	}
+	check(cfg.Notify.channelsLine, "[notify]", cfg.Notify.Channels)
+	for _, jc := range cfg.Jobs {
+		check(jc.Notify.line, fmt.Sprintf("job %q", jc.Name), jc.Notify.Channels)
+	}
+	for _, wf := range cfg.Workflows {
+		check(wf.Notify.line, fmt.Sprintf("workflow %q", wf.Name), wf.Notify.Channels)
+	}
+	return errs
+}
+
+// Notification is one message about a job, as delivered to every channel.
+// Webhooks receive it as JSON.
+type Notification struct {
+	Event string `json:"event"`
+	Job   string `json:"job"`
+	RunID string `json:"run_id,omitempty"`
+	// Status and Error describe the run that caused the notification.
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Failures counts the failed runs in a row, including this one for a
+	// failure, before this one for a recovery.
+	Failures int       `json:"failures,omitempty"`
+	Host     string    `json:"host"`
+	Time     time.Time `json:"time"`
+	// Summary is a one-line description; Message adds the details.
+	Summary string `json:"summary"`
+	Message string `json:"message"`
+}
+
+func newNotification(event string, rec *RunRecord, failures int) Notification {
+	host, _ := os.Hostname()
+	n := Notification{
+		Event:    event,
+		Job:      rec.Job,
+		RunID:    rec.ID,
+		Status:   rec.Status,
+		Error:    rec.Error,
+		Failures: failures,
+		Host:     host,
+		Time:     rec.End,
+	}
+
+	var msg strings.Builder
+	switch event {
+	case notifyEventRecovery:
+		n.Summary = fmt.Sprintf("Job %s recovered", rec.Job)
+		runs := "runs"
+		if failures == 1 {
+			runs = "run"
+		}
+		fmt.Fprintf(&msg, "Job %s on %s succeeded at %s after %d failed %s.\n", rec.Job, host, rec.End.Format(time.DateTime), failures, runs)
+	default:
+		n.Summary = fmt.Sprintf("Job %s failed", rec.Job)
+		if failures > 1 {
+			n.Summary = fmt.Sprintf("Job %s failed %d times in a row", rec.Job, failures)
+		}
+		fmt.Fprintf(&msg, "Job %s on %s %s at %s after %s.\n", rec.Job, host, rec.Status, rec.End.Format(time.DateTime), rec.Duration().Round(time.Millisecond))
+		if rec.Error != "" {
+			fmt.Fprintf(&msg, "Error: %s\n", rec.Error)
+		}
+		if tail := lastLines(rec.Stderr, notifyOutputLines); tail != "" {
+			fmt.Fprintf(&msg, "\nLast lines of stderr:\n%s\n", tail)
+		}
+	}
+	fmt.Fprintf(&msg, "Run ID: %s\n", rec.ID)
+	n.Message = shared.Redact(msg.String())
+	n.Error = shared.Redact(n.Error)
+	return n
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jobRoute is the resolved NotifyRoute of a job.
+type jobRoute struct {
+	channels  []string
+	onFailure bool
+	onRecover bool
+	after     int
+	retry     RetryPolicy
+}
+
+// failureStreak follows the failed runs in a row of one job.
+type failureStreak struct {
+	failures int
+	// alerting is set once failures reached the route's threshold, so a
+	// later success counts as a recovery.
+	alerting bool
+	lastSent time.Time
+}
+
+// notifyRouter turns completed runs into notifications. It is a
+// RunRecorder: a job's final attempt that fails moves it towards a failure
+// notification, and a success after one sends a recovery. Deliveries run in
+// the background so slow relays do not hold up the scheduler.
+type notifyRouter struct {
+	log *shared.Logger
+
+	mu       sync.Mutex
+	opts     NotifyOptions
+	channels map[string]notifyChannel
+	routes   map[string]jobRoute
+	streaks  map[string]*failureStreak
+	// sent holds the send times of the last hour per channel, for the rate
+	// limit; recent holds the last send time per channel and message, for
+	// deduplication. Both survive reloads.
+	sent   map[string][]time.Time
+	recent map[string]time.Time
+
+	wg sync.WaitGroup
+}
+
+func newNotifyRouter(log *shared.Logger) *notifyRouter {
+	return &notifyRouter{
+		log:      log,
+		channels: make(map[string]notifyChannel),
+		routes:   make(map[string]jobRoute),
+		streaks:  make(map[string]*failureStreak),
+		sent:     make(map[string][]time.Time),
+		recent:   make(map[string]time.Time),
+	}
+}
+
+// notifySetup is a validated notification configuration ready to apply.
+type notifySetup struct {
+	opts     NotifyOptions
+	channels map[string]notifyChannel
+	routes   map[string]jobRoute
+}
+
+// buildNotify creates the channels and routes of cfg, expanding secret
+// references in channel settings.
+func buildNotify(cfg *Config, secrets map[string]string) (*notifySetup, error) {
+	setup := &notifySetup{
+		opts:     cfg.Notify,
+		channels: make(map[string]notifyChannel, len(cfg.Channels)),
+		routes:   make(map[string]jobRoute),
+	}
+	for _, cc := range cfg.Channels {
+		ch, err := newNotifyChannel(cc, secrets)
+		if err != nil {
+			return nil, &ConfigError{Path: cfg.Path, Line: cc.Line, Msg: fmt.Sprintf("channel %q: %v", cc.Name, err)}
+		}
+		setup.channels[cc.Name] = ch
+	}
+
+	resolve := func(r NotifyRoute, retry RetryPolicy) jobRoute {
+		channels := r.Channels
+		if channels == nil {
+			channels = cfg.Notify.Channels
+		}
+		return jobRoute{
+			channels:  channels,
+			onFailure: slices.Contains(r.On, notifyEventFailure),
+			onRecover: slices.Contains(r.On, notifyEventRecovery),
+			after:     r.After,
+			retry:     retry,
+		}
+	}
+	for _, jc := range cfg.Jobs {
+		if jc.Enabled {
+			setup.routes[jc.Name] = resolve(jc.Notify, jc.Retry)
+		}
+	}
+	for _, wf := range cfg.Workflows {
+		if wf.Enabled {
+			setup.routes[wf.Name] = resolve(wf.Notify, RetryPolicy{MaxAttempts: 1})
+		}
+	}
+	return setup, nil
+}
+
+// Apply switches to the channels and routes of setup. Failure streaks of
+// jobs that still exist are kept.
+func (r *notifyRouter) Apply(setup *notifySetup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opts = setup.opts
+	r.channels = setup.channels
+	r.routes = setup.routes
+	for job := range r.streaks {
+		if _, ok := r.routes[job]; !ok {
+			delete(r.streaks, job)
+		}
+	}
+}
+
+func (r *notifyRouter) Record(rec *RunRecord) error {
+	if rec.Status == runStatusSkipped || rec.Status == runStatusCancelled {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route, ok := r.routes[rec.Job]
+	if !ok || len(route.channels) == 0 {
+		return nil
+	}
+	// Only the last attempt of a run decides; earlier ones are retried.
+	if !route.retry.lastAttempt(rec) {
+		return nil
+	}
+	success := rec.Status == runStatusSuccess
+
+	st := r.streaks[rec.Job]
+	if success {
+		if st != nil && st.alerting && route.onRecover {
+			r.sendLocked(route.channels, newNotification(notifyEventRecovery, rec, st.failures))
+		}
+		delete(r.streaks, rec.Job)
+		return nil
+	}
+
+	if st == nil {
+		st = &failureStreak{}
+		r.streaks[rec.Job] = st
+	}
+	st.failures++
+	now := time.Now()
+	send := false
+	switch {
+	case st.failures == route.after:
+		st.alerting = true
+		send = true
+	case st.alerting && r.opts.Repeat > 0 && now.Sub(st.lastSent) >= r.opts.Repeat:
+		send = true
+	}
+	if send && route.onFailure {
+		st.lastSent = now
+		r.sendLocked(route.channels, newNotification(notifyEventFailure, rec, st.failures))
+	}
+	return nil
+}
+
+// sendLocked delivers n through channels in the background, subject to
+// deduplication and the rate limit.
+func (r *notifyRouter) sendLocked(channels []string, n Notification) {
+	now := time.Now()
+	for key, t := range r.recent {
+		if now.Sub(t) >= r.opts.Dedup {
+			delete(r.recent, key)
+		}
+	}
+	for _, name := range channels {
+		ch, ok := r.channels[name]
+		if !ok {
+			continue
+		}
+
+		log := r.log.With("job", n.Job, "run_id", n.RunID, "channel", name, "event", n.Event)
+		key := name + "\x00" + n.Job + "\x00" + n.Event + "\x00" + n.Error
+		if last, ok := r.recent[key]; ok {
+			log.Info("Notification suppressed: same as an earlier one", "sent_ago", now.Sub(last).Round(time.Second))
+			continue
+		}
+		recent := slices.DeleteFunc(r.sent[name], func(t time.Time) bool { return now.Sub(t) >= time.Hour })
+		if r.opts.RateLimit > 0 && len(recent) >= r.opts.RateLimit {
+			r.sent[name] = recent
+			log.Warn("Notification dropped: rate limit reached", "per_hour", r.opts.RateLimit)
+			continue
+		}
+		r.sent[name] = append(recent, now)
+		r.recent[key] = now
+
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			if err := deliver(ch, n); err != nil {
+				log.Error("Failed to send notification", "error", err)
+				return
+			}
+			log.Info("Sent notification", "summary", n.Summary)
+		}()
+	}
+}
+
+// Wait waits up to timeout for deliveries in flight.
+func (r *notifyRouter) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// TestNotify sends a test notification through the named channel, or through
+// every channel if name is empty, as configured in runner.conf. It waits for
+// the deliveries and returns one result per channel.
+func TestNotify(name string) (map[string]error, error) {
+	configPath, err := getRunnerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var channels []ChannelConfig
+	for _, cc := range cfg.Channels {
+		if name == "" || cc.Name == name {
+			channels = append(channels, cc)
+		}
+	}
+	if len(channels) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("unknown channel %q", name)
+		}
+		return nil, fmt.Errorf("no channels configured in %s", configPath)
+	}
+	secrets, err := loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	n := Notification{
+		Event:   notifyEventTest,
+		Job:     "test",
+		Host:    host,
+		Time:    time.Now(),
+		Summary: "go-toy test notification",
+		Message: fmt.Sprintf("This is a test notification from the go-toy task runner on %s.\n", host),
+	}
+	results := make(map[string]error, len(channels))
+	for _, cc := range channels {
+		ch, err := newNotifyChannel(cc, secrets)
+		if err == nil {
+			err = deliver(ch, n)
+		}
+		results[cc.Name] = err
+	}
+	return results, nil
+}
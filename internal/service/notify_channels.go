@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+
+	// SMTP transport security.
+	smtpTLSStartTLS = "starttls"
+	smtpTLSImplicit = "tls"
+	smtpTLSNone     = "none"
+
+	notifyAppName = "go-toy"
+)
+
+// notifyChannel delivers notifications to one destination.
+type notifyChannel interface {
+	send(ctx context.Context, n Notification) error
+}
+
+// newNotifyChannel creates the channel described by cc.
+func newNotifyChannel(cc ChannelConfig, secrets map[string]string) (notifyChannel, error) {
+	switch cc.Type {
+	case channelSMTP:
+		expanded, _, err := expandSecrets([]string{cc.Password}, secrets)
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(cc.Server)
+		if err != nil {
+			return nil, fmt.Errorf("server must be host:port, got %q", cc.Server)
+		}
+		return &smtpChannel{
+			server:   cc.Server,
+			host:     host,
+			username: cc.Username,
+			password: expanded[0],
+			from:     cc.From,
+			to:       cc.To,
+			tls:      cc.TLS,
+		}, nil
+	case channelWebhook:
+		headers, _, err := expandSecrets(cc.Headers, secrets)
+		if err != nil {
+			return nil, err
+		}
+		ch := &webhookChannel{url: cc.URL, header: make(http.Header), timeout: cc.Timeout}
+		for _, h := range headers {
+			name, value, _ := strings.Cut(h, ":")
+			ch.header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		return ch, nil
+	case channelDesktop:
+		return desktopChannel{}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", cc.Type)
+}
+
+// deliver sends n through ch within notifySendTimeout.
+func deliver(ch notifyChannel, n Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifySendTimeout)
+	defer cancel()
+	return ch.send(ctx, n)
+}
+
+// smtpChannel mails notifications through a relay.
+type smtpChannel struct {
+	server   string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+	tls      string
+}
+
+func (c *smtpChannel) send(ctx context.Context, n Notification) error {
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if c.tls == smtpTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.host}}).DialContext(ctx, "tcp", c.server)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.server)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", c.server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+	if host, err := os.Hostname(); err == nil {
+		if err := client.Hello(host); err != nil {
+			return fmt.Errorf("SMTP HELO failed: %w", err)
+		}
+	}
+	if c.tls == smtpTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS; set tls = none to send unencrypted", c.server)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if c.username != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost.
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(c.from); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	for _, rcpt := range c.to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(c.message(n)); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message formats n as a plain-text mail.
+func (c *smtpChannel) message(n Notification) []byte {
+	var b bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", c.from)
+	header("To", strings.Join(c.to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", "["+notifyAppName+"] "+n.Summary))
+	header("Date", n.Time.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	header("X-Go-Toy-Event", n.Event)
+	header("X-Go-Toy-Job", n.Job)
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(n.Message, "\r\n", "\n"), "\n", "\r\n")
+	b.WriteString(body)
+	return b.Bytes()
+}
+
+// checkWebhookURL accepts absolute http and https URLs.
+func checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("want an http or https URL, got %q", raw)
+	}
+	return nil
+}
+
+// webhookChannel POSTs notifications as JSON.
+type webhookChannel struct {
+	url     string
+	header  http.Header
+	timeout time.Duration
+}
+
+func (c *webhookChannel) send(ctx context.Context, n Notification) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", notifyAppName+"-runner")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// desktopChannel shows notifications on the user's desktop through the
+// freedesktop.org Notifications D-Bus interface.
+type desktopChannel struct{}
+
+// Notification urgency levels of the freedesktop.org specification.
+const (
+	desktopUrgencyNormal   byte = 1
+	desktopUrgencyCritical byte = 2
+)
+
+func (desktopChannel) send(ctx context.Context, n Notification) error {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to connect to the session bus: %w", err)
+	}
+	defer conn.Close()
+
+	urgency := desktopUrgencyNormal
+	if n.Event == notifyEventFailure {
+		urgency = desktopUrgencyCritical
+	}
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency)}
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.CallWithContext(ctx, "org.freedesktop.Notifications.Notify", 0,
+		notifyAppName, uint32(0), "", n.Summary, n.Message, []string{}, hints, int32(-1))
+	if call.Err != nil {
+		return fmt.Errorf("desktop notification failed: %w", call.Err)
+	}
+	return nil
+}
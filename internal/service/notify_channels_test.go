@@ -0,0 +1,259 @@
+package service
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testNotification() Notification {
+	return Notification{
+		Event:    notifyEventFailure,
+		Job:      "backup",
+		RunID:    "r1",
+		Status:   runStatusFailed,
+		Error:    "exit status 1",
+		Failures: 3,
+		Host:     "testhost",
+		Time:     time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC),
+		Summary:  "Job backup failed 3 times in a row",
+		Message:  "Job: backup\nError: exit status 1",
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var got Notification
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ch, err := newNotifyChannel(ChannelConfig{
+		Type:    channelWebhook,
+		URL:     srv.URL,
+		Headers: []string{"Authorization: Bearer ${secret:hook_token}"},
+	}, map[string]string{"hook_token": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := testNotification()
+	if err := deliver(ch, n); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(n.Time) {
+		t.Errorf("time = %s, want %s", got.Time, n.Time)
+	}
+	got.Time = n.Time
+	if got != n {
+		t.Errorf("notification = %+v, want %+v", got, n)
+	}
+	if h := header.Get("Authorization"); h != "Bearer s3cret" {
+		t.Errorf("Authorization = %q, want the expanded secret", h)
+	}
+	if h := header.Get("Content-Type"); h != "application/json" {
+		t.Errorf("Content-Type = %q", h)
+	}
+}
+
+func TestWebhookChannelErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	ch, err := newNotifyChannel(ChannelConfig{Type: channelWebhook, URL: srv.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deliver(ch, testNotification()); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("error = %v, want the 502 status", err)
+	}
+}
+
+func TestWebhookChannelTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ch, err := newNotifyChannel(ChannelConfig{Type: channelWebhook, URL: srv.URL, Timeout: 50 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deliver(ch, testNotification()); err == nil {
+		t.Error("deliver to a hung webhook succeeded")
+	}
+}
+
+// smtpStandIn is a minimal SMTP relay accepting one message per session.
+type smtpStandIn struct {
+	ln       net.Listener
+	starttls bool
+
+	mu       sync.Mutex
+	commands []string
+	auth     string
+	data     string
+}
+
+func startSMTPStandIn(t *testing.T, starttls bool) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{ln: ln, starttls: starttls}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.ToUpper(verb))
+		s.mu.Unlock()
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-localhost")
+			if s.starttls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "HELO":
+			reply("250 localhost")
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			s.mu.Lock()
+			s.auth = string(decoded)
+			s.mu.Unlock()
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL", "RCPT":
+			reply("250 2.1.0 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.mu.Lock()
+			s.data = b.String()
+			s.mu.Unlock()
+			reply("250 2.0.0 Queued")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func TestSMTPChannel(t *testing.T) {
+	relay := startSMTPStandIn(t, false)
+	ch, err := newNotifyChannel(ChannelConfig{
+		Type:     channelSMTP,
+		Server:   relay.ln.Addr().String(),
+		Username: "runner",
+		Password: "${secret:smtp_password}",
+		From:     "runner@example.com",
+		To:       []string{"ops@example.com", "dev@example.com"},
+		TLS:      smtpTLSNone,
+	}, map[string]string{"smtp_password": "pa55"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deliver(ch, testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	want := []string{"EHLO", "AUTH", "MAIL", "RCPT", "RCPT", "DATA", "QUIT"}
+	if strings.Join(relay.commands, " ") != strings.Join(want, " ") {
+		t.Errorf("commands = %v, want %v", relay.commands, want)
+	}
+	if relay.auth != "\x00runner\x00pa55" {
+		t.Errorf("AUTH PLAIN credentials = %q", relay.auth)
+	}
+	for _, want := range []string{
+		"From: runner@example.com\r\n",
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: [go-toy] Job backup failed 3 times in a row\r\n",
+		"X-Go-Toy-Job: backup\r\n",
+		"\r\n\r\nJob: backup\r\nError: exit status 1",
+	} {
+		if !strings.Contains(relay.data, want) {
+			t.Errorf("message lacks %q:\n%s", want, relay.data)
+		}
+	}
+}
+
+func TestSMTPChannelRequiresStartTLS(t *testing.T) {
+	relay := startSMTPStandIn(t, false)
+	ch, err := newNotifyChannel(ChannelConfig{
+		Type:   channelSMTP,
+		Server: relay.ln.Addr().String(),
+		From:   "runner@example.com",
+		To:     []string{"ops@example.com"},
+		TLS:    smtpTLSStartTLS,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deliver(ch, testNotification()); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("error = %v, want STARTTLS to be required", err)
+	}
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	for _, cmd := range relay.commands {
+		if cmd == "MAIL" {
+			t.Error("message sent without STARTTLS")
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+const (
+	// outputReplayBytes bounds the output kept per running command, so a
+	// client that starts following mid-run sees what it missed.
+	outputReplayBytes = 64 * 1024
+	// outputSubscriberBuffer is how many events a follower may fall behind
+	// before events are dropped for it.
+	outputSubscriberBuffer = 256
+)
+
+// Output event types.
+const (
+	outputEventSubscribed = "subscribed"
+	outputEventStart      = "start"
+	outputEventOutput     = "output"
+	outputEventEnd        = "end"
+)
+
+// OutputEvent is one step in the life of a command run as seen by followers:
+// its start, a chunk of its stdout or stderr, or its end.
+type OutputEvent struct {
+	Type  string    `json:"type"`
+	RunID string    `json:"run_id"`
+	Job   string    `json:"job"`
+	Time  time.Time `json:"time"`
+	// RetryOf is the ID of the first attempt when the run is a retry.
+	RetryOf string `json:"retry_of,omitempty"`
+	// Stream is "stdout" or "stderr" for output events.
+	Stream string `json:"stream,omitempty"`
+	Data   string `json:"data,omitempty"`
+	// Status is the final run status for end events.
+	Status string `json:"status,omitempty"`
+	// Dropped counts events this follower missed because it fell behind.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// outputHub fans live command output out to followers. It is a RunRecorder
+// so followers learn when a run ends.
+type outputHub struct {
+	mu   sync.Mutex
+	subs map[*outputSubscriber]struct{}
+	runs map[string]*liveRun
+}
+
+type outputSubscriber struct {
+	job     string
+	events  chan OutputEvent
+	dropped int
+}
+
+// liveRun keeps the recent events of a running command for replay.
+type liveRun struct {
+	events []OutputEvent
+	size   int
+}
+
+func newOutputHub() *outputHub {
+	return &outputHub{
+		subs: make(map[*outputSubscriber]struct{}),
+		runs: make(map[string]*liveRun),
+	}
+}
+
+// subscribe registers a follower of job, or of every job if job is empty.
+// The events of matching runs in progress are replayed first.
+func (h *outputHub) subscribe(job string) *outputSubscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &outputSubscriber{job: job, events: make(chan OutputEvent, outputSubscriberBuffer)}
+	for _, run := range h.runs {
+		if job != "" && run.events[0].Job != job {
+			continue
+		}
+		for _, ev := range run.events {
+			sub.send(ev)
+		}
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+func (h *outputHub) unsubscribe(sub *outputSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// send delivers ev without blocking; a follower that falls behind loses
+// events and is told how many on the next one it receives.
+func (s *outputSubscriber) send(ev OutputEvent) {
+	ev.Dropped = s.dropped
+	select {
+	case s.events <- ev:
+		s.dropped = 0
+	default:
+		s.dropped++
+	}
+}
+
+func (h *outputHub) publish(ev OutputEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch ev.Type {
+	case outputEventStart:
+		h.runs[ev.RunID] = &liveRun{events: []OutputEvent{ev}}
+	case outputEventOutput:
+		if run, ok := h.runs[ev.RunID]; ok {
+			run.events = append(run.events, ev)
+			run.size += len(ev.Data)
+			// Keep the start event; drop the oldest output beyond the limit.
+			for run.size > outputReplayBytes && len(run.events) > 2 {
+				run.size -= len(run.events[1].Data)
+				run.events = append(run.events[:1], run.events[2:]...)
+			}
+		}
+	case outputEventEnd:
+		delete(h.runs, ev.RunID)
+	}
+
+	for sub := range h.subs {
+		if sub.job == "" || sub.job == ev.Job {
+			sub.send(ev)
+		}
+	}
+}
+
+// started announces a command run and returns writers for its stdout and
+// stderr.
+func (h *outputHub) started(rec *RunRecord) (stdout, stderr *outputWriter) {
+	h.publish(OutputEvent{Type: outputEventStart, RunID: rec.ID, Job: rec.Job, Time: time.Now(), RetryOf: rec.RetryOf})
+	return &outputWriter{hub: h, runID: rec.ID, retryOf: rec.RetryOf, job: rec.Job, stream: "stdout"},
+		&outputWriter{hub: h, runID: rec.ID, retryOf: rec.RetryOf, job: rec.Job, stream: "stderr"}
+}
+
+// Record ends the live output of rec's run.
+func (h *outputHub) Record(rec *RunRecord) error {
+	h.mu.Lock()
+	_, live := h.runs[rec.ID]
+	h.mu.Unlock()
+	if live {
+		h.publish(OutputEvent{Type: outputEventEnd, RunID: rec.ID, Job: rec.Job, Time: rec.End, RetryOf: rec.RetryOf, Status: rec.Status})
+	}
+	return nil
+}
+
+// outputWriter publishes everything written to it as output events of one
+// stream. Secrets are hidden as in the run history, but only within one
+// write: a secret split across two writes goes out in clear.
+type outputWriter struct {
+	hub     *outputHub
+	runID   string
+	retryOf string
+	job     string
+	stream  string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.hub.publish(OutputEvent{
+		Type:    outputEventOutput,
+		RunID:   w.runID,
+		Job:     w.job,
+		Time:    time.Now(),
+		RetryOf: w.retryOf,
+		Stream:  w.stream,
+		Data:    shared.Redact(string(p)),
+	})
+	return len(p), nil
+}
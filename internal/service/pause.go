@@ -0,0 +1,147 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const pauseStateFileName = "paused.json"
+
+// PauseState records which scheduling is suspended. It is kept in the runner
+// directory so a paused runner stays paused across restarts.
+type PauseState struct {
+	// All suspends every job.
+	All bool `json:"all"`
+	// Jobs lists individually paused jobs, sorted.
+	Jobs []string `json:"jobs"`
+}
+
+func (st PauseState) clone() PauseState {
+	return PauseState{All: st.All, Jobs: slices.Clone(st.Jobs)}
+}
+
+// with returns a copy of st with job (or everything, if job is empty) paused
+// or resumed.
+func (st PauseState) with(job string, paused bool) PauseState {
+	next := st.clone()
+	if job == "" {
+		next.All = paused
+		return next
+	}
+	i, found := slices.BinarySearch(next.Jobs, job)
+	switch {
+	case paused && !found:
+		next.Jobs = slices.Insert(next.Jobs, i, job)
+	case !paused && found:
+		next.Jobs = slices.Delete(next.Jobs, i, i+1)
+	}
+	return next
+}
+
+func loadPauseState(path string) (PauseState, error) {
+	var st PauseState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("failed to read pause state: %w", err)
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return PauseState{}, fmt.Errorf("failed to parse pause state %s: %w", path, err)
+	}
+	slices.Sort(st.Jobs)
+	st.Jobs = slices.Compact(st.Jobs)
+	return st, nil
+}
+
+// savePauseState replaces the state file atomically.
+func savePauseState(path string, st PauseState) error {
+	if st.Jobs == nil {
+		st.Jobs = []string{}
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pause state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), pauseStateFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write pause state: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write pause state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write pause state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write pause state: %w", err)
+	}
+	return nil
+}
+
+// UpdatePauseState pauses or resumes job (everything, if job is empty) in the
+// state file directly. It is meant for when the runner is not running; the
+// change takes effect when it starts.
+func UpdatePauseState(job string, paused bool) (PauseState, error) {
+	path, err := getPauseStatePath()
+	if err != nil {
+		return PauseState{}, err
+	}
+	st, err := loadPauseState(path)
+	if err != nil {
+		return PauseState{}, err
+	}
+	st = st.with(job, paused)
+	if err := savePauseState(path, st); err != nil {
+		return PauseState{}, err
+	}
+	return st, nil
+}
+
+// setPaused pauses or resumes job (everything, if job is empty) in the
+// scheduler and persists the result.
+func (r *runner) setPaused(job string, paused bool) (PauseState, error) {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+
+	st, err := r.scheduler.SetPaused(job, paused)
+	if err != nil {
+		return PauseState{}, err
+	}
+	if err := savePauseState(r.pausePath, st); err != nil {
+		r.log.Error("Failed to persist pause state", "error", err)
+	}
+
+	what := "all scheduling"
+	if job != "" {
+		what = "job " + job
+	}
+	verb := "Resumed"
+	if paused {
+		verb = "Paused"
+	}
+	r.log.Info(verb + " " + what)
+	r.sdNotify(sdStatus("%s", pauseSummary(st)))
+	return st, nil
+}
+
+// pauseSummary describes st in a few words for status lines.
+func pauseSummary(st PauseState) string {
+	switch {
+	case st.All:
+		return "Scheduling paused"
+	case len(st.Jobs) > 0:
+		return fmt.Sprintf("Running; paused jobs: %v", st.Jobs)
+	default:
+		return "Running"
+	}
+}
@@ -0,0 +1,612 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// Plugins are executables in the plugins directory of the runner that
+// provide job types. The runner talks to a plugin over its stdin and stdout,
+// one JSON object per line; the plugin's stderr is kept as the run's stderr.
+//
+// Every session starts with a handshake: the runner sends
+//
+//	{"type":"hello","protocol":1}
+//
+// and the plugin answers with the same message. The runner then either asks
+// the plugin to describe itself, or to run a job:
+//
+//	-> {"type":"describe"}
+//	<- {"type":"describe","name":"backup","description":"...","params":[
+//	     {"name":"dir","type":"string","required":true},
+//	     {"name":"keep","type":"int","default":"7"}]}
+//
+//	-> {"type":"run","job":"nightly","run_id":"...","trigger":"schedule","params":{"dir":"/srv","keep":7}}
+//	<- {"type":"log","level":"info","message":"copying"}
+//	<- {"type":"progress","percent":40,"message":"2 of 5 files"}
+//	<- {"type":"result","ok":true,"output":"5 files copied"}
+//
+// After the result the runner closes the plugin's stdin and the plugin
+// should exit. To cancel a run, the runner sends {"type":"cancel"}, closes
+// stdin and kills the plugin if it has not exited after a grace period.
+// Messages of unknown types are ignored.
+
+const (
+	pluginsDirName = "plugins"
+
+	pluginProtocolVersion = 1
+
+	pluginDescribeTimeout  = 10 * time.Second
+	pluginDescribeGrace    = time.Second
+	pluginHandshakeTimeout = 10 * time.Second
+	pluginMaxMessageSize   = 1 << 20
+)
+
+// Protocol message types.
+const (
+	pluginMsgHello    = "hello"
+	pluginMsgDescribe = "describe"
+	pluginMsgRun      = "run"
+	pluginMsgCancel   = "cancel"
+	pluginMsgLog      = "log"
+	pluginMsgProgress = "progress"
+	pluginMsgResult   = "result"
+)
+
+// pluginRequest is a message from the runner to a plugin.
+type pluginRequest struct {
+	Type     string         `json:"type"`
+	Protocol int            `json:"protocol,omitempty"`
+	Job      string         `json:"job,omitempty"`
+	RunID    string         `json:"run_id,omitempty"`
+	Trigger  string         `json:"trigger,omitempty"`
+	Params   map[string]any `json:"params,omitempty"`
+}
+
+// pluginMessage is a message from a plugin to the runner. Which fields are
+// set depends on Type.
+type pluginMessage struct {
+	Type     string `json:"type"`
+	Protocol int    `json:"protocol"`
+
+	// describe
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Params      []pluginParam `json:"params"`
+
+	// log and progress
+	Level   string  `json:"level"`
+	Message string  `json:"message"`
+	Percent float64 `json:"percent"`
+
+	// result
+	OK     bool   `json:"ok"`
+	Output string `json:"output"`
+	Error  string `json:"error"`
+}
+
+// pluginParam declares a parameter in a describe message.
+type pluginParam struct {
+	Name        string          `json:"name"`
+	Type        ParamType       `json:"type"`
+	Description string          `json:"description"`
+	Required    bool            `json:"required"`
+	Default     json.RawMessage `json:"default"`
+	Choices     []string        `json:"choices"`
+}
+
+// spec converts p to a ParamSpec. Defaults may be given as a JSON string or
+// as a plain number or boolean.
+func (p pluginParam) spec() (ParamSpec, error) {
+	spec := ParamSpec{
+		Name:        p.Name,
+		Type:        p.Type,
+		Description: p.Description,
+		Required:    p.Required,
+		Choices:     p.Choices,
+	}
+	if len(p.Default) > 0 && string(p.Default) != "null" {
+		if err := json.Unmarshal(p.Default, &spec.Default); err != nil {
+			spec.Default = string(p.Default)
+		}
+	}
+	if p.Name == "" {
+		return spec, fmt.Errorf("parameter without a name")
+	}
+	if len(p.Choices) > 0 && p.Type != ParamString {
+		return spec, fmt.Errorf("parameter %s: only string parameters can have choices", p.Name)
+	}
+	switch p.Type {
+	case ParamString, ParamInt, ParamFloat, ParamBool, ParamDuration, ParamList:
+	default:
+		return spec, fmt.Errorf("parameter %s: unknown type %q", p.Name, p.Type)
+	}
+	if spec.Default != "" {
+		if _, err := spec.parse(spec.Default); err != nil {
+			return spec, fmt.Errorf("parameter %s: invalid default: %v", p.Name, err)
+		}
+	}
+	return spec, nil
+}
+
+// pluginProcess is a running plugin after a successful handshake.
+type pluginProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	msgs    chan pluginMessage
+	done    chan struct{}
+	readErr error // set before msgs is closed
+
+	exited  chan struct{}
+	waitErr error // set before exited is closed
+
+	stopOnce sync.Once
+}
+
+// startPlugin starts the plugin at path and performs the handshake within
+// ctx. stderr receives what the plugin writes to its stderr.
+func startPlugin(ctx context.Context, path string, env []string, stderr io.Writer) (*pluginProcess, error) {
+	cmd := exec.Command(path)
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = stderr
+	// Do not let a backgrounded grandchild holding our pipes block Wait forever.
+	cmd.WaitDelay = defaultKillGrace
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+	// The plugin's stdout goes through an io.Pipe rather than StdoutPipe so
+	// that Wait can be called while messages are still being read; closing
+	// the pipe after Wait ends the reader.
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	p := &pluginProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		msgs:   make(chan pluginMessage),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go p.read(pr)
+	go func() {
+		p.waitErr = cmd.Wait()
+		pw.Close()
+		close(p.exited)
+	}()
+
+	if err := p.send(pluginRequest{Type: pluginMsgHello, Protocol: pluginProtocolVersion}); err != nil {
+		p.stop(0)
+		return nil, err
+	}
+	hsCtx, cancel := context.WithTimeout(ctx, pluginHandshakeTimeout)
+	defer cancel()
+	hello, err := p.expect(hsCtx, pluginMsgHello)
+	if err != nil {
+		p.stop(0)
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	if hello.Protocol != pluginProtocolVersion {
+		p.stop(0)
+		return nil, fmt.Errorf("plugin speaks protocol version %d, the runner speaks %d", hello.Protocol, pluginProtocolVersion)
+	}
+	return p, nil
+}
+
+// read decodes the plugin's messages until its stdout is closed. After an
+// invalid message the rest of the output is discarded.
+func (p *pluginProcess) read(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), pluginMaxMessageSize)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg pluginMessage
+		if err := json.Unmarshal(line, &msg); err != nil || msg.Type == "" {
+			if len(line) > 200 {
+				line = line[:200]
+			}
+			p.readErr = fmt.Errorf("invalid protocol message %q", line)
+			break
+		}
+		select {
+		case p.msgs <- msg:
+		case <-p.done:
+		}
+	}
+	if err := sc.Err(); err != nil && p.readErr == nil {
+		p.readErr = fmt.Errorf("failed to read plugin output: %w", err)
+	}
+	close(p.msgs)
+	io.Copy(io.Discard, r)
+}
+
+func (p *pluginProcess) send(req pluginRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", req.Type, err)
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send %s message: %w", req.Type, err)
+	}
+	return nil
+}
+
+// next returns the next message from the plugin. It returns io.EOF once the
+// plugin has closed its stdout.
+func (p *pluginProcess) next(ctx context.Context) (pluginMessage, error) {
+	select {
+	case msg, ok := <-p.msgs:
+		if !ok {
+			if p.readErr != nil {
+				return msg, p.readErr
+			}
+			return msg, io.EOF
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return pluginMessage{}, ctx.Err()
+	}
+}
+
+// expect returns the next message of type typ, skipping log and unknown
+// messages.
+func (p *pluginProcess) expect(ctx context.Context, typ string) (pluginMessage, error) {
+	for {
+		msg, err := p.next(ctx)
+		if errors.Is(err, io.EOF) {
+			return msg, fmt.Errorf("plugin exited before sending %s", typ)
+		}
+		if err != nil {
+			return msg, err
+		}
+		switch msg.Type {
+		case typ:
+			return msg, nil
+		case pluginMsgHello, pluginMsgDescribe, pluginMsgProgress, pluginMsgResult:
+			return msg, fmt.Errorf("expected a %s message, got %s", typ, msg.Type)
+		}
+	}
+}
+
+// stop closes the plugin's stdin and waits up to grace for it to exit before
+// killing its process group. It returns the plugin's exit error.
+func (p *pluginProcess) stop(grace time.Duration) error {
+	p.stopOnce.Do(func() {
+		// Messages still in flight are dropped.
+		close(p.done)
+		p.stdin.Close()
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-p.exited:
+		case <-timer.C:
+			_ = killProcessGroup(p.cmd.Process)
+			<-p.exited
+		}
+	})
+	return p.waitErr
+}
+
+// describePlugin asks the plugin at path for its name and parameters.
+func describePlugin(path string) (TaskDefinition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+	defer cancel()
+
+	stderr := newTailBuffer(4 << 10)
+	def, err := func() (TaskDefinition, error) {
+		p, err := startPlugin(ctx, path, nil, stderr)
+		if err != nil {
+			return TaskDefinition{}, err
+		}
+		defer p.stop(pluginDescribeGrace)
+		if err := p.send(pluginRequest{Type: pluginMsgDescribe}); err != nil {
+			return TaskDefinition{}, err
+		}
+		msg, err := p.expect(ctx, pluginMsgDescribe)
+		if err != nil {
+			return TaskDefinition{}, err
+		}
+		return pluginDefinition(path, msg)
+	}()
+	if err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			err = fmt.Errorf("%w (stderr: %s)", err, s)
+		}
+		return TaskDefinition{}, err
+	}
+	return def, nil
+}
+
+// pluginDefinition turns a describe message into the plugin's task
+// definition.
+func pluginDefinition(path string, msg pluginMessage) (TaskDefinition, error) {
+	def := TaskDefinition{Name: msg.Name, Description: msg.Description, Path: path}
+	if !taskNamePattern.MatchString(def.Name) {
+		return def, fmt.Errorf("invalid name %q (use lowercase letters, digits, '_' and '-')", def.Name)
+	}
+	seen := make(map[string]bool)
+	for _, pp := range msg.Params {
+		spec, err := pp.spec()
+		if err != nil {
+			return def, err
+		}
+		if seen[spec.Name] {
+			return def, fmt.Errorf("parameter %s declared twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		def.Params = append(def.Params, spec)
+	}
+	return def, nil
+}
+
+// pluginEntry caches what a plugin executable described itself as.
+type pluginEntry struct {
+	modTime time.Time
+	size    int64
+	def     TaskDefinition
+	err     error
+}
+
+var (
+	// pluginScanMu serializes scans of the plugins directory.
+	pluginScanMu sync.Mutex
+	pluginCache  = make(map[string]*pluginEntry)
+
+	pluginMu    sync.RWMutex
+	pluginTasks = make(map[string]TaskDefinition) // by name
+	pluginErrs  = make(map[string]error)          // by path
+)
+
+// scanPlugins scans the plugins directory and asks new and changed
+// executables to describe themselves; unchanged ones are not started again.
+// Plugins that fail to describe themselves, or whose name is taken, are
+// left out and reported by PluginErrors. Describing a plugin starts it, so
+// scans happen only where plugins are expected to change: on runner start
+// and reload, in JobTypes and in the commands that load the configuration
+// outside the runner. Loading the configuration uses the last scan.
+func scanPlugins() {
+	pluginScanMu.Lock()
+	defer pluginScanMu.Unlock()
+
+	dir, err := getPluginsDir()
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		pluginMu.Lock()
+		pluginTasks = make(map[string]TaskDefinition)
+		pluginErrs = map[string]error{dir: fmt.Errorf("failed to read plugins directory: %w", err)}
+		pluginMu.Unlock()
+		return
+	}
+
+	found := make(map[string]*pluginEntry)
+	var paths []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || !isPluginExecutable(info) {
+			continue
+		}
+		entry := pluginCache[path]
+		if entry == nil || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+			def, err := describePlugin(path)
+			entry = &pluginEntry{modTime: info.ModTime(), size: info.Size(), def: def, err: err}
+		}
+		found[path] = entry
+		paths = append(paths, path)
+	}
+	pluginCache = found
+
+	tasks := make(map[string]TaskDefinition)
+	errs := make(map[string]error)
+	for _, path := range paths {
+		entry := found[path]
+		name := entry.def.Name
+		_, builtin := lookupBuiltinTask(name)
+		switch {
+		case entry.err != nil:
+			errs[path] = entry.err
+		case name == jobTypeCommand || name == jobTypeTask || name == jobTypeWorkflow:
+			errs[path] = fmt.Errorf("name %q is a built-in job type", name)
+		case builtin:
+			errs[path] = fmt.Errorf("name %q is taken by a built-in task", name)
+		case tasks[name].Path != "":
+			errs[path] = fmt.Errorf("name %q is taken by %s", name, tasks[name].Path)
+		default:
+			tasks[name] = entry.def
+		}
+	}
+
+	pluginMu.Lock()
+	pluginTasks, pluginErrs = tasks, errs
+	pluginMu.Unlock()
+}
+
+// isPluginExecutable reports whether a file in the plugins directory can be
+// run as a plugin.
+func isPluginExecutable(info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".exe", ".com", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+func lookupPlugin(name string) (TaskDefinition, bool) {
+	pluginMu.RLock()
+	defer pluginMu.RUnlock()
+	def, ok := pluginTasks[name]
+	return def, ok
+}
+
+// PluginErrors describes the plugins that could not be loaded in the last
+// scan of the plugins directory, sorted by path.
+func PluginErrors() []string {
+	pluginMu.RLock()
+	defer pluginMu.RUnlock()
+	msgs := make([]string, 0, len(pluginErrs))
+	for path, err := range pluginErrs {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", path, err))
+	}
+	slices.Sort(msgs)
+	return msgs
+}
+
+// encodePluginParams converts params to JSON values; durations are sent in
+// their string form.
+func encodePluginParams(params TaskParams) map[string]any {
+	values := make(map[string]any, len(params.values))
+	for name, v := range params.values {
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		values[name] = v
+	}
+	return values
+}
+
+// runPlugin runs one job run through a plugin and records the outcome in
+// rec, like runCommand does for command jobs. The plugin's log and progress
+// messages and its result output make up the run's stdout; log messages also
+// go to the runner log.
+func runPlugin(ctx context.Context, def TaskDefinition, params TaskParams, timeout time.Duration, rec *RunRecord, live *outputHub, log *shared.Logger) error {
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	stdout := newTailBuffer(defaultMaxOutputKB * 1024)
+	stderr := newTailBuffer(defaultMaxOutputKB * 1024)
+	var out, errOut io.Writer = stdout, stderr
+	if live != nil {
+		liveOut, liveErr := live.started(rec)
+		out = io.MultiWriter(stdout, liveOut)
+		errOut = io.MultiWriter(stderr, liveErr)
+	}
+	env := []string{
+		commandEnvJobName + "=" + rec.Job,
+		commandEnvRunID + "=" + rec.ID,
+		commandEnvRunTrigger + "=" + rec.Trigger,
+	}
+
+	p, err := startPlugin(runCtx, def.Path, env, errOut)
+	if err == nil {
+		err = p.send(pluginRequest{
+			Type:    pluginMsgRun,
+			Job:     rec.Job,
+			RunID:   rec.ID,
+			Trigger: rec.Trigger,
+			Params:  encodePluginParams(params),
+		})
+	}
+	var result *pluginMessage
+	for err == nil && result == nil {
+		var msg pluginMessage
+		msg, err = p.next(runCtx)
+		if err != nil {
+			break
+		}
+		switch msg.Type {
+		case pluginMsgLog:
+			line := msg.Message
+			if msg.Level != "" {
+				line = msg.Level + ": " + line
+			}
+			fmt.Fprintln(out, line)
+			level, lerr := shared.ParseLevel(msg.Level)
+			if lerr != nil {
+				level = shared.LevelInfo
+			}
+			log.Log(level, msg.Message, "job", rec.Job, "run_id", rec.ID, "plugin", def.Name)
+		case pluginMsgProgress:
+			line := fmt.Sprintf("progress %g%%", msg.Percent)
+			if msg.Message != "" {
+				line += ": " + msg.Message
+			}
+			fmt.Fprintln(out, line)
+		case pluginMsgResult:
+			result = &msg
+		}
+	}
+
+	if p != nil {
+		grace := defaultKillGrace
+		if runCtx.Err() != nil {
+			_ = p.send(pluginRequest{Type: pluginMsgCancel})
+		} else if result == nil {
+			grace = 0
+		}
+		p.stop(grace)
+		if p.cmd.ProcessState != nil {
+			code := p.cmd.ProcessState.ExitCode()
+			rec.ExitCode = &code
+		}
+	}
+	if result != nil && result.Output != "" {
+		io.WriteString(out, result.Output)
+		if !strings.HasSuffix(result.Output, "\n") {
+			io.WriteString(out, "\n")
+		}
+	}
+	// Captured output is kept in the run history, so hide secrets in it.
+	rec.Stdout, rec.Stderr = shared.Redact(stdout.String()), shared.Redact(stderr.String())
+	rec.OutputTruncated = stdout.Truncated() || stderr.Truncated()
+
+	switch {
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		rec.Status = runStatusTimeout
+		return fmt.Errorf("timed out after %s", timeout)
+	case ctx.Err() != nil:
+		rec.Status = runStatusCancelled
+		return fmt.Errorf("cancelled: %w", ctx.Err())
+	case errors.Is(err, io.EOF):
+		rec.Status = runStatusFailed
+		return fmt.Errorf("plugin exited without a result")
+	case err != nil:
+		rec.Status = runStatusFailed
+		return err
+	case !result.OK:
+		rec.Status = runStatusFailed
+		if result.Error == "" {
+			return fmt.Errorf("plugin reported failure")
+		}
+		return errors.New(shared.Redact(result.Error))
+	}
+	rec.Status = runStatusSuccess
+	return nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 64
+)
+
+// PoolStatus is a point-in-time view of the worker pool.
+type PoolStatus struct {
+	Workers   int `json:"workers"`
+	Active    int `json:"active"`
+	Queued    int `json:"queued"`
+	QueueSize int `json:"queue_size"`
+}
+
+// workerPool runs tasks on a fixed number of goroutines fed from a bounded
+// queue. Submitting never blocks: when the queue is full the task is
+// rejected, so a burst of due jobs cannot pile up goroutines.
+type workerPool struct {
+	tasks   chan func()
+	workers int
+	active  atomic.Int64
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{
+		tasks:   make(chan func(), queueSize),
+		workers: workers,
+	}
+	p.wg.Add(workers)
+	for range workers {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		p.active.Add(1)
+		task()
+		p.active.Add(-1)
+	}
+}
+
+// submit queues task and reports whether it was accepted.
+func (p *workerPool) submit(task func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	select {
+	case p.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting tasks and waits for the queued ones to finish.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.tasks)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *workerPool) status() PoolStatus {
+	return PoolStatus{
+		Workers:   p.workers,
+		Active:    int(p.active.Load()),
+		Queued:    len(p.tasks),
+		QueueSize: cap(p.tasks),
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package service
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups are a Unix concept; elsewhere only the direct child is
+// signalled.
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+const (
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 5 * time.Minute
+	defaultRetryMultiplier     = 2.0
+	defaultRetryJitter         = 0.2
+
+	// maxRetryBackoff bounds the delay when retry_max_backoff is 0, so the
+	// exponential stays representable as a Duration.
+	maxRetryBackoff = 24 * time.Hour
+)
+
+// RetryPolicy decides whether and when a failed run is attempted again.
+// The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomises each backoff by up to this fraction in either
+	// direction, so that jobs failing together do not retry in lockstep.
+	Jitter float64
+	// ExitCodes limits retries to these exit codes. Empty means any failure
+	// or timeout is retried.
+	ExitCodes []int
+}
+
+// retryable reports whether the failed attempt rec may be retried.
+func (p RetryPolicy) retryable(rec *RunRecord) bool {
+	switch rec.Status {
+	case runStatusFailed, runStatusTimeout:
+	default:
+		return false
+	}
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+	return rec.ExitCode != nil && slices.Contains(p.ExitCodes, *rec.ExitCode)
+}
+
+// lastAttempt reports whether no retry follows the attempt rec: it
+// succeeded, used up the attempts or failed in a way that is not retried.
+func (p RetryPolicy) lastAttempt(rec *RunRecord) bool {
+	return rec.Status == runStatusSuccess || rec.Attempt >= p.MaxAttempts || !p.retryable(rec)
+}
+
+// backoff returns the delay before attempt number attempt+1, where attempt
+// is the 1-based number of the attempt that just failed.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = maxRetryBackoff
+	}
+	if d > float64(limit) {
+		d = float64(limit)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	// A zero initial backoff times an overflowed power is NaN.
+	if d < 0 || math.IsNaN(d) {
+		d = 0
+	}
+	return time.Duration(d)
+}
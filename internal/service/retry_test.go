@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}, 1, time.Second},
+		{RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}, 4, 8 * time.Second},
+		{RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}, 10, time.Minute},
+		// Without a cap the exponential overflows a Duration long before
+		// the attempt count runs out.
+		{RetryPolicy{InitialBackoff: time.Second, Multiplier: 2}, 100, maxRetryBackoff},
+		{RetryPolicy{InitialBackoff: time.Second, Multiplier: 100}, 1000, maxRetryBackoff},
+		{RetryPolicy{Multiplier: 2}, 2000, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.backoff(tt.attempt); got != tt.want {
+			t.Errorf("%+v: backoff(%d) = %s, want %s", tt.policy, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+	for range 100 {
+		if d := p.backoff(200); d < maxRetryBackoff/2 || d > maxRetryBackoff*3/2 {
+			t.Fatalf("backoff(200) = %s, want within 50%% of %s", d, maxRetryBackoff)
+		}
+	}
+}
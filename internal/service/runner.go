@@ -1,24 +1,33 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"go-toy/internal/shared"
-
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 const (
 	serviceRunnerDirName = ".toy-servicerunner"
 	serviceLogFileName   = "toy-service.log"
+	heartbeatJobName     = "heartbeat"
 	heartbeatInterval    = 10 * time.Second
 	logMaxSizeMB         = 5  // Max size of log file in megabytes
 	logMaxBackups        = 3  // Max number of old log files to retain
 	logMaxAgeDays        = 28 // Max age of log file in days
+
+	defaultSyslogFacility = "daemon"
 )
 
 func Run() {
@@ -31,13 +40,17 @@ func Run() {
 
 	switch os.Args[1] {
 	case "run":
-		runService()
+		if err := runService(); err != nil {
+			fmt.Fprintf(os.Stderr, "Runner failed: %v\n", err)
+			os.Exit(1)
+		}
 	case "install":
 		if err := service.Install(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to install service: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("Service installed successfully")
+		printAPIToken()
 	case "uninstall":
 		if err := service.Uninstall(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to uninstall service: %v\n", err)
@@ -56,6 +69,8 @@ func Run() {
 			os.Exit(1)
 		}
 		fmt.Println("Service stopped successfully")
+	case "check-config":
+		checkConfig()
 	case "status":
 		status, err := service.Status()
 		if err != nil {
@@ -63,6 +78,21 @@ func Run() {
 			os.Exit(1)
 		}
 		fmt.Printf("Service status: %s\n", status)
+		printRunnerStatus()
+	case "pause", "resume":
+		setPausedCommand(os.Args[1] == "pause")
+	case "next-runs":
+		nextRunsCommand()
+	case "secret":
+		secretCommand()
+	case "notify-test":
+		notifyTestCommand()
+	case "job-types":
+		jobTypesCommand()
+	case "log-level":
+		logLevelCommand()
+	case limitExecCommand:
+		runLimitExec(os.Args[2:])
 	default:
 		fmt.Printf("Unknown command: %s\n\n", os.Args[1])
 		printUsage()
@@ -72,63 +102,729 @@ func Run() {
 
 func printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  go-service run        Run as a service")
-	fmt.Println("  go-service install    Install the service")
-	fmt.Println("  go-service uninstall  Uninstall the service")
-	fmt.Println("  go-service start      Start the service")
-	fmt.Println("  go-service stop       Stop the service")
-	fmt.Println("  go-service status     Check service status")
+	fmt.Println("  go-service run           Run as a service")
+	fmt.Println("  go-service install       Install the service")
+	fmt.Println("  go-service uninstall     Uninstall the service")
+	fmt.Println("  go-service start         Start the service")
+	fmt.Println("  go-service stop          Stop the service")
+	fmt.Println("  go-service status        Check service status")
+	fmt.Println("  go-service check-config  Validate the runner configuration file")
+	fmt.Println("  go-service pause [job]   Suspend scheduling of all jobs, or of one job")
+	fmt.Println("  go-service resume [job]  Resume scheduling of all jobs, or of one job")
+	fmt.Println("  go-service next-runs <job> [count]")
+	fmt.Println("                           Preview the next runs of a job as configured")
+	fmt.Println("  go-service secret set <name> [value]")
+	fmt.Println("                           Store a secret; the value is read from stdin if omitted")
+	fmt.Println("  go-service secret list   List the names of the stored secrets")
+	fmt.Println("  go-service secret remove <name>")
+	fmt.Println("                           Delete a secret")
+	fmt.Println("  go-service notify-test [channel]")
+	fmt.Println("                           Send a test notification through one or all channels")
+	fmt.Println("  go-service job-types     List the task and plugin job types and their parameters")
+	fmt.Println("  go-service log-level [level]")
+	fmt.Println("                           Show or change the log level of the running runner")
+}
+
+// printRunnerStatus prints the live runner state, if a runner is reachable.
+func printRunnerStatus() {
+	client, err := NewControlClient()
+	if err != nil {
+		return
+	}
+	st, err := client.Status()
+	if err != nil {
+		return
+	}
+	uptime := time.Duration(st.UptimeSeconds * float64(time.Second)).Round(time.Second)
+	fmt.Printf("Runner: version %s, pid %d, up %s\n", st.Version, st.PID, uptime)
+	fmt.Printf("Workers: %d/%d busy, %d/%d queued\n", st.Pool.Active, st.Pool.Workers, st.Pool.Queued, st.Pool.QueueSize)
+	if st.Paused {
+		fmt.Println("Scheduling: paused")
+	}
+	fmt.Printf("Log level: %s\n", st.LogLevel)
+	if st.LogWriteFailures > 0 {
+		fmt.Printf("Log: %d lines could not be written to the log file and went to stderr\n", st.LogWriteFailures)
+	}
+	for _, sink := range st.LogSinks {
+		fmt.Printf("Log forwarding to %s: %d sent, %d dropped, %d failed", sink.Name, sink.Written, sink.Dropped, sink.Failed)
+		if sink.LastError != "" {
+			fmt.Printf(" (last error: %s)", sink.LastError)
+		}
+		fmt.Println()
+	}
+	for _, job := range st.Jobs {
+		state := "idle"
+		if job.Running {
+			state = "running"
+		} else if job.Paused {
+			state = "paused"
+		}
+		fmt.Printf("  %-20s %-10s %-8s runs=%d pending=%d skipped=%d\n", job.Name, job.Type, state, job.RunCount, job.Pending, job.Skipped)
+	}
+}
+
+// setPausedCommand implements "pause [job]" and "resume [job]". When the
+// runner is not running, the state file is updated for its next start.
+func setPausedCommand(paused bool) {
+	job := ""
+	if len(os.Args) > 2 {
+		job = os.Args[2]
+	}
+
+	var st PauseState
+	client, err := NewControlClient()
+	if err == nil {
+		if paused {
+			st, err = client.Pause(job)
+		} else {
+			st, err = client.Resume(job)
+		}
+	}
+	if errors.Is(err, ErrRunnerNotRunning) {
+		st, err = UpdatePauseState(job, paused)
+		if err == nil {
+			fmt.Println("Runner is not running; the change applies when it starts")
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update pause state: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(pauseSummary(st))
+}
+
+// logLevelCommand implements "log-level [level]". The level set lasts until
+// the runner restarts; the level key of the [log] section sets it for good.
+func logLevelCommand() {
+	client, err := NewControlClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to the runner: %v\n", err)
+		os.Exit(1)
+	}
+	var st LogLevelState
+	if len(os.Args) > 2 {
+		st, err = client.SetLogLevel(os.Args[2])
+	} else {
+		st, err = client.LogLevel()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to query the log level: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Log level: %s (format %s)\n", st.Level, st.Format)
+}
+
+// nextRunsCommand implements "next-runs <job> [count]".
+func nextRunsCommand() {
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "Usage: go-service next-runs <job> [count]")
+		os.Exit(1)
+	}
+	count := defaultPreviewCount
+	if len(os.Args) > 3 {
+		n, err := strconv.Atoi(os.Args[3])
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid count %q\n", os.Args[3])
+			os.Exit(1)
+		}
+		count = n
+	}
+
+	scanPlugins()
+	preview, err := PreviewSchedule(os.Args[2], count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	zone := preview.TimeZone
+	if zone == "" {
+		zone = "system"
+	}
+	fmt.Printf("Job %s: %s (time zone %s)\n", preview.Job, preview.Schedule, zone)
+	for _, window := range preview.Blackout {
+		fmt.Printf("Blackout: %s\n", window)
+	}
+	if preview.Jitter != "" {
+		fmt.Printf("Each run starts up to %s later than shown\n", preview.Jitter)
+	}
+	for _, t := range preview.Times {
+		fmt.Printf("  %s\n", t.Format("Mon 2006-01-02 15:04:05 MST"))
+	}
+	if len(preview.Times) < count {
+		fmt.Println("  (no further runs)")
+	}
+}
+
+// secretCommand implements "secret set|list|remove". A running runner picks
+// up changes on its next reload.
+func secretCommand() {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-service secret set <name> [value] | secret list | secret remove <name>")
+		os.Exit(1)
+	}
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "set":
+		if len(os.Args) < 4 || len(os.Args) > 5 {
+			usage()
+		}
+		var value string
+		if len(os.Args) == 5 {
+			value = os.Args[4]
+		} else {
+			var data []byte
+			if data, err = io.ReadAll(os.Stdin); err != nil {
+				break
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if err = SetSecret(os.Args[3], value); err == nil {
+			fmt.Printf("Secret %s stored\n", os.Args[3])
+			if len(value) < shared.MinRedactedLength {
+				fmt.Fprintf(os.Stderr, "Warning: values shorter than %d characters are not redacted from the log\n", shared.MinRedactedLength)
+			}
+		}
+	case "list":
+		var names []string
+		if names, err = ListSecrets(); err == nil {
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		}
+	case "remove":
+		if len(os.Args) != 4 {
+			usage()
+		}
+		if err = RemoveSecret(os.Args[3]); err == nil {
+			fmt.Printf("Secret %s removed\n", os.Args[3])
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to access secrets: %v\n", err)
+		os.Exit(1)
+	}
+	if os.Args[2] != "list" {
+		fmt.Println("A running runner uses the change after its next reload (SIGHUP)")
+	}
+}
+
+// notifyTestCommand implements "notify-test [channel]".
+func notifyTestCommand() {
+	name := ""
+	if len(os.Args) > 2 {
+		name = os.Args[2]
+	}
+	scanPlugins()
+	results, err := TestNotify(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	failed := false
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+			failed = true
+		} else {
+			fmt.Printf("%s: sent\n", name)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
-func runService() {
+// jobTypesCommand implements "job-types": it lists the tasks and plugins
+// jobs can use as their type, with the parameters they take.
+func jobTypesCommand() {
+	var broken []JobType
+	for _, jt := range JobTypes() {
+		if jt.Error != "" {
+			broken = append(broken, jt)
+			continue
+		}
+		header := fmt.Sprintf("%s - %s", jt.Name, jt.Description)
+		if jt.Path != "" {
+			header += " (plugin " + jt.Path + ")"
+		}
+		fmt.Println(header)
+		for _, p := range jt.Params {
+			var notes []string
+			if p.Required {
+				notes = append(notes, "required")
+			}
+			if p.Default != "" {
+				notes = append(notes, fmt.Sprintf("default %q", p.Default))
+			}
+			if len(p.Choices) > 0 {
+				notes = append(notes, "one of "+strings.Join(p.Choices, ", "))
+			}
+			line := fmt.Sprintf("  %-16s %-9s %s", p.Name, p.Type, p.Description)
+			if len(notes) > 0 {
+				line += " (" + strings.Join(notes, "; ") + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+	if len(broken) > 0 {
+		fmt.Fprintln(os.Stderr, "Plugins that failed to load:")
+		for _, jt := range broken {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", jt.Path, jt.Error)
+		}
+	}
+}
+
+// printAPIToken creates the HTTP API token if needed and says where it is.
+func printAPIToken() {
+	path, err := EnsureAPIToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not create API token: %v\n", err)
+		return
+	}
+	fmt.Printf("HTTP API token: %s\n", path)
+}
+
+func checkConfig() {
+	configPath, err := getRunnerConfigPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get config path: %v\n", err)
+		os.Exit(1)
+	}
+	scanPlugins()
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Configuration OK: %s (%d jobs)\n", configPath, len(cfg.Jobs))
+}
+
+// runService runs the scheduler until a signal stops it. It returns instead
+// of exiting so that its deferred cleanup, the instance lock above all, runs.
+func runService() error {
+	// Take the systemd notification settings and the secrets passphrase
+	// before any job can inherit them
+	notifier := newSDNotifier()
+	secretsPassphrase()
+
 	// Setup log directory
 	if err := shared.EnsureLogDir(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating log dir: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	// Only one runner may use the runner directory; a second one would
+	// interleave its lines into the same log
+	pidPath, err := getRunnerPIDPath()
+	if err != nil {
+		return fmt.Errorf("failed to get PID file path: %w", err)
 	}
+	lock, stalePID, err := acquireInstanceLock(pidPath)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
 
 	logPath := shared.GetLogPath()
 	if logPath == "" {
-		fmt.Fprintf(os.Stderr, "Error getting log path\n")
-		os.Exit(1)
+		return errors.New("failed to get log path")
 	}
 
-	// Configure rolling logger
-	logWriter := &lumberjack.Logger{
-		Filename:   logPath,
-		MaxSize:    logMaxSizeMB,
-		MaxBackups: logMaxBackups,
-		MaxAge:     logMaxAgeDays,
-		Compress:   true,
+	configPath, err := getRunnerConfigPath()
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %w", err)
+	}
+	if err := writeDefaultConfig(configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write default config: %v\n", err)
 	}
 
+	// Validate configuration before anything else starts; jobs can use the
+	// job types of plugins, so find them first
+	scanPlugins()
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	// Configure rolling logger
+	logWriter := newRotatingLog(logPath, cfg.Log)
 	defer logWriter.Close()
+	log := shared.NewLogger(logWriter, cfg.Log.Format, cfg.Log.Level)
+	if stalePID > 0 {
+		log.Warn("Previous runner exited without cleaning up; took over its lock", "pid", stalePID)
+	}
+
+	// Open the run history
+	historyPath, err := getHistoryPath()
+	if err != nil {
+		return fmt.Errorf("failed to get history path: %w", err)
+	}
+	history, err := OpenHistoryStore(historyPath, cfg.History)
+	if err != nil {
+		log.Error("Failed to open run history", "error", err)
+		return fmt.Errorf("failed to open run history: %w", err)
+	}
+	defer history.Close()
+
+	r := &runner{
+		startedAt:  time.Now(),
+		configPath: configPath,
+		cfg:        cfg,
+		logWriter:  logWriter,
+		log:        log,
+		scheduler:  NewScheduler(log, cfg.Runner.Workers, cfg.Runner.QueueSize),
+		history:    history,
+		metrics:    newRunMetrics(),
+		output:     newOutputHub(),
+		notifier:   notifier,
+
+		notifications: newNotifyRouter(log),
+	}
+	r.applyLogForwarding(cfg.Log.Forward)
+	r.scheduler.AddRecorder(history)
+	r.scheduler.AddRecorder(r.metrics)
+	r.scheduler.AddRecorder(r.output)
+	r.scheduler.AddRecorder(r.notifications)
+	r.watches = newWatchManager(log, func(job string, paths []string, event string) error {
+		if r.scheduler.Paused(job) {
+			log.Info("Job is paused, ignoring changed paths", "job", job, "paths", len(paths))
+			return nil
+		}
+		_, err := r.scheduler.Fire(job, triggerWatch, paths, event)
+		return err
+	})
+
+	// Restore pause state from the previous run
+	r.pausePath, err = getPauseStatePath()
+	if err != nil {
+		return fmt.Errorf("failed to get pause state path: %w", err)
+	}
+	pause, err := loadPauseState(r.pausePath)
+	if err != nil {
+		log.Warn("Starting unpaused", "error", err)
+	}
+	r.scheduler.RestorePauseState(pause)
+	if pause.All || len(pause.Jobs) > 0 {
+		log.Info(pauseSummary(pause))
+	}
+
+	// Remember schedule slots across restarts so missed runs can be found
+	schedulePath, err := getScheduleStatePath()
+	if err != nil {
+		return fmt.Errorf("failed to get schedule state path: %w", err)
+	}
+	if err := r.scheduler.UseScheduleState(schedulePath); err != nil {
+		log.Warn("Missed runs before this start are not detected", "error", err)
+	}
+
+	r.logPluginErrors()
+	secrets, err := r.loadSecrets(cfg)
+	var jobs []Job
+	var notify *notifySetup
+	if err == nil {
+		jobs, err = r.buildJobs(cfg, secrets)
+	}
+	if err == nil {
+		notify, err = buildNotify(cfg, secrets)
+	}
+	if err != nil {
+		log.Error("Invalid configuration", "error", err)
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	r.notifications.Apply(notify)
+	if _, err := r.scheduler.Reload(jobs); err != nil {
+		log.Error("Failed to register jobs", "error", err)
+		return fmt.Errorf("failed to register jobs: %w", err)
+	}
+
+	// Start the control socket so the GUI can query live state
+	controlPath, err := getControlSocketPath()
+	if err != nil {
+		return fmt.Errorf("failed to get control socket path: %w", err)
+	}
+	control := newControlServer(controlPath, log)
+	r.registerControlHandlers(control)
+	if err := control.Start(); err != nil {
+		log.Error("Failed to start control socket", "error", err)
+		return fmt.Errorf("failed to start control socket: %w", err)
+	}
+	defer control.Close()
+
+	// The HTTP API is optional; it needs a token, normally created on install
+	if _, err := EnsureAPIToken(); err != nil {
+		log.Warn("Failed to create API token", "error", err)
+	}
+	r.applyAPI(cfg.API)
+	r.applyMetrics(cfg.Metrics)
 
 	// Log startup
-	shared.LogMessage(logWriter, "Service started")
+	log.Info("Service started", "version", shared.Version, "pid", os.Getpid())
 
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
-	// Create ticker for periodic task
-	ticker := time.NewTicker(heartbeatInterval)
-	defer ticker.Stop()
-
-	// Main service loop
-	firstTick := true
-	for {
-		select {
-		case <-ticker.C:
-			if firstTick {
-				shared.LogMessage(logWriter, "I'm alive")
-				firstTick = false
-			} else {
-				shared.LogMessage(logWriter, "Staying alive")
-			}
-		case sig := <-sigChan:
-			shared.LogMessage(logWriter, fmt.Sprintf("Received signal: %v, shutting down. Bye!", sig))
-			return
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.scheduler.Start(ctx)
+	r.watches.Reload(cfg.Jobs)
+	if pause.All || len(pause.Jobs) > 0 {
+		r.sdNotify(sdReady, sdStatus("%s", pauseSummary(pause)))
+	} else {
+		r.sdNotify(sdReady, sdStatus("Running %d jobs", len(jobs)))
+	}
+
+	// The watchdog keeps pinging through shutdown, which may take a while
+	stopWatchdog := make(chan struct{})
+	defer close(stopWatchdog)
+	go notifier.runWatchdog(stopWatchdog, func() { r.scheduler.Jobs() }, func(msg string) {
+		log.Warn(msg)
+	})
+
+	// Main service loop: SIGHUP reloads, anything else shuts down.
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			r.reload()
+			continue
+		}
+
+		log.Info("Received signal, shutting down. Bye!", "signal", sig)
+		cancel()
+		r.shutdown()
+		return nil
+	}
+	return nil
+}
+
+// runner holds the live state of a running background task runner.
+type runner struct {
+	startedAt  time.Time
+	configPath string
+	logWriter  *rotatingLog
+	log        *shared.Logger
+	scheduler  *Scheduler
+	watches    *watchManager
+	history    *HistoryStore
+	metrics    *runMetrics
+	output     *outputHub
+	notifier   *sdNotifier
+
+	notifications *notifyRouter
+
+	mu  sync.Mutex
+	cfg *Config
+
+	apiMu sync.Mutex
+	api   *apiServer
+
+	metricsMu     sync.Mutex
+	metricsServer *metricsServer
+
+	forwardMu sync.Mutex
+	forward   LogForwardOptions
+
+	pauseMu   sync.Mutex
+	pausePath string
+
+	// lastHeartbeat is the Unix time in nanoseconds of the last heartbeat.
+	lastHeartbeat atomic.Int64
+	heartbeats    atomic.Int64
+}
+
+// sdNotify reports state to systemd, logging failures.
+func (r *runner) sdNotify(state ...string) {
+	if err := r.notifier.notify(state...); err != nil {
+		r.log.Warn(err.Error())
+	}
+}
+
+// applyLogOptions applies reloaded [log] settings. A level set at runtime
+// through the control socket is kept unless the configured level changed.
+func (r *runner) applyLogOptions(opts LogOptions) {
+	old := r.config().Log
+	if r.logWriter.Apply(opts) {
+		r.log.Info("Log rotation settings updated")
+	}
+	if opts.Format != old.Format {
+		r.log.SetFormat(opts.Format)
+		r.log.Info("Log format changed", "format", opts.Format)
+	}
+	if opts.Level != old.Level {
+		r.setLogLevel(opts.Level.String())
+	}
+	r.applyLogForwarding(opts.Forward)
+}
+
+// applyLogForwarding connects the runner log to the syslog and journal
+// sockets selected by opts, replacing the previous connections if opts
+// changed. A destination that cannot be reached is logged and left out.
+func (r *runner) applyLogForwarding(opts LogForwardOptions) {
+	r.forwardMu.Lock()
+	defer r.forwardMu.Unlock()
+	if opts == r.forward {
+		return
+	}
+	r.forward = opts
+
+	var sinks []shared.Sink
+	if opts.Syslog {
+		if sink, err := shared.NewSyslogSink(opts.SyslogSocket, opts.SyslogFacility); err != nil {
+			r.log.Error("Failed to start syslog forwarding", "error", err)
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+	if opts.Journal {
+		if sink, err := shared.NewJournalSink(opts.JournalSocket); err != nil {
+			r.log.Error("Failed to start journal forwarding", "error", err)
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+	r.log.SetSinks(sinks...)
+	for _, sink := range sinks {
+		r.log.Info("Forwarding log", "to", sink.Name())
+	}
+}
+
+// logPluginErrors logs the plugins that the last scan could not use.
+func (r *runner) logPluginErrors() {
+	for _, msg := range PluginErrors() {
+		r.log.Warn("Plugin not loaded", "error", msg)
+	}
+}
+
+func (r *runner) config() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// loadSecrets reads the secrets store and hides the secret values in the log.
+// An unreadable store is only an error when cfg refers to secrets.
+func (r *runner) loadSecrets(cfg *Config) (map[string]string, error) {
+	secrets, err := loadSecrets()
+	if err != nil {
+		if cfg.usesSecrets() {
+			return nil, err
+		}
+		r.log.Warn("Secrets unavailable", "error", err)
+	}
+	for _, name := range shortSecrets(secrets) {
+		r.log.Warn("Secret is too short to be redacted from the log", "secret", name, "min_length", shared.MinRedactedLength)
+	}
+	shared.SetRedactions(secretValues(secrets))
+	return secrets, nil
+}
+
+// buildJobs turns the enabled job and workflow configurations into runnable
+// jobs.
+func (r *runner) buildJobs(cfg *Config, secrets map[string]string) ([]Job, error) {
+	var jobs []Job
+	enabled := make(map[string]bool)
+	for _, jc := range cfg.Jobs {
+		if !jc.Enabled {
+			continue
+		}
+		job, err := r.buildJob(jc, secrets)
+		if err != nil {
+			return nil, &ConfigError{Path: cfg.Path, Line: jc.Line, Msg: err.Error()}
 		}
+		jobs = append(jobs, job)
+		enabled[jc.Name] = true
+	}
+	for _, wf := range cfg.Workflows {
+		if wf.Enabled {
+			jobs = append(jobs, r.buildWorkflow(wf, cfg, enabled))
+		}
+	}
+	return jobs, nil
+}
+
+// reload re-reads the configuration and applies it. On any error the running
+// configuration is kept.
+func (r *runner) reload() {
+	r.log.Info("Received SIGHUP, reloading configuration")
+
+	scanPlugins()
+	cfg, err := LoadConfig(r.configPath)
+	if err != nil {
+		r.log.Error("Reload failed, keeping current configuration", "error", err)
+		return
+	}
+	r.logPluginErrors()
+	secrets, err := r.loadSecrets(cfg)
+	var jobs []Job
+	var notify *notifySetup
+	if err == nil {
+		jobs, err = r.buildJobs(cfg, secrets)
+	}
+	if err == nil {
+		notify, err = buildNotify(cfg, secrets)
+	}
+	if err != nil {
+		r.log.Error("Reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	if old := r.config().Runner; old.Workers != cfg.Runner.Workers || old.QueueSize != cfg.Runner.QueueSize {
+		r.log.Warn("Worker pool settings changed; they take effect on restart")
+	}
+	r.applyLogOptions(cfg.Log)
+	if err := r.history.SetOptions(cfg.History); err != nil {
+		r.log.Error("Failed to apply history retention", "error", err)
+	}
+	result, err := r.scheduler.Reload(jobs)
+	if err != nil {
+		r.log.Error("Reload failed, keeping current jobs", "error", err)
+		return
+	}
+	r.watches.Reload(cfg.Jobs)
+	r.notifications.Apply(notify)
+	r.applyAPI(cfg.API)
+	r.applyMetrics(cfg.Metrics)
+
+	r.mu.Lock()
+	r.cfg = cfg
+	r.mu.Unlock()
+
+	r.log.Info("Configuration reloaded",
+		"added", result.Added, "removed", result.Removed, "changed", result.Changed, "unchanged", result.Unchanged)
+	r.sdNotify(sdStatus("Running %d jobs; configuration reloaded at %s", len(jobs), time.Now().Format(time.TimeOnly)))
+}
+
+// shutdown stops the scheduler, giving in-flight jobs up to the configured
+// shutdown timeout to return.
+func (r *runner) shutdown() {
+	r.sdNotify(sdStopping, sdStatus("Shutting down"))
+	r.watches.Close()
+	r.applyAPI(APIOptions{})
+	r.applyMetrics(MetricsOptions{})
+
+	timeout := r.config().Runner.ShutdownTimeout
+	stopped := make(chan struct{})
+	go func() {
+		r.scheduler.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		r.log.Warn("Jobs still running after the shutdown timeout, exiting anyway", "timeout", timeout)
+	}
+	if !r.notifications.Wait(notifyShutdownWait) {
+		r.log.Warn("Notifications still being sent, exiting anyway")
 	}
+	// Forwarded entries still queued are flushed last.
+	r.applyLogForwarding(LogForwardOptions{})
 }
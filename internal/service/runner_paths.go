@@ -0,0 +1,87 @@
+package service
+
+import (
+	"path/filepath"
+
+	"go-toy/internal/shared"
+)
+
+func getRunnerConfigPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, runnerConfigFileName), nil
+}
+
+func getControlSocketPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, controlSocketFileName), nil
+}
+
+func getHistoryPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyFileName), nil
+}
+
+func getAPITokenPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, apiTokenFileName), nil
+}
+
+func getRunnerPIDPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, runnerPIDFileName), nil
+}
+
+func getPauseStatePath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pauseStateFileName), nil
+}
+
+func getScheduleStatePath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, scheduleStateFileName), nil
+}
+
+func getSecretsPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, secretsFileName), nil
+}
+
+func getSecretsKeyPath() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, secretsKeyFileName), nil
+}
+
+func getPluginsDir() (string, error) {
+	dir, err := shared.GetLogDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pluginsDirName), nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Run statuses recorded in RunRecord.Status.
+const (
+	runStatusSuccess   = "success"
+	runStatusFailed    = "failed"
+	runStatusTimeout   = "timeout"
+	runStatusCancelled = "cancelled"
+	runStatusSkipped   = "skipped"
+)
+
+// Run triggers recorded in RunRecord.Trigger.
+const (
+	triggerSchedule = "schedule"
+	triggerRetry    = "retry"
+	triggerWorkflow = "workflow"
+	triggerWatch    = "watch"
+	triggerAPI      = "api"
+	triggerManual   = "manual"
+	triggerCatchUp  = "catchup"
+)
+
+// RunRecord describes one execution of a job.
+type RunRecord struct {
+	ID      string `json:"id"`
+	Job     string `json:"job"`
+	Trigger string `json:"trigger"`
+	// Attempt is 1 for the first try of a run and counts up on retries.
+	// RetryOf is the ID of the first attempt when this run is a retry.
+	Attempt int    `json:"attempt"`
+	RetryOf string `json:"retry_of,omitempty"`
+	// ParentID is the ID of the run that triggered this one, such as the
+	// workflow run a job ran in.
+	ParentID string    `json:"parent_id,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Status   string    `json:"status"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Stdout   string    `json:"stdout,omitempty"`
+	Stderr   string    `json:"stderr,omitempty"`
+	// OutputTruncated reports that stdout or stderr exceeded the capture
+	// limit and only the tail was kept.
+	OutputTruncated bool `json:"output_truncated,omitempty"`
+	// TriggerPaths and TriggerEvent describe the filesystem changes behind a
+	// watch run, oldest path first.
+	TriggerPaths []string `json:"trigger_paths,omitempty"`
+	TriggerEvent string   `json:"trigger_event,omitempty"`
+	// LimitExceeded names the resource limit that ended a failed run
+	// (limitCPU or limitMemory).
+	LimitExceeded string `json:"limit_exceeded,omitempty"`
+}
+
+// Duration returns how long the run took.
+func (r *RunRecord) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+func newRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; fall back to time.
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,934 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// Job is a named unit of work executed by the Scheduler on its own schedule.
+// A job without a schedule only runs when triggered through RunNow or Fire.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// Run performs the work. It may fill in rec (exit code, output, status);
+	// the scheduler sets the identity and timing fields.
+	Run func(ctx context.Context, rec *RunRecord) error
+
+	// Retry controls whether failed runs are attempted again.
+	Retry RetryPolicy
+
+	// Overlap decides what happens when the job comes due while an earlier
+	// run is still queued or in progress: overlapSkip, overlapAllow,
+	// overlapQueue or overlapCancel. Empty means overlapSkip.
+	Overlap string
+	// MaxQueued bounds the runs waiting behind a busy job under overlapQueue.
+	MaxQueued int
+	// Misfire decides what happens to scheduled runs missed while the runner
+	// was stopped or the machine was asleep.
+	Misfire MisfirePolicy
+	// Jitter delays each scheduled run by a random duration up to this long.
+	Jitter time.Duration
+	// Dedicated runs the job on its own goroutine instead of the worker
+	// pool. The heartbeat uses it so a saturated pool cannot starve it, and
+	// workflows so they do not hold a worker while their members wait for one.
+	Dedicated bool
+
+	// Type and ScheduleSpec describe the job for status reporting.
+	Type         string
+	ScheduleSpec string
+
+	// Fingerprint identifies the job definition. On Reload, jobs whose
+	// fingerprint is unchanged keep running undisturbed.
+	Fingerprint string
+}
+
+// Overlap policies.
+const (
+	overlapSkip   = "skip"
+	overlapAllow  = "allow"
+	overlapQueue  = "queue"
+	overlapCancel = "cancel"
+)
+
+// Scheduler keeps one goroutine per registered job that watches its schedule.
+// Due runs are handed to a bounded worker pool, subject to the job's overlap
+// policy.
+type Scheduler struct {
+	log       *shared.Logger
+	recorders []RunRecorder
+	pool      *workerPool
+
+	mu      sync.Mutex
+	entries map[string]*scheduledJob
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running bool
+	wg      sync.WaitGroup
+	// pause suspends scheduled and watch-triggered runs; runs started
+	// explicitly are not affected.
+	pause PauseState
+	// fires persists the last handled schedule slot of each job; nil keeps
+	// it in memory only.
+	fires *fireStore
+	// clockChanged is closed and replaced when a suspend or clock change is
+	// detected.
+	clockChanged chan struct{}
+}
+
+type scheduledJob struct {
+	job Job
+	// stop ends the schedule loop without cancelling an in-flight run.
+	stop chan struct{}
+	// done is closed once the job is stopped and every run it accepted has
+	// finished.
+	done chan struct{}
+
+	mu      sync.Mutex
+	state   JobStatus
+	lastRun *RunRecord
+	// stopped is set together with closing stop; no run is accepted after.
+	stopped bool
+	// runs holds the accepted runs, whether waiting for a worker or running.
+	runs map[*activeRun]struct{}
+	// backlog holds runs waiting for the current one to finish (overlapQueue).
+	backlog []runRequest
+	// inflight counts accepted runs, so done can wait for them.
+	inflight sync.WaitGroup
+}
+
+// runRequest asks for one run of a job, including its retries. id becomes
+// the ID of the first attempt, so the run can be referred to before it
+// starts. The final record is delivered on result, which has room for it.
+type runRequest struct {
+	ctx      context.Context
+	id       string
+	trigger  string
+	parentID string
+	paths    []string
+	event    string
+	result   chan *RunRecord
+}
+
+func newRunRequest(ctx context.Context, trigger, parentID string) runRequest {
+	return runRequest{
+		ctx:      ctx,
+		id:       newRunID(),
+		trigger:  trigger,
+		parentID: parentID,
+		result:   make(chan *RunRecord, 1),
+	}
+}
+
+type activeRun struct {
+	id     string
+	cancel context.CancelFunc
+}
+
+// JobStatus is a point-in-time view of a scheduled job.
+type JobStatus struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Schedule   string     `json:"schedule"`
+	Running    bool       `json:"running"`
+	Overlap    string     `json:"overlap"`
+	Active     int        `json:"active"`
+	Pending    int        `json:"pending"`
+	Skipped    int        `json:"skipped"`
+	Paused     bool       `json:"paused"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+	LastStart  *time.Time `json:"last_start,omitempty"`
+	LastEnd    *time.Time `json:"last_end,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	LastStatus string     `json:"last_status,omitempty"`
+	LastRunID  string     `json:"last_run_id,omitempty"`
+	RunCount   int        `json:"run_count"`
+}
+
+// RunRecorder is notified of every completed run.
+type RunRecorder interface {
+	Record(rec *RunRecord) error
+}
+
+// ReloadResult summarises what changed in a Reload.
+type ReloadResult struct {
+	Added, Removed, Changed, Unchanged []string
+}
+
+// NewScheduler creates a scheduler that logs job activity to log and runs
+// jobs on workers goroutines, with up to queueSize runs waiting for one.
+func NewScheduler(log *shared.Logger, workers, queueSize int) *Scheduler {
+	return &Scheduler{
+		log:          log,
+		pool:         newWorkerPool(workers, queueSize),
+		entries:      make(map[string]*scheduledJob),
+		clockChanged: make(chan struct{}),
+	}
+}
+
+// AddRecorder registers r to receive completed runs. It must be called
+// before Start.
+func (s *Scheduler) AddRecorder(r RunRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorders = append(s.recorders, r)
+}
+
+func validateJob(job Job) error {
+	if job.Name == "" {
+		return fmt.Errorf("job name is required")
+	}
+	if job.Run == nil {
+		return fmt.Errorf("job %q: run function is required", job.Name)
+	}
+	switch job.Overlap {
+	case "", overlapSkip, overlapAllow, overlapQueue, overlapCancel:
+	default:
+		return fmt.Errorf("job %q: unknown overlap policy %q", job.Name, job.Overlap)
+	}
+	return nil
+}
+
+// Add registers a job. If the scheduler is already running the job starts
+// immediately.
+func (s *Scheduler) Add(job Job) error {
+	if err := validateJob(job); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[job.Name]; exists {
+		return fmt.Errorf("job %q already registered", job.Name)
+	}
+	entry := newScheduledJob(job)
+	s.entries[job.Name] = entry
+	if s.running {
+		s.startLocked(entry, nil)
+	}
+	return nil
+}
+
+// Reload replaces the registered jobs with jobs. Unchanged jobs keep their
+// schedule; removed and changed jobs stop scheduling new runs but any run in
+// progress is allowed to finish. A changed job's new schedule starts only
+// after its previous run has returned.
+func (s *Scheduler) Reload(jobs []Job) (ReloadResult, error) {
+	var result ReloadResult
+
+	next := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		if err := validateJob(job); err != nil {
+			return result, err
+		}
+		if _, dup := next[job.Name]; dup {
+			return result, fmt.Errorf("job %q defined twice", job.Name)
+		}
+		next[job.Name] = job
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fires.prune(next)
+	for name, entry := range s.entries {
+		job, keep := next[name]
+		if keep && job.Fingerprint == entry.job.Fingerprint {
+			result.Unchanged = append(result.Unchanged, name)
+			delete(next, name)
+			continue
+		}
+
+		s.shutdownEntry(entry, "job was reconfigured")
+		delete(s.entries, name)
+		if !keep {
+			result.Removed = append(result.Removed, name)
+			continue
+		}
+
+		result.Changed = append(result.Changed, name)
+		replacement := newScheduledJob(job)
+		s.entries[name] = replacement
+		if s.running {
+			s.startLocked(replacement, entry.done)
+		}
+		delete(next, name)
+	}
+
+	for name, job := range next {
+		result.Added = append(result.Added, name)
+		entry := newScheduledJob(job)
+		s.entries[name] = entry
+		if s.running {
+			s.startLocked(entry, nil)
+		}
+	}
+
+	for _, names := range [][]string{result.Added, result.Removed, result.Changed, result.Unchanged} {
+		sort.Strings(names)
+	}
+	return result, nil
+}
+
+// Start launches one goroutine per job. It returns immediately. Runs receive
+// a context derived from ctx, cancelled by Stop.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, entry := range s.entries {
+		s.startLocked(entry, nil)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.watchClock(s.ctx)
+	}()
+}
+
+// Stop cancels all job loops and in-flight runs, then waits for them and the
+// worker pool to return.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	s.cancel()
+	for _, entry := range s.entries {
+		s.shutdownEntry(entry, "runner is shutting down")
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	s.pool.close()
+	s.flushScheduleState()
+}
+
+// Pool reports the worker pool's load.
+func (s *Scheduler) Pool() PoolStatus {
+	return s.pool.status()
+}
+
+// Jobs returns the status of every registered job, sorted by name.
+func (s *Scheduler) Jobs() []JobStatus {
+	entries := s.snapshot()
+	out := make([]JobStatus, 0, len(entries))
+	for _, entry := range entries {
+		entry.mu.Lock()
+		state := entry.state
+		entry.mu.Unlock()
+		state.Paused = s.Paused(state.Name)
+		out = append(out, state)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// LastRun returns the record of the most recent completed run of the named
+// job, or nil if it has not run yet.
+func (s *Scheduler) LastRun(name string) (*RunRecord, error) {
+	entry, err := s.entry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.lastRun, nil
+}
+
+func newScheduledJob(job Job) *scheduledJob {
+	if job.Overlap == "" {
+		job.Overlap = overlapSkip
+	}
+	return &scheduledJob{
+		job:  job,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		runs: make(map[*activeRun]struct{}),
+		state: JobStatus{
+			Name:     job.Name,
+			Type:     job.Type,
+			Schedule: job.ScheduleSpec,
+			Overlap:  job.Overlap,
+		},
+	}
+}
+
+// shutdownEntry stops entry from accepting runs and ends its schedule loop.
+// Runs already started are left to finish; queued ones are recorded as
+// skipped with reason. Called with s.mu held.
+func (s *Scheduler) shutdownEntry(entry *scheduledJob, reason string) {
+	entry.mu.Lock()
+	if entry.stopped {
+		entry.mu.Unlock()
+		return
+	}
+	entry.stopped = true
+	close(entry.stop)
+	dropped := entry.backlog
+	entry.backlog = nil
+	entry.state.Pending -= len(dropped)
+	entry.mu.Unlock()
+
+	if len(dropped) == 0 {
+		return
+	}
+	// Recording takes s.mu, which the caller holds.
+	go func() {
+		for _, req := range dropped {
+			req.result <- s.skipRequest(entry.job.Name, req, reason)
+			entry.inflight.Done()
+		}
+	}()
+}
+
+func (e *scheduledJob) update(fn func(state *JobStatus)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn(&e.state)
+}
+
+func (s *Scheduler) startLocked(entry *scheduledJob, after <-chan struct{}) {
+	ctx := s.ctx
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(entry.done)
+
+		if after != nil {
+			select {
+			case <-after:
+			case <-entry.stop:
+				entry.inflight.Wait()
+				return
+			case <-ctx.Done():
+			}
+		}
+		s.loop(ctx, entry)
+
+		// Runs can still be triggered through RunNow until the job is
+		// stopped; done waits for all of them.
+		<-entry.stop
+		entry.inflight.Wait()
+	}()
+}
+
+// loop fires entry on its schedule. Slots are counted from the last one
+// handled, which survives restarts, so slots that passed while the runner was
+// stopped or the machine was asleep are found and handled by the job's
+// misfire policy.
+func (s *Scheduler) loop(ctx context.Context, entry *scheduledJob) {
+	job := entry.job
+	if job.Schedule == nil {
+		return
+	}
+	last := s.lastFire(job.Name)
+	if now := time.Now(); last.IsZero() || last.After(now) {
+		// First start, or the clock was set back past the last slot.
+		last = now
+		s.setLastFire(job.Name, last)
+	}
+	for {
+		now := time.Now()
+		next := job.Schedule.Next(last)
+		if next.IsZero() {
+			s.log.Info("Job has no future runs, stopping its schedule", "job", job.Name)
+			return
+		}
+
+		if next.After(now) {
+			entry.update(func(state *JobStatus) { state.NextRun = &next })
+			if !s.sleep(ctx, entry, next.Sub(now), true) {
+				return
+			}
+			if now := time.Now(); now.Before(last) {
+				// The clock was set back.
+				last = now
+			}
+			continue
+		}
+
+		slots, more := dueSlots(job.Schedule, last, now, job.Misfire.Limit+1)
+		onTime := !more && now.Sub(slots[len(slots)-1]) <= misfireThreshold
+		if until, ok := blackedOut(job.Schedule, now); ok && !onTime {
+			// Woke up inside a blackout window: handle the missed runs
+			// once it is over.
+			entry.update(func(state *JobStatus) { state.NextRun = &until })
+			if !s.sleep(ctx, entry, until.Sub(now), true) {
+				return
+			}
+			continue
+		}
+
+		last = slots[len(slots)-1]
+		if more {
+			last = now
+		}
+		s.setLastFire(job.Name, last)
+
+		if s.Paused(job.Name) {
+			continue
+		}
+		if job.Jitter > 0 {
+			delay := time.Duration(rand.Int63n(int64(job.Jitter)))
+			start := now.Add(delay)
+			entry.update(func(state *JobStatus) { state.NextRun = &start })
+			if !s.sleep(ctx, entry, delay, false) {
+				return
+			}
+		}
+		missed := slots
+		if onTime {
+			missed = slots[:len(slots)-1]
+		}
+		if len(missed) > 0 {
+			s.catchUp(ctx, entry, missed, more, onTime)
+		}
+		if onTime {
+			s.fire(entry, newRunRequest(ctx, triggerSchedule, ""))
+		}
+	}
+}
+
+// sleep waits for d, or less if wake is set and a suspend or clock change is
+// detected. It returns false if the job or the scheduler stopped meanwhile.
+func (s *Scheduler) sleep(ctx context.Context, entry *scheduledJob, d time.Duration, wake bool) bool {
+	var clockChange <-chan struct{}
+	if wake {
+		clockChange = s.clockChange()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-entry.stop:
+		return false
+	case <-clockChange:
+	case <-timer.C:
+	}
+	return true
+}
+
+func (s *Scheduler) lastFire(name string) time.Time {
+	s.mu.Lock()
+	fires := s.fires
+	s.mu.Unlock()
+	return fires.last(name)
+}
+
+func (s *Scheduler) setLastFire(name string, t time.Time) {
+	s.mu.Lock()
+	fires := s.fires
+	s.mu.Unlock()
+	fires.set(name, t)
+}
+
+// Paused reports whether automatic runs of the named job are suspended,
+// either globally or for the job alone.
+func (s *Scheduler) Paused(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found := slices.BinarySearch(s.pause.Jobs, name)
+	return s.pause.All || found
+}
+
+// PauseState returns what is currently paused.
+func (s *Scheduler) PauseState() PauseState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pause.clone()
+}
+
+// RestorePauseState replaces the pause state, e.g. with the persisted one on
+// start. Paused jobs need not be registered.
+func (s *Scheduler) RestorePauseState(st PauseState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pause = st.clone()
+}
+
+// SetPaused pauses or resumes the named job, or all jobs if name is empty,
+// and returns the new state. Only registered jobs can be paused; a paused job
+// that was since removed can still be resumed.
+func (s *Scheduler) SetPaused(name string, paused bool) (PauseState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name != "" {
+		_, registered := s.entries[name]
+		_, wasPaused := slices.BinarySearch(s.pause.Jobs, name)
+		if !registered && (paused || !wasPaused) {
+			return PauseState{}, fmt.Errorf("unknown job %q", name)
+		}
+	}
+	s.pause = s.pause.with(name, paused)
+	return s.pause.clone(), nil
+}
+
+// Fire starts a run of the named job in the background, like a schedule
+// tick, and returns the ID its first attempt will have. paths and event
+// describe the filesystem change behind a watch trigger and are passed on to
+// the run.
+func (s *Scheduler) Fire(name, trigger string, paths []string, event string) (string, error) {
+	s.mu.Lock()
+	ctx, running := s.ctx, s.running
+	s.mu.Unlock()
+	if !running {
+		return "", fmt.Errorf("scheduler is not running")
+	}
+
+	entry, err := s.entry(name)
+	if err != nil {
+		return "", err
+	}
+	req := newRunRequest(ctx, trigger, "")
+	req.paths, req.event = paths, event
+	if err := s.fire(entry, req); err != nil {
+		return "", err
+	}
+	return req.id, nil
+}
+
+// fire dispatches req without waiting for the run; a skipped run is counted
+// and logged.
+func (s *Scheduler) fire(entry *scheduledJob, req runRequest) error {
+	_, err := s.dispatch(entry, req)
+	if err != nil {
+		entry.update(func(state *JobStatus) { state.Skipped++ })
+		s.log.Warn("Job skipped", "job", entry.job.Name, "reason", err)
+	}
+	return err
+}
+
+// Cancel cancels the queued or running run whose first attempt has the ID
+// id, including any pending retries, and returns its job's name.
+func (s *Scheduler) Cancel(id string) (string, error) {
+	for _, entry := range s.snapshot() {
+		if s.cancelRuns(entry, id) > 0 {
+			return entry.job.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no queued or running run with ID %q", id)
+}
+
+// CancelJob cancels every queued and running run of the named job and
+// returns how many there were.
+func (s *Scheduler) CancelJob(name string) (int, error) {
+	entry, err := s.entry(name)
+	if err != nil {
+		return 0, err
+	}
+	return s.cancelRuns(entry, ""), nil
+}
+
+// cancelRuns cancels the runs of entry with the given ID, or all of them if
+// id is empty. Runs waiting behind a busy job are recorded as skipped.
+func (s *Scheduler) cancelRuns(entry *scheduledJob, id string) int {
+	entry.mu.Lock()
+	n := 0
+	for run := range entry.runs {
+		if id == "" || run.id == id {
+			run.cancel()
+			n++
+		}
+	}
+	var dropped []runRequest
+	kept := entry.backlog[:0]
+	for _, req := range entry.backlog {
+		if id == "" || req.id == id {
+			dropped = append(dropped, req)
+		} else {
+			kept = append(kept, req)
+		}
+	}
+	entry.backlog = kept
+	entry.state.Pending -= len(dropped)
+	entry.mu.Unlock()
+
+	for _, req := range dropped {
+		req.result <- s.skipRequest(entry.job.Name, req, "cancelled before it started")
+		entry.inflight.Done()
+	}
+	return n + len(dropped)
+}
+
+// RunNow runs the named job, including retries, and returns the record of
+// its final attempt. The run is subject to the job's overlap policy and
+// waits for a free worker. parentID links the run to the run that triggered
+// it, such as a workflow run.
+func (s *Scheduler) RunNow(ctx context.Context, name, trigger, parentID string) (*RunRecord, error) {
+	entry, err := s.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.dispatch(entry, newRunRequest(ctx, trigger, parentID))
+	if err != nil {
+		return nil, err
+	}
+	return <-result, nil
+}
+
+// dispatch accepts a run of entry according to its overlap policy and hands
+// it to the worker pool, or queues it behind the current run. It fails when
+// the run is skipped or no worker queue slot is free.
+func (s *Scheduler) dispatch(entry *scheduledJob, req runRequest) (<-chan *RunRecord, error) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.stopped {
+		return nil, fmt.Errorf("job %q was removed or reconfigured", entry.job.Name)
+	}
+	if len(entry.runs) > 0 {
+		switch entry.job.Overlap {
+		case overlapSkip:
+			return nil, fmt.Errorf("previous run still in progress")
+		case overlapQueue:
+			if len(entry.backlog) >= entry.job.MaxQueued {
+				return nil, fmt.Errorf("previous run still in progress and %d runs already queued", len(entry.backlog))
+			}
+			entry.backlog = append(entry.backlog, req)
+			entry.inflight.Add(1)
+			entry.state.Pending++
+			return req.result, nil
+		case overlapCancel:
+			for run := range entry.runs {
+				run.cancel()
+			}
+		}
+	}
+
+	if err := s.startRunLocked(entry, req); err != nil {
+		return nil, err
+	}
+	return req.result, nil
+}
+
+// startRunLocked submits req to the worker pool, or to a goroutine of its
+// own for dedicated jobs. Called with entry.mu held.
+func (s *Scheduler) startRunLocked(entry *scheduledJob, req runRequest) error {
+	ctx, cancel := context.WithCancel(req.ctx)
+	run := &activeRun{id: req.id, cancel: cancel}
+
+	task := func() {
+		entry.update(func(state *JobStatus) { state.Pending-- })
+		var rec *RunRecord
+		if ctx.Err() != nil {
+			rec = s.skipRequest(entry.job.Name, req, "cancelled before it started")
+		} else {
+			rec = s.runJob(ctx, entry, req)
+		}
+		cancel()
+		s.finishRun(entry, run)
+		req.result <- rec
+	}
+
+	if entry.job.Dedicated {
+		go task()
+	} else if !s.pool.submit(task) {
+		cancel()
+		return fmt.Errorf("worker queue is full (%d runs waiting)", s.pool.status().QueueSize)
+	}
+	entry.runs[run] = struct{}{}
+	entry.inflight.Add(1)
+	entry.state.Pending++
+	return nil
+}
+
+// finishRun releases run and starts the next queued run, if any.
+func (s *Scheduler) finishRun(entry *scheduledJob, run *activeRun) {
+	defer entry.inflight.Done()
+
+	entry.mu.Lock()
+	delete(entry.runs, run)
+	if len(entry.runs) > 0 || len(entry.backlog) == 0 {
+		entry.mu.Unlock()
+		return
+	}
+	next := entry.backlog[0]
+	entry.backlog = entry.backlog[1:]
+	entry.state.Pending--
+	err := s.startRunLocked(entry, next)
+	entry.mu.Unlock()
+
+	// The queued request already counted towards inflight.
+	entry.inflight.Done()
+	if err != nil {
+		s.log.Warn("Job skipped", "job", entry.job.Name, "run_id", next.id, "reason", err)
+		next.result <- s.skipRequest(entry.job.Name, next, err.Error())
+	}
+}
+
+// RecordSkipped records that the named job was not run, for example because
+// an upstream job failed.
+func (s *Scheduler) RecordSkipped(name, trigger, parentID, reason string) *RunRecord {
+	return s.skipRequest(name, newRunRequest(context.Background(), trigger, parentID), reason)
+}
+
+func (s *Scheduler) skipRequest(name string, req runRequest, reason string) *RunRecord {
+	now := time.Now()
+	rec := &RunRecord{
+		ID:       req.id,
+		Job:      name,
+		Trigger:  req.trigger,
+		ParentID: req.parentID,
+		Attempt:  1,
+		Start:    now,
+		End:      now,
+		Status:   runStatusSkipped,
+		Error:    reason,
+	}
+	s.record(rec)
+	return rec
+}
+
+func (s *Scheduler) snapshot() []*scheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*scheduledJob, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (s *Scheduler) entry(name string) (*scheduledJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown job %q", name)
+	}
+	return entry, nil
+}
+
+// runJob runs the job once, then retries it according to its policy. Every
+// attempt is recorded as its own run; the last one is returned. Backoff
+// waits end early when the scheduler stops or the job is removed.
+func (s *Scheduler) runJob(ctx context.Context, entry *scheduledJob, req runRequest) *RunRecord {
+	job := entry.job
+	policy := job.Retry
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			req.trigger = triggerRetry
+		}
+		rec := s.runAttempt(ctx, entry, req, attempt)
+
+		if rec.Status == runStatusSuccess {
+			if attempt > 1 {
+				s.log.Info("Job succeeded after retrying", "job", job.Name, "run_id", rec.ID, "attempt", attempt, "max_attempts", policy.MaxAttempts)
+			}
+			return rec
+		}
+		if policy.lastAttempt(rec) {
+			if attempt > 1 {
+				s.log.Error("Job gave up", "job", job.Name, "run_id", rec.ID, "attempts", attempt)
+			}
+			return rec
+		}
+
+		delay := policy.backoff(attempt)
+		s.log.Warn("Job attempt failed, retrying", "job", job.Name, "run_id", rec.ID, "attempt", attempt,
+			"max_attempts", policy.MaxAttempts, "status", rec.Status, "delay", delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.log.Info("Job retries cancelled", "job", job.Name, "run_id", rec.ID)
+			return rec
+		case <-entry.stop:
+			timer.Stop()
+			s.log.Info("Job retries cancelled: job was reconfigured", "job", job.Name, "run_id", rec.ID)
+			return rec
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) runAttempt(ctx context.Context, entry *scheduledJob, req runRequest, attempt int) *RunRecord {
+	job := entry.job
+	id, retryOf := req.id, ""
+	if attempt > 1 {
+		id, retryOf = newRunID(), req.id
+	}
+	rec := &RunRecord{
+		ID:           id,
+		Job:          job.Name,
+		Trigger:      req.trigger,
+		Attempt:      attempt,
+		RetryOf:      retryOf,
+		ParentID:     req.parentID,
+		TriggerPaths: req.paths,
+		TriggerEvent: req.event,
+		Start:        time.Now(),
+	}
+	entry.update(func(state *JobStatus) {
+		state.Active++
+		state.Running = true
+		state.LastStart = &rec.Start
+		state.LastRunID = rec.ID
+	})
+
+	log := s.log.With("job", job.Name, "run_id", rec.ID)
+	log.Debug("Job started", "trigger", rec.Trigger, "attempt", attempt)
+	err := job.Run(ctx, rec)
+
+	rec.End = time.Now()
+	if rec.Status == "" {
+		rec.Status = runStatusSuccess
+		if err != nil {
+			rec.Status = runStatusFailed
+		}
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	entry.mu.Lock()
+	entry.lastRun = rec
+	entry.state.Active--
+	entry.state.Running = entry.state.Active > 0
+	entry.state.LastEnd = &rec.End
+	entry.state.RunCount++
+	entry.state.LastError = rec.Error
+	entry.state.LastStatus = rec.Status
+	entry.mu.Unlock()
+
+	duration := rec.Duration().Round(time.Millisecond)
+	if err != nil {
+		log.Error("Job "+rec.Status, "duration", duration, "error", err)
+	} else {
+		log.Debug("Job finished", "status", rec.Status, "duration", duration)
+	}
+
+	s.record(rec)
+	return rec
+}
+
+func (s *Scheduler) record(rec *RunRecord) {
+	s.mu.Lock()
+	recorders := s.recorders
+	s.mu.Unlock()
+	for _, r := range recorders {
+		if err := r.Record(rec); err != nil {
+			s.log.Error("Failed to record run", "job", rec.Job, "run_id", rec.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The runner reports its state to systemd with the sd_notify protocol: one
+// datagram of newline-separated VAR=value assignments per message, sent to
+// the socket named in $NOTIFY_SOCKET. Outside a Type=notify unit the variable
+// is unset and every call is a no-op.
+
+const watchdogUnitInterval = 30 * time.Second // WatchdogSec= of the generated units
+
+const (
+	sdReady    = "READY=1"
+	sdStopping = "STOPPING=1"
+	sdWatchdog = "WATCHDOG=1"
+)
+
+func sdStatus(format string, args ...any) string {
+	return "STATUS=" + fmt.Sprintf(format, args...)
+}
+
+type sdNotifier struct {
+	socket string
+	// watchdog is the interval systemd expects pings at; zero when the
+	// watchdog is disabled.
+	watchdog time.Duration
+}
+
+// newSDNotifier reads the notification settings from the environment and
+// removes them, so command jobs do not inherit them and talk to systemd on
+// the runner's behalf.
+func newSDNotifier() *sdNotifier {
+	n := &sdNotifier{socket: os.Getenv("NOTIFY_SOCKET")}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	pid := os.Getenv("WATCHDOG_PID")
+	if err == nil && usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		n.watchdog = time.Duration(usec) * time.Microsecond
+	}
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	return n
+}
+
+// notify sends the given assignments in one message.
+func (n *sdNotifier) notify(state ...string) error {
+	if n.socket == "" {
+		return nil
+	}
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	// A leading @ names a socket in the abstract namespace.
+	if strings.HasPrefix(addr.Name, "@") {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(state, "\n"))); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// runWatchdog pings the watchdog at half its interval until stop is closed.
+// Each ping first calls alive, which blocks if the runner is wedged; systemd
+// then misses the ping and restarts the service.
+func (n *sdNotifier) runWatchdog(stop <-chan struct{}, alive func(), logf func(string)) {
+	if n.socket == "" || n.watchdog == 0 {
+		return
+	}
+	ticker := time.NewTicker(n.watchdog / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			alive()
+			if err := n.notify(sdWatchdog); err != nil {
+				logf(err.Error())
+			}
+		}
+	}
+}
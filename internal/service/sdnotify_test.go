@@ -0,0 +1,121 @@
+//go:build linux
+
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenNotify stands in for systemd's notification socket.
+func listenNotify(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSDNotifierEnvironment(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	n := newSDNotifier()
+	if n.socket != "/run/systemd/notify" || n.watchdog != 30*time.Second {
+		t.Errorf("notifier = %+v", n)
+	}
+	for _, name := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s is still set", name)
+		}
+	}
+}
+
+func TestSDNotifierWatchdogOtherPID(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+
+	if n := newSDNotifier(); n.watchdog != 0 {
+		t.Errorf("watchdog = %s for another process, want 0", n.watchdog)
+	}
+}
+
+func TestSDNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listenNotify(t, path)
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	n := newSDNotifier()
+	if err := n.notify(sdReady, sdStatus("Running %d jobs", 3)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readNotify(t, conn), "READY=1\nSTATUS=Running 3 jobs"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+func TestSDNotifyAbstractSocket(t *testing.T) {
+	name := "gotoy-test-" + strconv.Itoa(os.Getpid())
+	conn := listenNotify(t, "\x00"+name)
+	t.Setenv("NOTIFY_SOCKET", "@"+name)
+
+	if err := newSDNotifier().notify(sdStopping); err != nil {
+		t.Fatal(err)
+	}
+	if got := readNotify(t, conn); got != sdStopping {
+		t.Errorf("message = %q, want %q", got, sdStopping)
+	}
+}
+
+func TestSDNotifyUnset(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := newSDNotifier().notify(sdReady); err != nil {
+		t.Errorf("notify without a socket: %v", err)
+	}
+}
+
+func TestSDWatchdog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listenNotify(t, path)
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "20000")
+
+	n := newSDNotifier()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var alive atomic.Int32
+	go func() {
+		defer close(done)
+		n.runWatchdog(stop, func() { alive.Add(1) }, func(msg string) { t.Error(msg) })
+	}()
+	for range 2 {
+		if got := readNotify(t, conn); got != sdWatchdog {
+			t.Errorf("message = %q, want %q", got, sdWatchdog)
+		}
+	}
+	close(stop)
+	<-done
+	if alive.Load() < 2 {
+		t.Errorf("alive called %d times before 2 pings", alive.Load())
+	}
+}
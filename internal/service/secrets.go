@@ -0,0 +1,390 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"go-toy/internal/shared"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	secretsFileName    = "secrets.enc"
+	secretsKeyFileName = "secrets.key"
+	// secretsPassphraseEnv holds the passphrase protecting the store. Without
+	// it, a random key in secrets.key is used instead.
+	secretsPassphraseEnv = "GOTOY_SECRETS_PASSPHRASE"
+
+	secretsFileVersion = 1
+	secretsKeySize     = 32
+
+	// Key derivation functions recorded in the store.
+	secretsKDFScrypt  = "scrypt"
+	secretsKDFKeyFile = "keyfile"
+
+	// scrypt parameters for new stores: about 100ms and 32 MiB per
+	// derivation.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// secretsPassphrase returns the passphrase from the environment, read on
+// first use and then removed from it, so jobs, plugins and helpers started
+// afterwards cannot read the key to every stored secret.
+var secretsPassphrase = sync.OnceValue(func() string {
+	passphrase := os.Getenv(secretsPassphraseEnv)
+	os.Unsetenv(secretsPassphraseEnv)
+	return passphrase
+})
+
+var (
+	secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	secretRefPattern  = regexp.MustCompile(`\$\{secret:([A-Za-z0-9_.-]+)\}`)
+)
+
+// secretsFile is the on-disk form of the store. The secrets are a JSON
+// object of name to value, sealed with AES-256-GCM; the header fields are
+// authenticated with it.
+type secretsFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	N       int    `json:"n,omitempty"`
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+func (f *secretsFile) additionalData() []byte {
+	return fmt.Appendf(nil, "go-toy secrets v%d %s %x %d %d %d", f.Version, f.KDF, f.Salt, f.N, f.R, f.P)
+}
+
+// loadSecrets decrypts the secrets store. A missing store holds no secrets.
+func loadSecrets() (map[string]string, error) {
+	path, err := getSecretsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets: %w", err)
+	}
+
+	var file secretsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets store %s: %w", path, err)
+	}
+	if file.Version != secretsFileVersion {
+		return nil, fmt.Errorf("unsupported secrets store version %d", file.Version)
+	}
+	key, err := secretsKey(&file)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newSecretsAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, file.additionalData())
+	if err != nil {
+		if file.KDF == secretsKDFScrypt {
+			return nil, fmt.Errorf("failed to decrypt secrets: wrong passphrase or corrupted store")
+		}
+		return nil, fmt.Errorf("failed to decrypt secrets: wrong key file or corrupted store")
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse decrypted secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// saveSecrets encrypts secrets and replaces the store atomically. An
+// existing store keeps its kind of key; a new one uses the passphrase from
+// the environment if set, and a generated key file otherwise.
+func saveSecrets(secrets map[string]string) error {
+	path, err := getSecretsPath()
+	if err != nil {
+		return err
+	}
+	if err := shared.EnsureLogDir(); err != nil {
+		return fmt.Errorf("failed to create runner dir: %w", err)
+	}
+
+	kdf := secretsKDFKeyFile
+	if secretsPassphrase() != "" {
+		kdf = secretsKDFScrypt
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		var old secretsFile
+		if err := json.Unmarshal(data, &old); err == nil && old.KDF != "" {
+			kdf = old.KDF
+		}
+	}
+
+	file := secretsFile{Version: secretsFileVersion, KDF: kdf}
+	if kdf == secretsKDFScrypt {
+		file.Salt = make([]byte, 16)
+		if _, err := rand.Read(file.Salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		file.N, file.R, file.P = scryptN, scryptR, scryptP
+	} else if err := ensureSecretsKeyFile(); err != nil {
+		return err
+	}
+	key, err := secretsKey(&file)
+	if err != nil {
+		return err
+	}
+	aead, err := newSecretsAEAD(key)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %w", err)
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, file.additionalData())
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets store: %w", err)
+	}
+	// CreateTemp makes the file readable by the owner only.
+	tmp, err := os.CreateTemp(filepath.Dir(path), secretsFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write secrets: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write secrets: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write secrets: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write secrets: %w", err)
+	}
+	return nil
+}
+
+func newSecretsAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretsKey derives the store key from the passphrase or reads it from the
+// key file, as recorded in file.
+func secretsKey(file *secretsFile) ([]byte, error) {
+	switch file.KDF {
+	case secretsKDFScrypt:
+		passphrase := secretsPassphrase()
+		if passphrase == "" {
+			return nil, fmt.Errorf("the secrets store is protected by a passphrase; set %s", secretsPassphraseEnv)
+		}
+		key, err := scrypt.Key([]byte(passphrase), file.Salt, file.N, file.R, file.P, secretsKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive secrets key: %w", err)
+		}
+		return key, nil
+	case secretsKDFKeyFile:
+		return readSecretsKeyFile()
+	default:
+		return nil, fmt.Errorf("unknown secrets key derivation %q", file.KDF)
+	}
+}
+
+func readSecretsKeyFile() ([]byte, error) {
+	path, err := getSecretsKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key: %w", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("secrets key %s must be readable by its owner only (mode 0600), has %04o", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != secretsKeySize {
+		return nil, fmt.Errorf("secrets key %s is not a base64-encoded %d-byte key", path, secretsKeySize)
+	}
+	return key, nil
+}
+
+// ensureSecretsKeyFile generates the key file unless it exists.
+func ensureSecretsKeyFile() error {
+	path, err := getSecretsKeyPath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create secrets key: %w", err)
+	}
+	key := make([]byte, secretsKeySize)
+	if _, err := rand.Read(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to generate secrets key: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, base64.StdEncoding.EncodeToString(key)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write secrets key: %w", err)
+	}
+	return f.Close()
+}
+
+// SetSecret stores value under name, replacing any previous value.
+func SetSecret(name, value string) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q (use letters, digits, '_', '.' and '-')", name)
+	}
+	secrets, err := loadSecrets()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return saveSecrets(secrets)
+}
+
+// RemoveSecret deletes the named secret.
+func RemoveSecret(name string) error {
+	secrets, err := loadSecrets()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return fmt.Errorf("unknown secret %q", name)
+	}
+	delete(secrets, name)
+	return saveSecrets(secrets)
+}
+
+// ListSecrets returns the names of the stored secrets, sorted. Values are
+// never returned.
+func ListSecrets() ([]string, error) {
+	secrets, err := loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// checkSecretRefs reports a malformed ${secret:...} reference in value.
+func checkSecretRefs(value string) error {
+	rest := secretRefPattern.ReplaceAllString(value, "")
+	if strings.Contains(rest, "${secret:") {
+		return fmt.Errorf("malformed secret reference (want ${secret:NAME})")
+	}
+	return nil
+}
+
+// usesSecrets reports whether any enabled job, in its env or task
+// parameters, or any notification channel refers to a secret.
+func (c *Config) usesSecrets() bool {
+	for _, jc := range c.Jobs {
+		for _, value := range append(slices.Clone(jc.Env), jc.Params...) {
+			if jc.Enabled && secretRefPattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	for _, ch := range c.Channels {
+		for _, value := range append([]string{ch.Password}, ch.Headers...) {
+			if secretRefPattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandSecrets replaces the secret references in env with their values. It
+// also returns a digest of the values used, so a job is restarted on reload
+// when one of its secrets changed.
+func expandSecrets(env []string, secrets map[string]string) ([]string, string, error) {
+	out := make([]string, len(env))
+	h := sha256.New()
+	var missing []string
+	for i, e := range env {
+		out[i] = secretRefPattern.ReplaceAllStringFunc(e, func(ref string) string {
+			name := secretRefPattern.FindStringSubmatch(ref)[1]
+			value, ok := secrets[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			fmt.Fprintf(h, "%s=%q\n", name, value)
+			return value
+		})
+	}
+	if len(missing) > 0 {
+		return nil, "", fmt.Errorf("unknown secret %q", missing[0])
+	}
+	return out, fmt.Sprintf("%x", h.Sum(nil)[:8]), nil
+}
+
+// secretValues returns the values to redact from the log.
+func secretValues(secrets map[string]string) []string {
+	values := make([]string, 0, len(secrets))
+	for _, value := range secrets {
+		values = append(values, value)
+	}
+	return values
+}
+
+// shortSecrets returns the sorted names of the secrets whose values are too
+// short for the log to redact.
+func shortSecrets(secrets map[string]string) []string {
+	var names []string
+	for name, value := range secrets {
+		if len(value) < shared.MinRedactedLength {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestShortSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"db-password": "correct horse",
+		"pin":         "123",
+		"empty":       "",
+		"four":        "abcd",
+	}
+	if got, want := shortSecrets(secrets), []string{"empty", "pin"}; !slices.Equal(got, want) {
+		t.Errorf("shortSecrets = %v, want %v", got, want)
+	}
+}
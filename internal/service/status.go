@@ -0,0 +1,294 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// RunnerStatus is the live internal state reported by a running runner.
+type RunnerStatus struct {
+	Version         string      `json:"version"`
+	ProtocolVersion int         `json:"protocol_version"`
+	PID             int         `json:"pid"`
+	StartedAt       time.Time   `json:"started_at"`
+	UptimeSeconds   float64     `json:"uptime_seconds"`
+	LastHeartbeat   *time.Time  `json:"last_heartbeat,omitempty"`
+	ConfigPath      string      `json:"config_path"`
+	Paused          bool        `json:"paused"`
+	Pool            PoolStatus  `json:"pool"`
+	Jobs            []JobStatus `json:"jobs"`
+
+	LogLevel string `json:"log_level"`
+	// LogWriteFailures counts log lines that went to stderr because the log
+	// file could not be written.
+	LogWriteFailures int64           `json:"log_write_failures"`
+	LogSinks         []LogSinkStatus `json:"log_sinks"`
+}
+
+func (r *runner) status() RunnerStatus {
+	st := RunnerStatus{
+		Version:          shared.Version,
+		ProtocolVersion:  controlProtocolVersion,
+		PID:              os.Getpid(),
+		StartedAt:        r.startedAt,
+		UptimeSeconds:    time.Since(r.startedAt).Seconds(),
+		ConfigPath:       r.configPath,
+		Paused:           r.scheduler.PauseState().All,
+		LogLevel:         r.log.Level().String(),
+		LogWriteFailures: r.log.WriteFailures(),
+		LogSinks:         []LogSinkStatus{},
+		Pool:             r.scheduler.Pool(),
+		Jobs:             r.scheduler.Jobs(),
+	}
+	for _, s := range r.log.SinkStats() {
+		st.LogSinks = append(st.LogSinks, LogSinkStatus(s))
+	}
+	if ns := r.lastHeartbeat.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		st.LastHeartbeat = &t
+	}
+	return st
+}
+
+// LogSinkStatus reports how forwarding the log to syslog or the journal is
+// keeping up. Entries are dropped when the destination falls behind.
+type LogSinkStatus struct {
+	Name      string `json:"name"`
+	Written   int64  `json:"written"`
+	Dropped   int64  `json:"dropped"`
+	Failed    int64  `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// jobArgs is the argument object of commands that target a single job.
+type jobArgs struct {
+	Job string `json:"job"`
+}
+
+// runArgs selects a single run by ID, or every run of a job.
+type runArgs struct {
+	Job   string `json:"job,omitempty"`
+	RunID string `json:"run_id,omitempty"`
+}
+
+// runRef identifies a run started through the control socket.
+type runRef struct {
+	Job   string `json:"job"`
+	RunID string `json:"run_id"`
+}
+
+// logLevelArgs is the argument object of "log-level"; without a level, the
+// command only reports the current one.
+type logLevelArgs struct {
+	Level string `json:"level,omitempty"`
+}
+
+// LogLevelState reports the level and format of the runner log.
+type LogLevelState struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// CancelResult reports what a cancel request stopped.
+type CancelResult struct {
+	Job       string `json:"job"`
+	Cancelled int    `json:"cancelled"`
+}
+
+func decodeArgs(raw json.RawMessage, dst any) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("missing arguments")
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func (r *runner) registerControlHandlers(s *controlServer) {
+	s.Handle("status", func(json.RawMessage) (any, error) {
+		return r.status(), nil
+	})
+	s.Handle("jobs", func(json.RawMessage) (any, error) {
+		return r.scheduler.Jobs(), nil
+	})
+	s.Handle("last-run", func(raw json.RawMessage) (any, error) {
+		var args jobArgs
+		if err := decodeArgs(raw, &args); err != nil {
+			return nil, err
+		}
+		return r.scheduler.LastRun(args.Job)
+	})
+	s.Handle("pause", func(raw json.RawMessage) (any, error) {
+		return r.controlSetPaused(raw, true)
+	})
+	s.Handle("resume", func(raw json.RawMessage) (any, error) {
+		return r.controlSetPaused(raw, false)
+	})
+	s.Handle("run", func(raw json.RawMessage) (any, error) {
+		var args jobArgs
+		if err := decodeArgs(raw, &args); err != nil {
+			return nil, err
+		}
+		id, err := r.scheduler.Fire(args.Job, triggerManual, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		return runRef{Job: args.Job, RunID: id}, nil
+	})
+	s.Handle("cancel", func(raw json.RawMessage) (any, error) {
+		var args runArgs
+		if err := decodeArgs(raw, &args); err != nil {
+			return nil, err
+		}
+		return r.cancel(args)
+	})
+	s.HandleStream("follow", r.followOutput)
+	s.Handle("log-level", func(raw json.RawMessage) (any, error) {
+		var args logLevelArgs
+		if len(raw) > 0 {
+			if err := decodeArgs(raw, &args); err != nil {
+				return nil, err
+			}
+		}
+		return r.setLogLevel(args.Level)
+	})
+	s.Handle("history", func(raw json.RawMessage) (any, error) {
+		var q HistoryQuery
+		if len(raw) > 0 {
+			if err := decodeArgs(raw, &q); err != nil {
+				return nil, err
+			}
+		}
+		return r.history.Query(q), nil
+	})
+}
+
+// controlSetPaused handles "pause" and "resume", which take an optional job.
+func (r *runner) controlSetPaused(raw json.RawMessage, paused bool) (any, error) {
+	var args jobArgs
+	if len(raw) > 0 {
+		if err := decodeArgs(raw, &args); err != nil {
+			return nil, err
+		}
+	}
+	return r.setPaused(args.Job, paused)
+}
+
+// setLogLevel changes the level of the runner log until the next restart, or
+// a reload that changes the configured level. An empty level leaves it as is.
+func (r *runner) setLogLevel(name string) (LogLevelState, error) {
+	if name != "" {
+		level, err := shared.ParseLevel(name)
+		if err != nil {
+			return LogLevelState{}, err
+		}
+		// Log the change while the more verbose of the two levels applies.
+		if old := r.log.Level(); level > old {
+			r.log.Info("Log level changed", "from", old, "to", level)
+			r.log.SetLevel(level)
+		} else if level < old {
+			r.log.SetLevel(level)
+			r.log.Info("Log level changed", "from", old, "to", level)
+		}
+	}
+	return LogLevelState{Level: r.log.Level().String(), Format: string(r.log.Format())}, nil
+}
+
+func (r *runner) cancel(args runArgs) (CancelResult, error) {
+	if args.RunID != "" {
+		job, err := r.scheduler.Cancel(args.RunID)
+		if err != nil {
+			return CancelResult{}, err
+		}
+		return CancelResult{Job: job, Cancelled: 1}, nil
+	}
+	if args.Job == "" {
+		return CancelResult{}, fmt.Errorf("cancel needs a job or a run_id")
+	}
+	n, err := r.scheduler.CancelJob(args.Job)
+	if err != nil {
+		return CancelResult{}, err
+	}
+	return CancelResult{Job: args.Job, Cancelled: n}, nil
+}
+
+// followOutput streams the live output of command runs: of one run and its
+// retries if run_id is set, ending with the last attempt, otherwise of every
+// run of job (or of all jobs) until the client disconnects. The first event,
+// of type "subscribed", confirms the stream is set up.
+func (r *runner) followOutput(raw json.RawMessage, send func(any) error, stop <-chan struct{}) error {
+	var args runArgs
+	if len(raw) > 0 {
+		if err := decodeArgs(raw, &args); err != nil {
+			return err
+		}
+	}
+	if args.Job != "" {
+		if _, err := r.scheduler.entry(args.Job); err != nil {
+			return err
+		}
+	}
+
+	sub := r.output.subscribe(args.Job)
+	defer r.output.unsubscribe(sub)
+	if err := send(OutputEvent{Type: outputEventSubscribed, RunID: args.RunID, Job: args.Job, Time: time.Now()}); err != nil {
+		return nil
+	}
+	// A run that has already finished will not produce an end event.
+	if args.RunID != "" {
+		if attempts := r.history.Attempts(args.RunID); len(attempts) > 0 {
+			if rec := attempts[len(attempts)-1]; r.lastAttempt(&rec) {
+				_ = send(OutputEvent{Type: outputEventEnd, RunID: rec.ID, Job: rec.Job, Time: rec.End, RetryOf: rec.RetryOf, Status: rec.Status})
+				return nil
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case ev := <-sub.events:
+			if args.RunID != "" && ev.RunID != args.RunID && ev.RetryOf != args.RunID {
+				continue
+			}
+			if err := send(ev); err != nil {
+				return nil
+			}
+			if args.RunID != "" && ev.Type == outputEventEnd && r.attemptEnded(ev) {
+				return nil
+			}
+		}
+	}
+}
+
+// attemptEnded reports whether the end event ev closes the last attempt of
+// its run. The history records a run before the output hub announces its
+// end, so the attempt can be looked up there.
+func (r *runner) attemptEnded(ev OutputEvent) bool {
+	first := ev.RunID
+	if ev.RetryOf != "" {
+		first = ev.RetryOf
+	}
+	for _, rec := range r.history.Attempts(first) {
+		if rec.ID == ev.RunID {
+			return r.lastAttempt(&rec)
+		}
+	}
+	return true
+}
+
+// lastAttempt reports whether no retry follows rec under its job's current
+// retry policy. Runs of removed jobs are not retried.
+func (r *runner) lastAttempt(rec *RunRecord) bool {
+	entry, err := r.scheduler.entry(rec.Job)
+	if err != nil {
+		return true
+	}
+	return entry.job.Retry.lastAttempt(rec)
+}
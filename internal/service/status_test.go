@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+// newTestRunner returns a started runner with jobs registered, recording to
+// a history in a temporary directory.
+func newTestRunner(t *testing.T, jobs ...Job) *runner {
+	t.Helper()
+	log := shared.NewLogger(io.Discard, shared.LogFormatText, shared.LevelDebug)
+	history, err := OpenHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"), HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &runner{
+		log:       log,
+		scheduler: NewScheduler(log, 2, 10),
+		history:   history,
+		output:    newOutputHub(),
+	}
+	r.scheduler.AddRecorder(history)
+	r.scheduler.AddRecorder(r.output)
+	if _, err := r.scheduler.Reload(jobs); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.scheduler.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		r.scheduler.Stop()
+		history.Close()
+	})
+	return r
+}
+
+// follow runs followOutput for runID and returns its events once it ends.
+// subscribed is called when the stream is set up.
+func follow(t *testing.T, r *runner, runID string, subscribed func()) []OutputEvent {
+	t.Helper()
+	var events []OutputEvent
+	send := func(v any) error {
+		ev := v.(OutputEvent)
+		events = append(events, ev)
+		if ev.Type == outputEventSubscribed && subscribed != nil {
+			subscribed()
+		}
+		return nil
+	}
+	args, _ := json.Marshal(runArgs{RunID: runID})
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- r.followOutput(args, send, stop) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(stop)
+		<-done
+		t.Fatalf("follow did not end; events: %+v", events)
+	}
+	return events
+}
+
+// Following a run covers its retries and ends with the last attempt.
+func TestFollowOutputRetries(t *testing.T) {
+	release := make(chan struct{})
+	var r *runner
+	r = newTestRunner(t, Job{
+		Name:  "flaky",
+		Retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond, Multiplier: 1},
+		Run: func(ctx context.Context, rec *RunRecord) error {
+			if rec.Attempt == 1 {
+				<-release
+			}
+			stdout, _ := r.output.started(rec)
+			fmt.Fprintf(stdout, "attempt %d", rec.Attempt)
+			return errors.New("failed")
+		},
+	})
+	id, err := r.scheduler.Fire("flaky", triggerManual, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := follow(t, r, id, func() { close(release) })
+	var got []string
+	for _, ev := range events {
+		got = append(got, fmt.Sprintf("%s %s %s", ev.Type, ev.Data, ev.Status))
+	}
+	want := []string{
+		"subscribed  ",
+		"start  ", "output attempt 1 ", "end  failed",
+		"start  ", "output attempt 2 ", "end  failed",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	retry := events[len(events)-1]
+	if events[1].RunID != id || retry.RunID == id || retry.RetryOf != id {
+		t.Errorf("attempts %s and %s (retry of %q), want retry of %s", events[1].RunID, retry.RunID, retry.RetryOf, id)
+	}
+
+	// Once the run has finished, following it reports its last attempt.
+	events = follow(t, r, id, nil)
+	if len(events) != 2 || events[1].Type != outputEventEnd || events[1].RunID != retry.RunID {
+		t.Errorf("events after the run = %+v, want the end of %s", events, retry.RunID)
+	}
+}
@@ -0,0 +1,448 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-toy/internal/shared"
+)
+
+const jobTypeTask = "task"
+
+var taskNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// Task is work implemented in Go and run inside the runner process. Run
+// should return when ctx is done; its error fails the run.
+type Task interface {
+	Run(ctx context.Context, params TaskParams) (TaskResult, error)
+}
+
+// TaskResult is what a task reports about a successful or failed run.
+type TaskResult struct {
+	// Output is recorded as the run's stdout in the run history.
+	Output string
+}
+
+// TaskEnv is what a task gets to know about the job it runs for.
+type TaskEnv struct {
+	Job string
+	// Log writes to the runner log, with the job name as a field.
+	Log *shared.Logger
+}
+
+// TaskDefinition describes a task that jobs can run with type = task.
+type TaskDefinition struct {
+	// Name is what jobs refer to in their task key.
+	Name        string
+	Description string
+	// Params is the schema of the parameters jobs pass with param keys.
+	Params []ParamSpec
+	// Dedicated runs the task outside the worker pool, so busy jobs cannot
+	// delay it. Only use it for short tasks.
+	Dedicated bool
+	// New creates the task for one job. It is called again whenever the job
+	// is rebuilt on reload.
+	New func(env TaskEnv) Task
+	// Path is the executable of a plugin; it is empty for tasks built into
+	// the runner, and New is unset for plugins.
+	Path string
+}
+
+// ParamType is the type of a task parameter.
+type ParamType string
+
+// Parameter types, and the Go type TaskParams holds them as.
+const (
+	ParamString   ParamType = "string"   // string
+	ParamInt      ParamType = "int"      // int
+	ParamFloat    ParamType = "float"    // float64
+	ParamBool     ParamType = "bool"     // bool
+	ParamDuration ParamType = "duration" // time.Duration
+	ParamList     ParamType = "list"     // []string, comma-separated
+)
+
+// ParamSpec declares one task parameter.
+type ParamSpec struct {
+	Name        string
+	Type        ParamType
+	Description string
+	Required    bool
+	// Default is used when a job does not set the parameter, written as it
+	// would be in runner.conf.
+	Default string
+	// Choices, if set, lists the allowed values of a string parameter.
+	Choices []string
+	// Validate, if set, checks the parsed value further.
+	Validate func(value any) error
+}
+
+// parse converts a value from runner.conf to the parameter's type.
+func (p ParamSpec) parse(raw string) (any, error) {
+	var value any
+	switch p.Type {
+	case ParamString:
+		if len(p.Choices) > 0 && !slices.Contains(p.Choices, raw) {
+			return nil, fmt.Errorf("must be one of %s, got %q", strings.Join(p.Choices, ", "), raw)
+		}
+		value = raw
+	case ParamInt:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer, got %q", raw)
+		}
+		value = n
+	case ParamFloat:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number, got %q", raw)
+		}
+		value = f
+	case ParamBool:
+		switch strings.ToLower(raw) {
+		case "true", "yes", "on", "1":
+			value = true
+		case "false", "no", "off", "0":
+			value = false
+		default:
+			return nil, fmt.Errorf("must be a boolean, got %q", raw)
+		}
+	case ParamDuration:
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("must be a duration like 30s or 5m, got %q", raw)
+		}
+		value = d
+	case ParamList:
+		var list []string
+		for _, part := range strings.Split(raw, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+		value = list
+	default:
+		return nil, fmt.Errorf("unknown parameter type %q", p.Type)
+	}
+	if p.Validate != nil {
+		if err := p.Validate(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// TaskParams holds the validated parameters of a task run. The accessors
+// return the zero value for parameters that are neither set nor defaulted.
+type TaskParams struct {
+	values map[string]any
+}
+
+// Has reports whether the parameter is set or has a default.
+func (p TaskParams) Has(name string) bool {
+	_, ok := p.values[name]
+	return ok
+}
+
+func (p TaskParams) String(name string) string {
+	s, _ := p.values[name].(string)
+	return s
+}
+
+func (p TaskParams) Int(name string) int {
+	n, _ := p.values[name].(int)
+	return n
+}
+
+func (p TaskParams) Float(name string) float64 {
+	f, _ := p.values[name].(float64)
+	return f
+}
+
+func (p TaskParams) Bool(name string) bool {
+	b, _ := p.values[name].(bool)
+	return b
+}
+
+func (p TaskParams) Duration(name string) time.Duration {
+	d, _ := p.values[name].(time.Duration)
+	return d
+}
+
+func (p TaskParams) List(name string) []string {
+	l, _ := p.values[name].([]string)
+	return slices.Clone(l)
+}
+
+var (
+	taskRegistryMu sync.RWMutex
+	taskRegistry   = make(map[string]TaskDefinition)
+)
+
+// RegisterTask makes a task available to jobs. It is meant to be called from
+// init functions and panics if def is invalid or its name is taken.
+func RegisterTask(def TaskDefinition) {
+	if !taskNamePattern.MatchString(def.Name) {
+		panic(fmt.Sprintf("service: invalid task name %q", def.Name))
+	}
+	if def.New == nil {
+		panic(fmt.Sprintf("service: task %q has no New function", def.Name))
+	}
+	seen := make(map[string]bool)
+	for _, p := range def.Params {
+		if p.Name == "" || seen[p.Name] {
+			panic(fmt.Sprintf("service: task %q has an empty or duplicate parameter %q", def.Name, p.Name))
+		}
+		seen[p.Name] = true
+		if p.Default == "" {
+			continue
+		}
+		if _, err := p.parse(p.Default); err != nil {
+			panic(fmt.Sprintf("service: task %q parameter %q: invalid default: %v", def.Name, p.Name, err))
+		}
+	}
+
+	taskRegistryMu.Lock()
+	defer taskRegistryMu.Unlock()
+	if _, dup := taskRegistry[def.Name]; dup {
+		panic(fmt.Sprintf("service: task %q registered twice", def.Name))
+	}
+	taskRegistry[def.Name] = def
+}
+
+// lookupTask returns the registered task or plugin of that name.
+func lookupTask(name string) (TaskDefinition, bool) {
+	if def, ok := lookupBuiltinTask(name); ok {
+		return def, true
+	}
+	return lookupPlugin(name)
+}
+
+func lookupBuiltinTask(name string) (TaskDefinition, bool) {
+	taskRegistryMu.RLock()
+	defer taskRegistryMu.RUnlock()
+	def, ok := taskRegistry[name]
+	return def, ok
+}
+
+// RegisteredTasks returns the registered tasks and the plugins found in the
+// last scan of the plugins directory, sorted by name.
+func RegisteredTasks() []TaskDefinition {
+	taskRegistryMu.RLock()
+	defs := make([]TaskDefinition, 0, len(taskRegistry))
+	for _, def := range taskRegistry {
+		defs = append(defs, def)
+	}
+	taskRegistryMu.RUnlock()
+	pluginMu.RLock()
+	for _, def := range pluginTasks {
+		defs = append(defs, def)
+	}
+	pluginMu.RUnlock()
+	slices.SortFunc(defs, func(a, b TaskDefinition) int { return strings.Compare(a.Name, b.Name) })
+	return defs
+}
+
+// JobType describes a task or plugin that jobs can use as their type.
+type JobType struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Path        string         `json:"path,omitempty"` // plugins only
+	Params      []JobTypeParam `json:"params"`
+	// Error says why a plugin could not be loaded; Name is then its file name.
+	Error string `json:"error,omitempty"`
+}
+
+// JobTypeParam describes a parameter of a job type.
+type JobTypeParam struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Required    bool     `json:"required"`
+	Default     string   `json:"default,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+}
+
+// JobTypes rescans the plugins directory and returns the job types provided
+// by tasks and plugins, followed by the plugins that failed to load.
+func JobTypes() []JobType {
+	scanPlugins()
+	var types []JobType
+	for _, def := range RegisteredTasks() {
+		jt := JobType{Name: def.Name, Description: def.Description, Path: def.Path, Params: []JobTypeParam{}}
+		for _, p := range def.Params {
+			jt.Params = append(jt.Params, JobTypeParam{
+				Name:        p.Name,
+				Type:        string(p.Type),
+				Description: p.Description,
+				Required:    p.Required,
+				Default:     p.Default,
+				Choices:     p.Choices,
+			})
+		}
+		types = append(types, jt)
+	}
+
+	pluginMu.RLock()
+	defer pluginMu.RUnlock()
+	paths := make([]string, 0, len(pluginErrs))
+	for path := range pluginErrs {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		types = append(types, JobType{
+			Name:   filepath.Base(path),
+			Path:   path,
+			Params: []JobTypeParam{},
+			Error:  pluginErrs[path].Error(),
+		})
+	}
+	return types
+}
+
+func (def TaskDefinition) param(name string) (ParamSpec, bool) {
+	for _, p := range def.Params {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ParamSpec{}, false
+}
+
+func (def TaskDefinition) paramNames() string {
+	names := make([]string, len(def.Params))
+	for i, p := range def.Params {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// newParams validates "name=value" parameters against the schema and fills
+// in the defaults.
+func (def TaskDefinition) newParams(raw []string) (TaskParams, error) {
+	params := TaskParams{values: make(map[string]any)}
+	for _, p := range def.Params {
+		if p.Default != "" {
+			params.values[p.Name], _ = p.parse(p.Default)
+		}
+	}
+	set := make(map[string]bool)
+	for _, kv := range raw {
+		name, value, _ := strings.Cut(kv, "=")
+		spec, ok := def.param(name)
+		if !ok {
+			return params, fmt.Errorf("task %q has no parameter %q", def.Name, name)
+		}
+		v, err := spec.parse(value)
+		if err != nil {
+			return params, fmt.Errorf("param %s: %w", name, err)
+		}
+		params.values[name] = v
+		set[name] = true
+	}
+	for _, p := range def.Params {
+		if p.Required && !set[p.Name] {
+			return params, fmt.Errorf("task %q needs param %s", def.Name, p.Name)
+		}
+	}
+	return params, nil
+}
+
+// decodeTaskJob reads the task and param keys of a task job, checking the
+// parameters against the task's schema. Values that refer to secrets are
+// checked once the secrets are known, when the job is built.
+func decodeTaskJob(d *sectionDecoder, job *JobConfig) {
+	if job.Type == jobTypeTask {
+		line := d.required("task", &job.Task)
+		if job.Task == "" {
+			return
+		}
+		if _, ok := lookupTask(job.Task); !ok {
+			d.errorf(line, "unknown task %q (run go-toy job-types for the list)", job.Task)
+			return
+		}
+	}
+	def, _ := lookupTask(job.Task)
+
+	seen := make(map[string]int)
+	for _, e := range d.all("param") {
+		name, value, ok := strings.Cut(e.value, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			d.errorf(e.line, "param must be name=value, got %q", e.value)
+			continue
+		}
+		if first, dup := seen[name]; dup {
+			d.errorf(e.line, "param %s set twice (first on line %d)", name, first)
+			continue
+		}
+		seen[name] = e.line
+		spec, ok := def.param(name)
+		if !ok {
+			d.errorf(e.line, "task %q has no parameter %q (it takes: %s)", def.Name, name, def.paramNames())
+			continue
+		}
+		if err := checkSecretRefs(value); err != nil {
+			d.errorf(e.line, "param %s: %v", name, err)
+			continue
+		}
+		if !secretRefPattern.MatchString(value) {
+			if _, err := spec.parse(value); err != nil {
+				d.errorf(e.line, "param %s: %v", name, err)
+				continue
+			}
+		}
+		job.Params = append(job.Params, name+"="+value)
+	}
+	for _, p := range def.Params {
+		if _, ok := seen[p.Name]; p.Required && !ok {
+			d.errorf(d.sec.line, "%s: task %q needs param %s", d.describe(), def.Name, p.Name)
+		}
+	}
+	d.duration("timeout", &job.Timeout)
+}
+
+// runTask runs task with params, bounded by timeout if set, and records the
+// outcome in rec. A panicking task fails the run instead of the runner; the
+// stack trace is kept as the run's stderr.
+func runTask(ctx context.Context, task Task, params TaskParams, timeout time.Duration, rec *RunRecord) (err error) {
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var result TaskResult
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("task panicked: %v", p)
+				rec.Stderr = string(debug.Stack())
+			}
+		}()
+		result, err = task.Run(runCtx, params)
+	}()
+	rec.Stdout = shared.Redact(result.Output)
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		rec.Status = runStatusTimeout
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	case ctx.Err() != nil:
+		rec.Status = runStatusCancelled
+		return fmt.Errorf("cancelled: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"go-toy/internal/shared"
+)
+
+// Built-in tasks.
+
+const heartbeatTaskName = "heartbeat"
+
+func init() {
+	RegisterTask(TaskDefinition{
+		Name:        heartbeatTaskName,
+		Description: "Logs that the runner is alive",
+		Params: []ParamSpec{
+			{Name: "message", Type: ParamString, Default: "Staying alive", Description: "line logged on every beat"},
+			{Name: "first_message", Type: ParamString, Default: "I'm alive", Description: "line logged on the first beat"},
+		},
+		// The heartbeat bypasses the worker pool so busy jobs cannot delay it.
+		Dedicated: true,
+		New: func(env TaskEnv) Task {
+			return &heartbeatTask{log: env.Log}
+		},
+	})
+}
+
+// heartbeatTask logs a line on every run; the first one says so explicitly.
+type heartbeatTask struct {
+	log  *shared.Logger
+	once sync.Once
+}
+
+func (t *heartbeatTask) Run(ctx context.Context, params TaskParams) (TaskResult, error) {
+	message := params.String("message")
+	t.once.Do(func() { message = params.String("first_message") })
+	t.log.Info(message)
+	return TaskResult{}, nil
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPreviewCount = 10
+	maxPreviewCount     = 1000
+	// maxBlackoutSkips bounds the blackout windows skipped while looking for
+	// the next run, so a schedule that is always blacked out ends instead of
+	// searching forever.
+	maxBlackoutSkips = 10000
+)
+
+// ScheduleOptions refine when a job's schedule fires.
+type ScheduleOptions struct {
+	// TimeZone is the IANA zone the schedule is evaluated in. Empty means
+	// the system zone.
+	TimeZone string
+	// Jitter delays each scheduled run by a random duration up to this long.
+	Jitter time.Duration
+	// Blackout lists windows, such as "Mon-Fri 09:00-17:00", in which the
+	// schedule does not fire.
+	Blackout []string
+}
+
+// decodeScheduleOptions reads the timing keys of a job or workflow section
+// and returns schedule adjusted to them.
+func decodeScheduleOptions(d *sectionDecoder, o *ScheduleOptions, schedule Schedule) Schedule {
+	*o = ScheduleOptions{}
+	loc := time.Local
+	if line, ok := d.str("timezone", &o.TimeZone); ok {
+		l, err := time.LoadLocation(o.TimeZone)
+		if err != nil || o.TimeZone == "" || o.TimeZone == "Local" {
+			d.errorf(line, "unknown time zone %q (want an IANA name such as Europe/Paris)", o.TimeZone)
+		} else {
+			loc = l
+		}
+	}
+	d.duration("jitter", &o.Jitter)
+
+	var windows []blackoutWindow
+	for _, e := range d.all("blackout") {
+		w, err := parseBlackoutWindow(e.value)
+		if err != nil {
+			d.errorf(e.line, "invalid blackout %q: %v", e.value, err)
+			continue
+		}
+		o.Blackout = append(o.Blackout, e.value)
+		windows = append(windows, w)
+	}
+
+	if schedule == nil {
+		return nil
+	}
+	_, interval := schedule.(everySchedule)
+	return &zonedSchedule{inner: schedule, loc: loc, wall: !interval, blackout: windows}
+}
+
+// zonedSchedule evaluates a schedule in a time zone and leaves out the runs
+// that fall into a blackout window.
+type zonedSchedule struct {
+	inner Schedule
+	loc   *time.Location
+	// wall evaluates inner on the wall clock of loc, so a cron expression
+	// keeps its local meaning across DST transitions. Fixed intervals run on
+	// absolute time instead.
+	wall     bool
+	blackout []blackoutWindow
+}
+
+func (z *zonedSchedule) Next(t time.Time) time.Time {
+	next := z.next(t)
+	for range maxBlackoutSkips {
+		if next.IsZero() {
+			return next
+		}
+		until, ok := z.blackedOut(next)
+		if !ok {
+			return next
+		}
+		if z.wall {
+			// The end of the window is itself a candidate.
+			next = z.next(until.Add(-time.Nanosecond))
+		} else {
+			// An interval resumes when the window ends.
+			next = until
+		}
+	}
+	return time.Time{}
+}
+
+// next returns the next activation after t, ignoring blackout windows. A
+// wall-clock time repeated when the clocks go back fires only once; one
+// skipped when they go forward fires the length of the gap later.
+func (z *zonedSchedule) next(t time.Time) time.Time {
+	if !z.wall {
+		next := z.inner.Next(t)
+		if next.IsZero() {
+			return next
+		}
+		return next.In(z.loc)
+	}
+	w := wallClock(t, z.loc)
+	for {
+		w = z.inner.Next(w)
+		if w.IsZero() {
+			return w
+		}
+		if at := fromWallClock(w, z.loc); at.After(t) {
+			return at
+		}
+	}
+}
+
+// blackedOut reports whether t falls into a blackout window and, if so, when
+// the window ends.
+func (z *zonedSchedule) blackedOut(t time.Time) (time.Time, bool) {
+	local := t.In(z.loc)
+	for _, w := range z.blackout {
+		if until, ok := w.contains(local); ok {
+			return until, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// blackedOut reports whether schedule has a blackout window covering t and,
+// if so, when it ends.
+func blackedOut(schedule Schedule, t time.Time) (time.Time, bool) {
+	z, ok := schedule.(*zonedSchedule)
+	if !ok {
+		return time.Time{}, false
+	}
+	return z.blackedOut(t)
+}
+
+// wallClock returns the clock reading of t in loc as the same reading in
+// UTC, where no DST transitions happen.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+// fromWallClock returns the instant at which the clocks in loc show w. A
+// reading that occurs twice maps to its first occurrence; one that does not
+// occur is moved forward by the length of the gap.
+func fromWallClock(w time.Time, loc *time.Location) time.Time {
+	t := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), w.Nanosecond(), loc)
+	_, before := t.Add(-6 * time.Hour).Zone()
+	_, after := t.Add(6 * time.Hour).Zone()
+	if before == after {
+		return t
+	}
+	early := w.Add(-time.Duration(max(before, after)) * time.Second).In(loc)
+	if wallClock(early, loc).Equal(w) {
+		return early
+	}
+	return w.Add(-time.Duration(min(before, after)) * time.Second).In(loc)
+}
+
+// blackoutWindow is a daily time range, optionally limited to some weekdays.
+// A range that ends before it starts runs past midnight into the next day.
+type blackoutWindow struct {
+	// days is a bitset of the weekdays the window starts on.
+	days       uint8
+	start, end time.Duration
+}
+
+// parseBlackoutWindow parses "[days] HH:MM-HH:MM", where days is a list of
+// weekdays or weekday ranges such as "Mon-Fri" or "Sat,Sun".
+func parseBlackoutWindow(s string) (blackoutWindow, error) {
+	w := blackoutWindow{days: 0x7f}
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+	case 2:
+		days, err := parseCronField(fields[0], dowField)
+		if err != nil {
+			return w, err
+		}
+		w.days = uint8(days & 0x7f)
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("expected [days] HH:MM-HH:MM")
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("expected a time range such as 09:00-17:00")
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(from); err != nil {
+		return w, err
+	}
+	if w.end, err = parseTimeOfDay(to); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("time range is empty")
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses HH:MM, allowing 24:00 for the end of the day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, err1 := strconv.Atoi(hh)
+	m, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || len(mm) != 2 || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// contains reports whether the local time t is inside the window and, if
+// so, when the window ends.
+func (w blackoutWindow) contains(t time.Time) (time.Time, bool) {
+	y, mo, d := t.Date()
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	at := func(day int, clock time.Duration) time.Time {
+		h, m := int(clock/time.Hour), int(clock%time.Hour/time.Minute)
+		return time.Date(y, mo, day, h, m, 0, 0, t.Location())
+	}
+	startsOn := func(day time.Weekday) bool { return w.days&(1<<uint(day)) != 0 }
+
+	if w.start < w.end {
+		if startsOn(t.Weekday()) && sinceMidnight >= w.start && sinceMidnight < w.end {
+			return at(d, w.end), true
+		}
+		return time.Time{}, false
+	}
+	// The window runs past midnight: t is either in today's first part or in
+	// the tail of yesterday's.
+	if startsOn(t.Weekday()) && sinceMidnight >= w.start {
+		return at(d+1, w.end), true
+	}
+	if startsOn((t.Weekday()+6)%7) && sinceMidnight < w.end {
+		return at(d, w.end), true
+	}
+	return time.Time{}, false
+}
+
+// SchedulePreview lists the next activation times of a job or workflow.
+type SchedulePreview struct {
+	Job      string `json:"job"`
+	Schedule string `json:"schedule"`
+	// TimeZone is empty for the system zone.
+	TimeZone string   `json:"time_zone"`
+	Jitter   string   `json:"jitter,omitempty"`
+	Blackout []string `json:"blackout,omitempty"`
+	// Times are the scheduled times; with jitter, each run starts up to
+	// Jitter later.
+	Times []time.Time `json:"times"`
+}
+
+// PreviewSchedule returns the next count activation times of the named job
+// or workflow as defined in runner.conf, so a schedule can be checked
+// before the runner loads it.
+func PreviewSchedule(name string, count int) (*SchedulePreview, error) {
+	if count <= 0 {
+		count = defaultPreviewCount
+	}
+	count = min(count, maxPreviewCount)
+
+	configPath, err := getRunnerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var preview *SchedulePreview
+	var schedule Schedule
+	var opts ScheduleOptions
+	for _, jc := range cfg.Jobs {
+		if jc.Name == name {
+			preview = &SchedulePreview{Job: name, Schedule: jc.Schedule}
+			schedule, opts = jc.schedule, jc.Timing
+		}
+	}
+	for _, wf := range cfg.Workflows {
+		if wf.Name == name {
+			preview = &SchedulePreview{Job: name, Schedule: wf.Schedule}
+			schedule, opts = wf.schedule, wf.Timing
+		}
+	}
+	if preview == nil {
+		return nil, fmt.Errorf("unknown job %q", name)
+	}
+	if schedule == nil {
+		return nil, fmt.Errorf("job %q has no schedule", name)
+	}
+
+	preview.TimeZone = opts.TimeZone
+	if opts.Jitter > 0 {
+		preview.Jitter = opts.Jitter.String()
+	}
+	preview.Blackout = opts.Blackout
+	preview.Times = []time.Time{}
+	for t := schedule.Next(time.Now()); !t.IsZero() && len(preview.Times) < count; t = schedule.Next(t) {
+		preview.Times = append(preview.Times, t)
+	}
+	return preview, nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZonedScheduleDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(mo time.Month, d, h, m int) time.Time { return time.Date(2024, mo, d, h, m, 0, 0, time.UTC) }
+	// In 2024 Berlin goes from CET (+1) to CEST (+2) at 01:00 UTC on 31
+	// March and back at 01:00 UTC on 27 October.
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"daily run in the skipped hour fires an hour later", "30 2 * * *", utc(time.March, 30, 11, 0), utc(time.March, 31, 1, 30)},
+		{"daily run before the gap", "30 1 * * *", utc(time.March, 30, 11, 0), utc(time.March, 31, 0, 30)},
+		{"daily run after the gap", "30 3 * * *", utc(time.March, 30, 11, 0), utc(time.March, 31, 1, 30)},
+		{"half-hourly into the gap", "*/30 * * * *", utc(time.March, 31, 0, 45), utc(time.March, 31, 1, 0)},
+		{"half-hourly past the gap", "*/30 * * * *", utc(time.March, 31, 1, 0), utc(time.March, 31, 1, 30)},
+		{"repeated time fires on its first occurrence", "30 2 * * *", utc(time.October, 26, 10, 0), utc(time.October, 27, 0, 30)},
+		{"repeated time does not fire again", "30 2 * * *", utc(time.October, 27, 0, 30), utc(time.October, 28, 1, 30)},
+		{"half-hourly skips the repeated hour", "*/30 * * * *", utc(time.October, 27, 0, 45), utc(time.October, 27, 2, 0)},
+		{"intervals run on absolute time", "@every 1h", utc(time.October, 27, 0, 30), utc(time.October, 27, 1, 30)},
+	}
+	for _, tt := range tests {
+		inner, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, interval := inner.(everySchedule)
+		z := &zonedSchedule{inner: inner, loc: loc, wall: !interval}
+		if got := z.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%s: %q Next(%s) = %s, want %s", tt.name, tt.spec, tt.from.In(loc), got, tt.want.In(loc))
+		}
+	}
+}
+
+func TestParseBlackoutWindow(t *testing.T) {
+	const (
+		sun = 1 << 0
+		mon = 1 << 1
+		fri = 1 << 5
+		sat = 1 << 6
+	)
+	tests := []struct {
+		spec string
+		want blackoutWindow
+	}{
+		{"09:00-17:00", blackoutWindow{0x7f, 9 * time.Hour, 17 * time.Hour}},
+		{"Mon-Fri 09:00-17:00", blackoutWindow{0x3e, 9 * time.Hour, 17 * time.Hour}},
+		{"Sat,Sun 00:00-24:00", blackoutWindow{sat | sun, 0, 24 * time.Hour}},
+		{"7 08:30-09:15", blackoutWindow{sun, 8*time.Hour + 30*time.Minute, 9*time.Hour + 15*time.Minute}},
+		{"5-7 12:00-13:00", blackoutWindow{fri | sat | sun, 12 * time.Hour, 13 * time.Hour}},
+		{"Mon,7 22:00-06:00", blackoutWindow{mon | sun, 22 * time.Hour, 6 * time.Hour}},
+	}
+	for _, tt := range tests {
+		got, err := parseBlackoutWindow(tt.spec)
+		if err != nil {
+			t.Errorf("parseBlackoutWindow(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBlackoutWindow(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseBlackoutWindowErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{"", "expected [days] HH:MM-HH:MM"},
+		{"Mon Tue 09:00-10:00", "expected [days] HH:MM-HH:MM"},
+		{"Mon 09:00", "expected a time range"},
+		{"Mon 09:00-09:00", "time range is empty"},
+		{"Mon 9-10", `invalid time "9"`},
+		{"Mon 09:00-24:30", `invalid time "24:30"`},
+		{"Mon 09:60-10:00", `invalid time "09:60"`},
+		{"8 09:00-10:00", "out of range"},
+		{"Someday 09:00-10:00", "invalid value"},
+	}
+	for _, tt := range tests {
+		_, err := parseBlackoutWindow(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseBlackoutWindow(%q) error = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+// A window that wraps midnight belongs to the day it starts on.
+func TestBlackoutWindowAcrossMidnight(t *testing.T) {
+	w, err := parseBlackoutWindow("Fri 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-03-15 is a Friday.
+	at := func(d, h, m int) time.Time { return time.Date(2024, 3, d, h, m, 0, 0, time.UTC) }
+	satMorning := at(16, 6, 0)
+	tests := []struct {
+		t     time.Time
+		until time.Time
+	}{
+		{at(15, 21, 59), time.Time{}},
+		{at(15, 22, 0), satMorning},
+		{at(15, 23, 59), satMorning},
+		{at(16, 0, 0), satMorning},
+		{at(16, 5, 59), satMorning},
+		{at(16, 6, 0), time.Time{}},
+		// Saturday night is not blacked out, nor is Friday's early morning.
+		{at(16, 23, 0), time.Time{}},
+		{at(15, 3, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		until, ok := w.contains(tt.t)
+		if ok != !tt.until.IsZero() || !until.Equal(tt.until) {
+			t.Errorf("contains(%s) = %s, %v; want %s", tt.t, until, ok, tt.until)
+		}
+	}
+
+	inner, err := ParseSchedule("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := &zonedSchedule{inner: inner, loc: time.UTC, wall: true, blackout: []blackoutWindow{w}}
+	if got := z.Next(at(15, 21, 30)); !got.Equal(satMorning) {
+		t.Errorf("Next = %s, want %s", got, satMorning)
+	}
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"go-toy/internal/shared"
+)
+
+const (
+	defaultWatchDebounce = time.Second
+	// watchRetryInterval is how long a failed watch waits before trying to
+	// re-establish itself, e.g. after its directory was removed.
+	watchRetryInterval = 30 * time.Second
+	// maxTriggerPaths caps the paths collected in one debounce window.
+	maxTriggerPaths = 100
+)
+
+// Watch event names accepted by watch_events.
+const (
+	watchEventCreate = "create"
+	watchEventModify = "modify"
+	watchEventDelete = "delete"
+	watchEventRename = "rename"
+)
+
+type watchEventOp struct {
+	name string
+	op   fsnotify.Op
+}
+
+var watchEventOps = []watchEventOp{
+	{watchEventCreate, fsnotify.Create},
+	{watchEventModify, fsnotify.Write},
+	{watchEventDelete, fsnotify.Remove},
+	{watchEventRename, fsnotify.Rename},
+}
+
+// WatchConfig describes the filesystem changes that trigger a job.
+type WatchConfig struct {
+	Paths []string
+	// Patterns are globs matched against file names; empty matches all.
+	Patterns  []string
+	Recursive bool
+	Events    []string
+	// Debounce is the quiet period after the last event before the job is
+	// triggered with every path collected in the meantime.
+	Debounce time.Duration
+}
+
+func decodeWatch(d *sectionDecoder, w *WatchConfig) {
+	*w = WatchConfig{
+		Events:   []string{watchEventCreate, watchEventModify},
+		Debounce: defaultWatchDebounce,
+	}
+
+	for _, e := range d.all("watch") {
+		if !filepath.IsAbs(e.value) {
+			d.errorf(e.line, "watch path must be absolute, got %q", e.value)
+			continue
+		}
+		w.Paths = append(w.Paths, filepath.Clean(e.value))
+	}
+	if line := d.nameList("watch_pattern", &w.Patterns); line > 0 {
+		for _, pattern := range w.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				d.errorf(line, "invalid watch_pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	if line := d.nameList("watch_events", &w.Events); line > 0 {
+		for _, name := range w.Events {
+			if !slices.ContainsFunc(watchEventOps, func(e watchEventOp) bool { return e.name == name }) {
+				d.errorf(line, "unknown watch event %q (want create, modify, delete or rename)", name)
+			}
+		}
+	}
+	d.boolean("watch_recursive", &w.Recursive)
+	d.duration("watch_debounce", &w.Debounce)
+}
+
+// eventName returns the name of the first selected event kind in op.
+func (w WatchConfig) eventName(op fsnotify.Op) (string, bool) {
+	for _, e := range watchEventOps {
+		if op.Has(e.op) && slices.Contains(w.Events, e.name) {
+			return e.name, true
+		}
+	}
+	return "", false
+}
+
+func (w WatchConfig) matches(path string) bool {
+	if len(w.Patterns) == 0 {
+		return true
+	}
+	name := filepath.Base(path)
+	for _, pattern := range w.Patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchManager keeps one filesystem watch per job with watch paths and fires
+// the job when matching events arrive.
+type watchManager struct {
+	log  *shared.Logger
+	fire func(job string, paths []string, event string) error
+
+	mu      sync.Mutex
+	watches map[string]*jobWatch
+}
+
+type jobWatch struct {
+	job  string
+	cfg  WatchConfig
+	key  string
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newWatchManager(log *shared.Logger, fire func(job string, paths []string, event string) error) *watchManager {
+	return &watchManager{
+		log:     log,
+		fire:    fire,
+		watches: make(map[string]*jobWatch),
+	}
+}
+
+// Reload establishes watches for the enabled jobs in jobs that have watch
+// paths. Watches whose configuration is unchanged are kept; the others are
+// torn down and set up again.
+func (m *watchManager) Reload(jobs []JobConfig) {
+	next := make(map[string]WatchConfig)
+	for _, jc := range jobs {
+		if jc.Enabled && len(jc.Watch.Paths) > 0 {
+			next[jc.Name] = jc.Watch
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, w := range m.watches {
+		if cfg, ok := next[name]; ok && fmt.Sprintf("%+v", cfg) == w.key {
+			delete(next, name)
+			continue
+		}
+		close(w.stop)
+		<-w.done
+		delete(m.watches, name)
+	}
+	for name, cfg := range next {
+		w := &jobWatch{
+			job:  name,
+			cfg:  cfg,
+			key:  fmt.Sprintf("%+v", cfg),
+			stop: make(chan struct{}),
+			done: make(chan struct{}),
+		}
+		m.watches[name] = w
+		go m.run(w)
+	}
+}
+
+// Close stops every watch and waits for them to exit.
+func (m *watchManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, w := range m.watches {
+		close(w.stop)
+		<-w.done
+		delete(m.watches, name)
+	}
+}
+
+// run keeps w established until it is stopped, retrying after failures.
+func (m *watchManager) run(w *jobWatch) {
+	defer close(w.done)
+	for {
+		err := m.watch(w)
+		if err == nil {
+			return
+		}
+		m.log.Error("Watch failed, retrying", "job", w.job, "error", err, "delay", watchRetryInterval)
+
+		timer := time.NewTimer(watchRetryInterval)
+		select {
+		case <-w.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// watch runs one fsnotify watcher for w. It returns nil once w is stopped
+// and an error when the watch can no longer be trusted.
+func (m *watchManager) watch(w *jobWatch) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	for _, root := range w.cfg.Paths {
+		if _, err := addWatchTree(fsw, root, w.cfg.Recursive); err != nil {
+			return err
+		}
+	}
+
+	var (
+		pending []string
+		seen    = make(map[string]bool)
+		event   string
+		timer   *time.Timer
+		timerC  <-chan time.Time
+	)
+	queue := func(path, name string) {
+		if !seen[path] && len(pending) < maxTriggerPaths {
+			seen[path] = true
+			pending = append(pending, path)
+		}
+		event = name
+	}
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := m.fire(w.job, pending, event); err != nil {
+			m.log.Error("Failed to trigger job", "job", w.job, "error", err)
+		}
+		pending, seen = nil, make(map[string]bool)
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-w.stop:
+			return nil
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			// Typically an event queue overflow: events were lost, so the
+			// watch is rebuilt from scratch.
+			flush()
+			return fmt.Errorf("watcher error: %w", err)
+
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Remove|fsnotify.Rename) && slices.Contains(w.cfg.Paths, ev.Name) {
+				flush()
+				return fmt.Errorf("%s was removed", ev.Name)
+			}
+
+			matched := false
+			if ev.Has(fsnotify.Create) && isDir(ev.Name) {
+				if !w.cfg.Recursive {
+					continue
+				}
+				// Files may have appeared before the new directory was
+				// watched; treat them as created too.
+				files, err := addWatchTree(fsw, ev.Name, true)
+				if err != nil {
+					m.log.Warn("Failed to watch new directory", "job", w.job, "error", err)
+				}
+				if slices.Contains(w.cfg.Events, watchEventCreate) {
+					for _, f := range files {
+						if w.cfg.matches(f) {
+							queue(f, watchEventCreate)
+							matched = true
+						}
+					}
+				}
+			} else if name, ok := w.cfg.eventName(ev.Op); ok && w.cfg.matches(ev.Name) {
+				queue(ev.Name, name)
+				matched = true
+			}
+			if !matched {
+				continue
+			}
+			if w.cfg.Debounce == 0 {
+				flush()
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(w.cfg.Debounce)
+				timerC = timer.C
+			} else {
+				timer.Reset(w.cfg.Debounce)
+			}
+
+		case <-timerC:
+			flush()
+		}
+	}
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// addWatchTree watches root and, if recursive, every directory below it. It
+// returns the regular files found below root when recursing.
+func addWatchTree(fsw *fsnotify.Watcher, root string, recursive bool) ([]string, error) {
+	// Watching a file directly breaks as soon as an editor replaces it, so
+	// watches are always on directories.
+	if !isDir(root) {
+		return nil, fmt.Errorf("failed to watch %s: not a directory", root)
+	}
+	if err := fsw.Add(root); err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", root, err)
+	}
+	if !recursive {
+		return nil, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries can vanish while walking; skip them.
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if d.IsDir() {
+			if err := fsw.Add(path); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", path, err)
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -56,7 +56,7 @@ func (w *windowsService) Status() (string, error) {
 	}
 
 	if strings.Contains(output, "RUNNING") {
-		return "Running", nil
+		return withRunnerPID("Running", nil)
 	} else if strings.Contains(output, "STOPPED") {
 		return "Stopped", nil
 	}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const jobTypeWorkflow = "workflow"
+
+// WorkflowConfig is one [workflow "<name>"] section: a set of jobs run
+// together as a dependency graph built from their depends_on keys.
+type WorkflowConfig struct {
+	Name     string
+	Schedule string
+	Jobs     []string
+	Enabled  bool
+	Line     int
+	Misfire  MisfirePolicy
+	Timing   ScheduleOptions
+	Notify   NotifyRoute
+
+	schedule Schedule
+	jobsLine int
+}
+
+func decodeWorkflow(d *sectionDecoder) (WorkflowConfig, bool) {
+	wf := WorkflowConfig{
+		Name:    d.sec.name,
+		Enabled: true,
+		Line:    d.sec.line,
+	}
+	before := len(d.errs)
+
+	scheduleLine := d.required("schedule", &wf.Schedule)
+	if wf.Schedule != "" {
+		schedule, err := ParseSchedule(wf.Schedule)
+		if err != nil {
+			d.errorf(scheduleLine, "invalid schedule: %v", err)
+		}
+		wf.schedule = schedule
+	}
+	wf.schedule = decodeScheduleOptions(d, &wf.Timing, wf.schedule)
+	wf.jobsLine = d.nameList("jobs", &wf.Jobs)
+	if len(wf.Jobs) == 0 && len(d.errs) == before {
+		d.errorf(d.sec.line, "%s: missing required key %q", d.describe(), "jobs")
+	}
+	d.boolean("enabled", &wf.Enabled)
+	decodeMisfire(d, &wf.Misfire)
+	decodeNotifyRoute(d, &wf.Notify)
+
+	return wf, len(d.errs) == before
+}
+
+// validateWorkflows checks the relations between jobs and workflows: every
+// reference resolves, dependencies stay inside a workflow, and the dependency
+// graph is acyclic.
+func validateWorkflows(cfg *Config) []error {
+	var errs []error
+	errorf := func(line int, format string, args ...any) {
+		errs = append(errs, &ConfigError{Path: cfg.Path, Line: line, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	jobs := make(map[string]*JobConfig, len(cfg.Jobs))
+	for i := range cfg.Jobs {
+		jobs[cfg.Jobs[i].Name] = &cfg.Jobs[i]
+	}
+
+	inWorkflow := make(map[string]bool)
+	for _, wf := range cfg.Workflows {
+		if _, clash := jobs[wf.Name]; clash {
+			errorf(wf.Line, "workflow %q has the same name as a job", wf.Name)
+		}
+		members := make(map[string]bool, len(wf.Jobs))
+		for _, name := range wf.Jobs {
+			members[name] = true
+		}
+		for _, name := range wf.Jobs {
+			job, ok := jobs[name]
+			if !ok {
+				errorf(wf.jobsLine, "workflow %q lists unknown job %q", wf.Name, name)
+				continue
+			}
+			inWorkflow[name] = true
+			for _, dep := range job.DependsOn {
+				if _, known := jobs[dep]; known && !members[dep] {
+					errorf(wf.jobsLine, "job %q depends on %q, which is not part of workflow %q", name, dep, wf.Name)
+				}
+			}
+		}
+	}
+
+	for _, job := range cfg.Jobs {
+		for _, dep := range job.DependsOn {
+			if _, ok := jobs[dep]; !ok {
+				errorf(job.dependsOnLine, "job %q depends on unknown job %q", job.Name, dep)
+			}
+		}
+		if len(job.DependsOn) > 0 && !inWorkflow[job.Name] {
+			errorf(job.dependsOnLine, "depends_on only applies to jobs that are part of a workflow")
+		}
+	}
+
+	if cycle := findDependencyCycle(cfg.Jobs); cycle != nil {
+		errorf(jobs[cycle[0]].dependsOnLine, "dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return errs
+}
+
+// findDependencyCycle returns the jobs on a depends_on cycle, starting and
+// ending with the same job, or nil if the graph is acyclic.
+func findDependencyCycle(jobs []JobConfig) []string {
+	deps := make(map[string][]string, len(jobs))
+	for _, job := range jobs {
+		deps[job.Name] = job.DependsOn
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(jobs))
+	var stack []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range deps[name] {
+			switch state[dep] {
+			case visiting:
+				start := slices.Index(stack, dep)
+				return append(slices.Clone(stack[start:]), dep)
+			case unvisited:
+				if _, known := deps[dep]; !known {
+					continue
+				}
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, job := range jobs {
+		if state[job.Name] == unvisited {
+			if cycle := visit(job.Name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// buildWorkflow returns the scheduler job that runs wf. enabled tells which
+// member jobs are registered with the scheduler; disabled members are
+// skipped like failed ones.
+func (r *runner) buildWorkflow(wf WorkflowConfig, cfg *Config, enabled map[string]bool) Job {
+	deps := make(map[string][]string, len(wf.Jobs))
+	for _, jc := range cfg.Jobs {
+		if slices.Contains(wf.Jobs, jc.Name) {
+			deps[jc.Name] = jc.DependsOn
+		}
+	}
+
+	return Job{
+		Name:         wf.Name,
+		Type:         jobTypeWorkflow,
+		ScheduleSpec: wf.Schedule,
+		Schedule:     wf.schedule,
+		Fingerprint:  fmt.Sprintf("%s %q %v %v %+v %+v", wf.Schedule, wf.Jobs, deps, enabled, wf.Misfire, wf.Timing),
+		Misfire:      wf.Misfire,
+		Jitter:       wf.Timing.Jitter,
+		// A workflow only waits on its members, which run on the pool.
+		Dedicated: true,
+		Run: func(ctx context.Context, rec *RunRecord) error {
+			return r.runWorkflow(ctx, wf.Jobs, deps, enabled, rec)
+		},
+	}
+}
+
+// runWorkflow runs every job once its dependencies have finished, so
+// independent branches run concurrently. A job whose dependency did not
+// succeed is skipped, and so is everything downstream of it.
+func (r *runner) runWorkflow(ctx context.Context, names []string, deps map[string][]string, enabled map[string]bool, rec *RunRecord) error {
+	var (
+		mu       sync.Mutex
+		statuses = make(map[string]string, len(names))
+		done     = make(map[string]chan struct{}, len(names))
+		wg       sync.WaitGroup
+	)
+	for _, name := range names {
+		done[name] = make(chan struct{})
+	}
+
+	for _, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[name])
+
+			reason := ""
+			for _, dep := range deps[name] {
+				<-done[dep]
+				mu.Lock()
+				depStatus := statuses[dep]
+				mu.Unlock()
+				if depStatus != runStatusSuccess && reason == "" {
+					reason = fmt.Sprintf("upstream job %s %s", dep, depStatus)
+				}
+			}
+			switch {
+			case reason != "":
+			case !enabled[name]:
+				reason = "job is disabled"
+			case ctx.Err() != nil:
+				reason = "workflow cancelled"
+			}
+
+			var status string
+			if reason != "" {
+				status = r.scheduler.RecordSkipped(name, triggerWorkflow, rec.ID, reason).Status
+			} else if final, err := r.scheduler.RunNow(ctx, name, triggerWorkflow, rec.ID); err != nil {
+				status = r.scheduler.RecordSkipped(name, triggerWorkflow, rec.ID, err.Error()).Status
+			} else {
+				status = final.Status
+			}
+
+			mu.Lock()
+			statuses[name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Summarise member outcomes in the workflow's own run record.
+	var summary strings.Builder
+	counts := make(map[string]int)
+	for _, name := range names {
+		fmt.Fprintf(&summary, "%s: %s\n", name, statuses[name])
+		counts[statuses[name]]++
+	}
+	rec.Stdout = summary.String()
+
+	if ctx.Err() != nil {
+		rec.Status = runStatusCancelled
+		return fmt.Errorf("workflow cancelled: %w", ctx.Err())
+	}
+	if ok := counts[runStatusSuccess]; ok != len(names) {
+		return fmt.Errorf("%d of %d jobs succeeded (%d skipped)", ok, len(names), counts[runStatusSkipped])
+	}
+	return nil
+}
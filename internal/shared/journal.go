@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultJournalSocket is the socket of journald's native protocol.
+const DefaultJournalSocket = "/run/systemd/journal/socket"
+
+// journalSink sends entries to journald over its native protocol, with the
+// entry's fields as journal fields: job becomes JOB, run_id RUN_ID, and so
+// on. See systemd's documentation of the journal native protocol.
+type journalSink struct {
+	mu   sync.Mutex
+	conn *datagramConn
+}
+
+// NewJournalSink connects to journald's native socket at address, such as
+// DefaultJournalSocket, and returns a sink sending entries there.
+func NewJournalSink(address string) (Sink, error) {
+	if runtime.GOOS != "linux" {
+		return nil, errors.New("the systemd journal is only available on Linux")
+	}
+	conn, err := dialDatagram(address)
+	if err != nil {
+		return nil, err
+	}
+	return &journalSink{conn: conn}, nil
+}
+
+func (s *journalSink) Name() string { return "journal" }
+
+func (s *journalSink) WriteEntry(e LogEntry) error {
+	msg := appendJournalEntry(nil, e)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.conn.send(msg)
+	if isMessageTooLarge(err) {
+		// Entries larger than a datagram are passed in a memory file.
+		return s.sendLarge(msg)
+	}
+	return err
+}
+
+func (s *journalSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
+
+// appendJournalEntry serializes e as journal fields.
+func appendJournalEntry(b []byte, e LogEntry) []byte {
+	b = appendJournalField(b, "MESSAGE", Redact(e.Message))
+	b = appendJournalField(b, "PRIORITY", strconv.Itoa(e.Level.SyslogSeverity()))
+	b = appendJournalField(b, "SYSLOG_IDENTIFIER", LogIdentifier)
+	if frame, ok := e.caller(); ok {
+		b = appendJournalField(b, "CODE_FUNC", frame.Function)
+		b = appendJournalField(b, "CODE_FILE", frame.File)
+		b = appendJournalField(b, "CODE_LINE", strconv.Itoa(frame.Line))
+	}
+	for _, f := range e.Fields {
+		b = appendJournalField(b, journalFieldName(f.Key), Redact(fieldString(f.Value)))
+	}
+	return b
+}
+
+// appendJournalField appends one field, in the binary form if value spans
+// several lines.
+func appendJournalField(b []byte, name, value string) []byte {
+	b = append(b, name...)
+	if !strings.Contains(value, "\n") {
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+	b = append(b, '\n')
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(value)))
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+// journalReservedFields are the fields journald gives a meaning to, which
+// entry fields must not set: a field named message would otherwise add a
+// second MESSAGE.
+var journalReservedFields = map[string]bool{
+	"MESSAGE": true, "MESSAGE_ID": true, "PRIORITY": true,
+	"CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true, "ERRNO": true,
+	"INVOCATION_ID": true, "USER_INVOCATION_ID": true, "TID": true,
+	"SYSLOG_FACILITY": true, "SYSLOG_IDENTIFIER": true, "SYSLOG_PID": true,
+	"SYSLOG_TIMESTAMP": true, "SYSLOG_RAW": true, "DOCUMENTATION": true,
+	"UNIT": true, "USER_UNIT": true,
+}
+
+// journalFieldName makes key a valid journal field name: at most 64
+// uppercase letters, digits and underscores, starting with a letter. Names
+// that journald reserves get an F_ prefix, so field message becomes
+// F_MESSAGE.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] < 'A' || name[0] > 'Z' {
+		// Names starting with '_' are reserved for journald's own fields.
+		name = append([]byte("F"), name...)
+	}
+	if journalReservedFields[string(name)] {
+		name = append([]byte("F_"), name...)
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return string(name)
+}
@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func isMessageTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// sendLarge writes msg to a sealed memory file and passes its descriptor to
+// journald, as the native protocol provides for entries too large for a
+// datagram.
+func (s *journalSink) sendLarge(msg []byte) error {
+	fd, err := unix.MemfdCreate("journal-entry", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return fmt.Errorf("failed to create memory file: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "journal-entry")
+	defer f.Close()
+	if _, err := f.Write(msg); err != nil {
+		return fmt.Errorf("failed to write memory file: %w", err)
+	}
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL); err != nil {
+		return fmt.Errorf("failed to seal memory file: %w", err)
+	}
+	rights := unix.UnixRights(fd)
+	return s.conn.do(func(conn *net.UnixConn) error {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		var sendErr error
+		err = raw.Write(func(sock uintptr) bool {
+			sendErr = unix.Sendmsg(int(sock), nil, rights, nil, 0)
+			return sendErr != unix.EAGAIN
+		})
+		if err != nil {
+			return err
+		}
+		return sendErr
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package shared
+
+// The journal only exists on Linux; NewJournalSink refuses elsewhere.
+
+func isMessageTooLarge(error) bool { return false }
+
+func (s *journalSink) sendLarge([]byte) error { return nil }
@@ -0,0 +1,138 @@
+//go:build linux
+
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// parseJournalEntry decodes the native protocol into field values, in order.
+func parseJournalEntry(t *testing.T, b []byte) map[string][]string {
+	t.Helper()
+	fields := make(map[string][]string)
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "=\n")
+		if i < 0 {
+			t.Fatalf("truncated field %q", b)
+		}
+		name := string(b[:i])
+		var value string
+		if b[i] == '=' {
+			end := bytes.IndexByte(b[i:], '\n')
+			if end < 0 {
+				t.Fatalf("unterminated field %s", name)
+			}
+			value, b = string(b[i+1:i+end]), b[i+end+1:]
+		} else {
+			b = b[i+1:]
+			n := binary.LittleEndian.Uint64(b)
+			value, b = string(b[8:8+n]), b[8+n+1:]
+		}
+		fields[name] = append(fields[name], value)
+	}
+	return fields
+}
+
+func TestJournalSink(t *testing.T) {
+	conn, path := listenDatagram(t, "journal.sock")
+	sink, err := NewJournalSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := newSinkLogger(t, sink)
+	log.Error("Job failed", "job", "backup", "run_id", "r1", "error", "line one\nline two", "message", "from the job")
+
+	fields := parseJournalEntry(t, readDatagram(t, conn))
+	want := map[string]string{
+		"MESSAGE":           "Job failed",
+		"PRIORITY":          "3",
+		"SYSLOG_IDENTIFIER": "go-toy",
+		"JOB":               "backup",
+		"RUN_ID":            "r1",
+		"ERROR":             "line one\nline two",
+		"F_MESSAGE":         "from the job",
+	}
+	for name, value := range want {
+		if got := fields[name]; len(got) != 1 || got[0] != value {
+			t.Errorf("%s = %q, want [%q]", name, got, value)
+		}
+	}
+	if got := fields["CODE_FUNC"]; len(got) != 1 || !strings.HasSuffix(got[0], ".TestJournalSink") {
+		t.Errorf("CODE_FUNC = %q", got)
+	}
+	if got := fields["CODE_FILE"]; len(got) != 1 || !strings.HasSuffix(got[0], "journal_test.go") {
+		t.Errorf("CODE_FILE = %q", got)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"job":                   "JOB",
+		"run-id":                "RUN_ID",
+		"2fa":                   "F2FA",
+		"_SYSTEMD_UNIT":         "F_SYSTEMD_UNIT",
+		"":                      "F",
+		"priority":              "F_PRIORITY",
+		"syslog_identifier":     "F_SYSLOG_IDENTIFIER",
+		"code_line":             "F_CODE_LINE",
+		strings.Repeat("x", 70): strings.Repeat("X", 64),
+	}
+	for key, want := range tests {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// Entries too large for a datagram are passed as a memory file.
+func TestJournalSinkLargeEntry(t *testing.T) {
+	conn, path := listenDatagram(t, "journal.sock")
+	sink, err := NewJournalSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	message := strings.Repeat("x", 1<<20)
+	if err := sink.WriteEntry(LogEntry{Time: time.Now(), Level: LevelInfo, Message: message}); err != nil {
+		t.Fatal(err)
+	}
+
+	oob := make([]byte, syscall.CmsgSpace(4))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, oobn, _, _, err := conn.ReadMsgUnix(make([]byte, 16), oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("control messages = %v, %v", msgs, err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("passed descriptors = %v, %v", fds, err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "journal-entry")
+	defer f.Close()
+	// The descriptor shares the sink's file offset, at the end of the entry.
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseJournalEntry(t, data)["MESSAGE"]; len(got) != 1 || got[0] != message {
+		t.Errorf("MESSAGE of %d bytes not passed intact", len(message))
+	}
+}
+
+func TestJournalSinkMissingSocket(t *testing.T) {
+	_, err := NewJournalSink("/nonexistent/journal.sock")
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/journal.sock") {
+		t.Errorf("error = %v, want the socket path", err)
+	}
+}
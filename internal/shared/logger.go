@@ -1,14 +1,399 @@
 package shared
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
-// LogMessage writes a timestamped message to the log file
-func LogMessage(logWriter io.Writer, message string) {
-	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05")
-	logLine := fmt.Sprintf("%s: %s\n", timestamp, message)
-	logWriter.Write([]byte(logLine))
+// MinRedactedLength is the shortest value SetRedactions hides; shorter ones
+// would mangle unrelated text.
+const MinRedactedLength = 4
+
+const redactedText = "[REDACTED]"
+
+var (
+	redactMu sync.RWMutex
+	redactor *strings.Replacer
+)
+
+// SetRedactions makes loggers replace every occurrence of values, such as
+// secrets, with a placeholder. It replaces the previous set.
+func SetRedactions(values []string) {
+	values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return len(v) < MinRedactedLength })
+	// Longer values first, so one containing another is hidden whole.
+	slices.SortFunc(values, func(a, b string) int { return len(b) - len(a) })
+	var pairs []string
+	for _, v := range slices.Compact(values) {
+		pairs = append(pairs, v, redactedText)
+	}
+
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	redactor = nil
+	if len(pairs) > 0 {
+		redactor = strings.NewReplacer(pairs...)
+	}
+}
+
+// Redact returns s with the values registered through SetRedactions hidden.
+func Redact(s string) string {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	if redactor == nil {
+		return s
+	}
+	return redactor.Replace(s)
+}
+
+// Level is the severity of a log entry.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name as written by Level.String; "warning" is
+// accepted for warn.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+// LogFormat selects how log entries are written.
+type LogFormat string
+
+const (
+	// LogFormatText is one human-readable line per entry:
+	//	2006-01-02 15:04:05 INFO  message key=value
+	LogFormatText LogFormat = "text"
+	// LogFormatLogfmt writes key=value pairs:
+	//	time=2006-01-02T15:04:05.000Z level=info msg=message key=value
+	LogFormatLogfmt LogFormat = "logfmt"
+	// LogFormatJSON writes one JSON object per line:
+	//	{"time":"2006-01-02T15:04:05.000Z","level":"info","msg":"message","key":"value"}
+	LogFormatJSON LogFormat = "json"
+)
+
+// ParseLogFormat checks that s names a log format.
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case LogFormatText, LogFormatLogfmt, LogFormatJSON:
+		return f, nil
+	}
+	return LogFormatText, fmt.Errorf("unknown log format %q (want text, logfmt or json)", s)
+}
+
+// Time layouts of the log formats. Text lines keep the layout of the
+// unstructured log of earlier versions.
+const (
+	textTimeLayout       = "2006-01-02 15:04:05"
+	structuredTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// LogEntry is one log line, as written by a Logger or parsed back by
+// ParseLogLine.
+type LogEntry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+	// PC is the program counter of the logging call, or 0 if unknown.
+	PC uintptr
+}
+
+// Field returns the value of the field key as a string, or "" if the entry
+// has no such field.
+func (e LogEntry) Field(key string) string {
+	for _, f := range e.Fields {
+		if f.Key == key {
+			return fieldString(f.Value)
+		}
+	}
+	return ""
+}
+
+// Logger writes leveled log entries with key-value fields. Loggers derived
+// with With share their output, level and format. A nil *Logger discards
+// everything.
+type Logger struct {
+	out    *logOutput
+	fields []Field
+}
+
+// logOutput is the destination shared by a logger and those derived from it.
+type logOutput struct {
+	mu       sync.Mutex
+	w        io.Writer
+	format   LogFormat
+	fallback io.Writer
+	sinks    []*sinkQueue
+
+	level    atomic.Int32
+	failures atomic.Int64
+}
+
+// NewLogger creates a logger writing entries of at least level to w in
+// format. Lines that cannot be written to w go to stderr instead.
+func NewLogger(w io.Writer, format LogFormat, level Level) *Logger {
+	out := &logOutput{w: w, format: format, fallback: os.Stderr}
+	out.level.Store(int32(level))
+	return &Logger{out: out}
+}
+
+// With returns a logger that adds the key-value pairs kv to every entry.
+func (l *Logger) With(kv ...any) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{out: l.out, fields: append(slices.Clip(l.fields), pairs(kv)...)}
+}
+
+// Level returns the minimum level written.
+func (l *Logger) Level() Level {
+	if l == nil {
+		return LevelError
+	}
+	return Level(l.out.level.Load())
+}
+
+// SetLevel changes the minimum level written, for this logger and all
+// loggers sharing its output.
+func (l *Logger) SetLevel(level Level) {
+	if l != nil {
+		l.out.level.Store(int32(level))
+	}
+}
+
+// Format returns the format entries are written in.
+func (l *Logger) Format() LogFormat {
+	if l == nil {
+		return LogFormatText
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.format
+}
+
+// SetFormat changes the format of the entries written from now on.
+func (l *Logger) SetFormat(format LogFormat) {
+	if l == nil {
+		return
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.format = format
+}
+
+// Enabled reports whether entries of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.Level()
+}
+
+// WriteFailures returns how many entries could not be written to the
+// logger's writer.
+func (l *Logger) WriteFailures() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.out.failures.Load()
+}
+
+func (l *Logger) Debug(msg string, kv ...any) { l.log(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...any)  { l.log(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...any)  { l.log(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...any) { l.log(LevelError, msg, kv) }
+
+// Log writes an entry with the message msg and the key-value pairs kv, which
+// follow the logger's own fields. Secrets registered with SetRedactions are
+// hidden in the message and in string values.
+func (l *Logger) Log(level Level, msg string, kv ...any) {
+	l.log(level, msg, kv)
+}
+
+// log is called directly by the exported methods, so the logging call is
+// always at the same depth.
+func (l *Logger) log(level Level, msg string, kv []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	var pc [1]uintptr
+	// Skip runtime.Callers, log and the exported method.
+	runtime.Callers(3, pc[:])
+	e := LogEntry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+		Fields:  append(slices.Clip(l.fields), pairs(kv)...),
+		PC:      pc[0],
+	}
+	l.out.write(e)
+}
+
+func (o *logOutput) write(e LogEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	line := AppendLogEntry(nil, e, o.format)
+	if _, err := o.w.Write(line); err != nil {
+		if o.failures.Add(1) == 1 {
+			fmt.Fprintf(o.fallback, "Failed to write log, logging to stderr: %v\n", err)
+		}
+		o.fallback.Write(line)
+	}
+	for _, q := range o.sinks {
+		q.offer(e)
+	}
+}
+
+// pairs turns alternating keys and values into fields. A trailing key
+// without a value, or a key that is not a string, is logged under !BADKEY.
+func pairs(kv []any) []Field {
+	fields := make([]Field, 0, len(kv)/2)
+	for len(kv) > 0 {
+		key, ok := kv[0].(string)
+		if !ok || len(kv) == 1 {
+			fields = append(fields, Field{Key: "!BADKEY", Value: kv[0]})
+			kv = kv[1:]
+			continue
+		}
+		fields = append(fields, Field{Key: key, Value: kv[1]})
+		kv = kv[2:]
+	}
+	return fields
+}
+
+// fieldString formats a field value for the text and logfmt formats.
+func fieldString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.UTC().Format(structuredTimeLayout)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonValue returns v as it should appear in a JSON entry: numbers and
+// booleans as themselves, everything else as a string.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return v
+	case time.Duration:
+		return v.String()
+	}
+	return Redact(fieldString(v))
+}
+
+// AppendLogEntry appends e, formatted as one line in format, to b.
+func AppendLogEntry(b []byte, e LogEntry, format LogFormat) []byte {
+	switch format {
+	case LogFormatJSON:
+		b = append(b, `{"time":`...)
+		b = appendJSON(b, e.Time.UTC().Format(structuredTimeLayout))
+		b = append(b, `,"level":`...)
+		b = appendJSON(b, e.Level.String())
+		b = append(b, `,"msg":`...)
+		b = appendJSON(b, Redact(e.Message))
+		for _, f := range e.Fields {
+			b = append(b, ',')
+			b = appendJSON(b, f.Key)
+			b = append(b, ':')
+			b = appendJSON(b, jsonValue(f.Value))
+		}
+		b = append(b, '}')
+	case LogFormatLogfmt:
+		b = append(b, "time="...)
+		b = append(b, e.Time.UTC().Format(structuredTimeLayout)...)
+		b = append(b, " level="...)
+		b = append(b, e.Level.String()...)
+		b = append(b, " msg="...)
+		b = appendLogfmtValue(b, Redact(e.Message))
+		b = appendFields(b, e.Fields)
+	default:
+		b = e.Time.UTC().AppendFormat(b, textTimeLayout)
+		b = fmt.Appendf(b, " %-5s ", strings.ToUpper(e.Level.String()))
+		b = append(b, Redact(e.Message)...)
+		b = appendFields(b, e.Fields)
+	}
+	return append(b, '\n')
+}
+
+func appendFields(b []byte, fields []Field) []byte {
+	for _, f := range fields {
+		b = append(b, ' ')
+		b = append(b, f.Key...)
+		b = append(b, '=')
+		b = appendLogfmtValue(b, Redact(fieldString(f.Value)))
+	}
+	return b
+}
+
+func appendJSON(b []byte, v any) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return strconv.AppendQuote(b, fmt.Sprint(v))
+	}
+	return append(b, bytes.TrimRight(buf.Bytes(), "\n")...)
+}
+
+// appendLogfmtValue appends s, quoted if it is empty or contains spaces,
+// quotes, '=' or control characters.
+func appendLogfmtValue(b []byte, s string) []byte {
+	needsQuote := s == ""
+	for _, r := range s {
+		if r == ' ' || r == '"' || r == '=' || r == '\\' || !unicode.IsPrint(r) {
+			needsQuote = true
+			break
+		}
+	}
+	if needsQuote {
+		return strconv.AppendQuote(b, s)
+	}
+	return append(b, s...)
 }
@@ -0,0 +1,166 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Text lines: "2006-01-02 15:04:05 INFO  message key=value".
+	textLinePattern = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d) (DEBUG|INFO|WARN|ERROR) +(.*)$`)
+	// Lines of the unstructured log of earlier versions:
+	// "2006-01-02 15:04:05: message".
+	legacyLinePattern = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d): (.*)$`)
+)
+
+// ParseLogLine parses a line written by a Logger in any format, or by the
+// unstructured logger of earlier versions, whose lines are taken as info
+// entries. In text lines, the key=value pairs ending the line are taken as
+// fields. ok is false for lines in none of these forms, such as the
+// continuation of a multi-line message.
+func ParseLogLine(line string) (e LogEntry, ok bool) {
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case strings.HasPrefix(line, "{"):
+		return parseJSONLine(line)
+	case strings.HasPrefix(line, "time="):
+		return parseLogfmtLine(line)
+	}
+	if m := textLinePattern.FindStringSubmatch(line); m != nil {
+		t, err := time.Parse(textTimeLayout, m[1])
+		if err != nil {
+			return e, false
+		}
+		level, _ := ParseLevel(m[2])
+		e = LogEntry{Time: t, Level: level, Message: m[3]}
+		// The fields start at the first space after which the rest of
+		// the line is all key=value pairs.
+		for i := strings.IndexByte(m[3], ' '); i >= 0; {
+			if fields, ok := splitLogfmt(m[3][i+1:]); ok && len(fields) > 0 {
+				e.Message, e.Fields = m[3][:i], fields
+				break
+			}
+			next := strings.IndexByte(m[3][i+1:], ' ')
+			if next < 0 {
+				break
+			}
+			i += 1 + next
+		}
+		return e, true
+	}
+	if m := legacyLinePattern.FindStringSubmatch(line); m != nil {
+		t, err := time.Parse(textTimeLayout, m[1])
+		if err != nil {
+			return e, false
+		}
+		return LogEntry{Time: t, Level: LevelInfo, Message: m[2]}, true
+	}
+	return e, false
+}
+
+// setStandard stores the standard keys time, level and msg in e, and any
+// other key as a field.
+func (e *LogEntry) setStandard(key string, value any) error {
+	switch key {
+	case "time":
+		s, _ := value.(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", s)
+		}
+		e.Time = t
+	case "level":
+		s, _ := value.(string)
+		level, err := ParseLevel(s)
+		if err != nil {
+			return err
+		}
+		e.Level = level
+	case "msg":
+		e.Message = fieldString(value)
+	default:
+		e.Fields = append(e.Fields, Field{Key: key, Value: value})
+	}
+	return nil
+}
+
+// parseJSONLine decodes a JSON entry, keeping its fields in order.
+func parseJSONLine(line string) (LogEntry, bool) {
+	var e LogEntry
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return e, false
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return e, false
+		}
+		key, _ := tok.(string)
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return e, false
+		}
+		if err := e.setStandard(key, value); err != nil {
+			return e, false
+		}
+	}
+	return e, !e.Time.IsZero()
+}
+
+// parseLogfmtLine decodes a logfmt entry.
+func parseLogfmtLine(line string) (LogEntry, bool) {
+	var e LogEntry
+	fields, ok := splitLogfmt(line)
+	if !ok {
+		return e, false
+	}
+	for _, f := range fields {
+		if err := e.setStandard(f.Key, f.Value); err != nil {
+			return e, false
+		}
+	}
+	return e, !e.Time.IsZero()
+}
+
+// splitLogfmt splits s into key=value pairs with string values, unquoting
+// quoted ones. ok is false unless all of s is made of such pairs.
+func splitLogfmt(s string) (fields []Field, ok bool) {
+	rest := s
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ")
+		key, after, found := strings.Cut(rest, "=")
+		if !found || key == "" || strings.ContainsAny(key, " \"") {
+			return nil, false
+		}
+		var value string
+		if strings.HasPrefix(after, `"`) {
+			quoted, err := strconv.QuotedPrefix(after)
+			if err != nil {
+				return nil, false
+			}
+			value, _ = strconv.Unquote(quoted)
+			after = after[len(quoted):]
+		} else {
+			end := strings.IndexByte(after, ' ')
+			if end < 0 {
+				end = len(after)
+			}
+			value, after = after[:end], after[end:]
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+		rest = strings.TrimLeft(after, " ")
+	}
+	return fields, true
+}
+
+// FormatLogEntry renders e as a text line without the trailing newline, the
+// form the GUI shows entries in whatever format they were written.
+func FormatLogEntry(e LogEntry) string {
+	return strings.TrimSuffix(string(AppendLogEntry(nil, e, LogFormatText)), "\n")
+}
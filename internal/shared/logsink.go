@@ -0,0 +1,268 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// logSinkQueueSize is how many entries a sink may fall behind before
+	// further ones are dropped.
+	logSinkQueueSize = 1024
+	// logSinkCloseTimeout bounds how long closing a sink waits for its queue
+	// to drain.
+	logSinkCloseTimeout = 2 * time.Second
+	// datagramWriteTimeout bounds a write to a syslog or journal socket whose
+	// daemon has stopped reading, so the sink gives up the entry rather than
+	// hold its lock until the daemon catches up.
+	datagramWriteTimeout = time.Second
+)
+
+// LogIdentifier names the program in syslog and journal entries.
+const LogIdentifier = "go-toy"
+
+// Sink receives a copy of the entries a Logger writes, such as a syslog or
+// journal connection. Each sink is fed from its own queue, so a slow sink
+// only delays itself; entries that do not fit in a full queue are dropped.
+type Sink interface {
+	// Name identifies the sink in SinkStats.
+	Name() string
+	WriteEntry(e LogEntry) error
+	Close() error
+}
+
+// SinkStats reports how a sink is keeping up.
+type SinkStats struct {
+	Name      string
+	Written   int64
+	Dropped   int64
+	Failed    int64
+	LastError string
+}
+
+// sinkQueue feeds entries to a sink from a goroutine of its own.
+type sinkQueue struct {
+	sink    Sink
+	entries chan LogEntry
+	stop    chan struct{}
+	done    chan struct{}
+
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+
+	mu      sync.Mutex
+	lastErr string
+}
+
+func newSinkQueue(s Sink) *sinkQueue {
+	q := &sinkQueue{
+		sink:    s,
+		entries: make(chan LogEntry, logSinkQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *sinkQueue) run() {
+	defer close(q.done)
+	for e := range q.entries {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+		if err := q.sink.WriteEntry(e); err != nil {
+			q.failed.Add(1)
+			q.mu.Lock()
+			q.lastErr = err.Error()
+			q.mu.Unlock()
+			continue
+		}
+		q.written.Add(1)
+	}
+}
+
+// offer queues e unless the queue is full.
+func (q *sinkQueue) offer(e LogEntry) {
+	select {
+	case q.entries <- e:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// close stops accepting entries, gives the queue up to timeout to drain and
+// closes the sink. Entries still queued then are dropped.
+func (q *sinkQueue) close(timeout time.Duration) {
+	close(q.entries)
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+		close(q.stop)
+	}
+	q.sink.Close()
+}
+
+func (q *sinkQueue) stats() SinkStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return SinkStats{
+		Name:      q.sink.Name(),
+		Written:   q.written.Load(),
+		Dropped:   q.dropped.Load(),
+		Failed:    q.failed.Load(),
+		LastError: q.lastErr,
+	}
+}
+
+// SetSinks replaces the sinks of the logger and all loggers sharing its
+// output. The previous sinks are closed once their queues have drained, or
+// after a short timeout.
+func (l *Logger) SetSinks(sinks ...Sink) {
+	if l == nil {
+		return
+	}
+	queues := make([]*sinkQueue, len(sinks))
+	for i, s := range sinks {
+		queues[i] = newSinkQueue(s)
+	}
+
+	l.out.mu.Lock()
+	old := l.out.sinks
+	l.out.sinks = queues
+	l.out.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, q := range old {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.close(logSinkCloseTimeout)
+		}()
+	}
+	wg.Wait()
+}
+
+// SinkStats reports on each sink of the logger.
+func (l *Logger) SinkStats() []SinkStats {
+	if l == nil {
+		return nil
+	}
+	l.out.mu.Lock()
+	queues := l.out.sinks
+	l.out.mu.Unlock()
+	stats := make([]SinkStats, len(queues))
+	for i, q := range queues {
+		stats[i] = q.stats()
+	}
+	return stats
+}
+
+// SyslogSeverity returns the syslog severity of l, which the journal uses as
+// PRIORITY too.
+func (l Level) SyslogSeverity() int {
+	switch {
+	case l >= LevelError:
+		return 3
+	case l >= LevelWarn:
+		return 4
+	case l >= LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// caller returns the function, file and line an entry was logged from.
+func (e LogEntry) caller() (runtime.Frame, bool) {
+	if e.PC == 0 {
+		return runtime.Frame{}, false
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{e.PC}).Next()
+	return frame, frame.Function != ""
+}
+
+// datagramConn is a Unix datagram socket that is dialled again after a
+// failed write, so forwarding recovers when the daemon behind it restarts.
+type datagramConn struct {
+	address string
+	conn    *net.UnixConn
+	closed  bool
+}
+
+func dialDatagram(address string) (*datagramConn, error) {
+	c := &datagramConn{address: address}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *datagramConn) dial() error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: c.address, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", c.address, err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// send writes b as one datagram.
+func (c *datagramConn) send(b []byte) error {
+	return c.do(func(conn *net.UnixConn) error {
+		_, err := conn.Write(b)
+		return err
+	})
+}
+
+// do calls write with the connection, dialling first if needed, within
+// datagramWriteTimeout. A write on a connection that has gone stale is
+// retried once on a new one; one that timed out is not, as the daemon is
+// alive but behind.
+func (c *datagramConn) do(write func(*net.UnixConn) error) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+	fresh := c.conn == nil
+	if fresh {
+		if err := c.dial(); err != nil {
+			return err
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(datagramWriteTimeout))
+	err := write(c.conn)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%s is not accepting entries: %w", c.address, err)
+	}
+	if err != nil && !fresh {
+		c.conn.Close()
+		if err = c.dial(); err != nil {
+			return err
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(datagramWriteTimeout))
+		err = write(c.conn)
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+func (c *datagramConn) Close() error {
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
@@ -0,0 +1,115 @@
+//go:build linux
+
+package shared
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenDatagram stands in for a syslog or journal daemon.
+func listenDatagram(t *testing.T, name string) (*net.UnixConn, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) []byte {
+	t.Helper()
+	buf := make([]byte, 1<<16)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+// newSinkLogger returns a logger forwarding to sink only.
+func newSinkLogger(t *testing.T, sink Sink) *Logger {
+	t.Helper()
+	log := NewLogger(io.Discard, LogFormatText, LevelDebug)
+	log.SetSinks(sink)
+	t.Cleanup(func() { log.SetSinks() })
+	return log
+}
+
+// A daemon that stops reading must neither block logging nor the reload
+// replacing its sink.
+func TestSinkStuckDaemon(t *testing.T) {
+	_, path := listenDatagram(t, "log.sock")
+	sink, err := NewSyslogSink(path, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := NewLogger(io.Discard, LogFormatText, LevelDebug)
+	log.SetSinks(sink)
+
+	start := time.Now()
+	for i := range 3000 {
+		log.Info("Entry", "n", i)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("logging 3000 entries took %s", d)
+	}
+
+	// Let the socket buffer fill and a write block.
+	time.Sleep(100 * time.Millisecond)
+	stats := log.SinkStats()
+	if len(stats) != 1 || stats[0].Dropped == 0 {
+		t.Errorf("stats = %+v, want dropped entries", stats)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		log.SetSinks()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(logSinkCloseTimeout + 3*datagramWriteTimeout):
+		t.Fatal("replacing a stuck sink did not return")
+	}
+}
+
+func TestSinkRedialsAfterRestart(t *testing.T) {
+	conn, path := listenDatagram(t, "log.sock")
+	sink, err := NewSyslogSink(path, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.WriteEntry(LogEntry{Time: time.Now(), Level: LevelInfo, Message: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	readDatagram(t, conn)
+
+	// The daemon restarts and binds a new socket at the same path.
+	conn.Close()
+	os.Remove(path)
+	restarted, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	if err := sink.WriteEntry(LogEntry{Time: time.Now(), Level: LevelInfo, Message: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readDatagram(t, restarted); !hasSuffix(msg, " after") {
+		t.Errorf("message = %q", msg)
+	}
+}
+
+func hasSuffix(b []byte, s string) bool {
+	return len(b) >= len(s) && string(b[len(b)-len(s):]) == s
+}
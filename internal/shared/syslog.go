@@ -0,0 +1,160 @@
+package shared
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultSyslogSocket is the local syslog socket.
+	DefaultSyslogSocket = "/dev/log"
+
+	// syslogSDID names the structured data element carrying entry fields.
+	// 32473 is the enterprise number reserved for documentation (RFC 5612).
+	syslogSDID = "gotoy@32473"
+	// syslogTimeLayout is RFC 3339 with microseconds, as RFC 5424 allows.
+	syslogTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+	// syslogMaxMessage is the size messages are cut to: syslog daemons
+	// commonly drop or truncate longer ones, and /dev/log refuses datagrams
+	// much larger.
+	syslogMaxMessage = 8192
+)
+
+// syslogFacilities are the facility names of RFC 5424 and their codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacilities returns the facility names NewSyslogSink accepts.
+func SyslogFacilities() []string {
+	names := make([]string, 0, len(syslogFacilities))
+	for name := range syslogFacilities {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int { return syslogFacilities[a] - syslogFacilities[b] })
+	return names
+}
+
+// syslogSink sends entries as RFC 5424 messages to a local syslog socket,
+// with the entry's fields as structured data.
+type syslogSink struct {
+	facility int
+	hostname string
+	pid      string
+
+	mu   sync.Mutex
+	conn *datagramConn
+}
+
+// NewSyslogSink connects to the syslog socket at address, such as
+// DefaultSyslogSocket, and returns a sink sending entries there under
+// facility.
+func NewSyslogSink(address, facility string) (Sink, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	conn, err := dialDatagram(address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{facility: code, hostname: hostname, pid: strconv.Itoa(os.Getpid()), conn: conn}, nil
+}
+
+func (s *syslogSink) Name() string { return "syslog" }
+
+func (s *syslogSink) WriteEntry(e LogEntry) error {
+	msg := s.appendMessage(nil, e)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.send(msg)
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
+
+// appendMessage formats e as an RFC 5424 message:
+//
+//	<27>1 2006-01-02T15:04:05.000000Z host go-toy 42 - [gotoy@32473 job="backup"] Job failed
+func (s *syslogSink) appendMessage(b []byte, e LogEntry) []byte {
+	b = fmt.Appendf(b, "<%d>1 ", s.facility*8+e.Level.SyslogSeverity())
+	b = e.Time.UTC().AppendFormat(b, syslogTimeLayout)
+	b = append(b, ' ')
+	b = append(b, s.hostname...)
+	b = append(b, ' ')
+	b = append(b, LogIdentifier...)
+	b = append(b, ' ')
+	b = append(b, s.pid...)
+	// No MSGID.
+	b = append(b, " - "...)
+	b = appendSyslogSD(b, e.Fields)
+	b = append(b, ' ')
+	b = append(b, Redact(e.Message)...)
+	if len(b) > syslogMaxMessage {
+		b = b[:syslogMaxMessage]
+		// Do not leave half a UTF-8 sequence at the end.
+		for len(b) > 0 && !utf8.RuneStart(b[len(b)-1]) {
+			b = b[:len(b)-1]
+		}
+		if len(b) > 0 && !utf8.FullRune(b[len(b)-1:]) {
+			b = b[:len(b)-1]
+		}
+	}
+	return b
+}
+
+// appendSyslogSD appends fields as one structured data element, or the nil
+// value "-" if there are none.
+func appendSyslogSD(b []byte, fields []Field) []byte {
+	if len(fields) == 0 {
+		return append(b, '-')
+	}
+	b = append(b, '[')
+	b = append(b, syslogSDID...)
+	for _, f := range fields {
+		b = append(b, ' ')
+		b = append(b, syslogParamName(f.Key)...)
+		b = append(b, `="`...)
+		for _, r := range Redact(fieldString(f.Value)) {
+			// '"', '\' and ']' must be escaped in parameter values.
+			if r == '"' || r == '\\' || r == ']' {
+				b = append(b, '\\')
+			}
+			b = append(b, string(r)...)
+		}
+		b = append(b, '"')
+	}
+	return append(b, ']')
+}
+
+// syslogParamName makes key a valid SD-NAME: at most 32 printable ASCII
+// characters other than '=', ' ', ']' and '"'.
+func syslogParamName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if c <= ' ' || c >= 0x7f || c == '=' || c == ']' || c == '"' {
+			name[i] = '_'
+		}
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
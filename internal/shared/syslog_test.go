@@ -0,0 +1,78 @@
+//go:build linux
+
+package shared
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSyslogSink(t *testing.T) {
+	conn, path := listenDatagram(t, "log.sock")
+	sink, err := NewSyslogSink(path, "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRedactions([]string{"hunter22"})
+	t.Cleanup(func() { SetRedactions(nil) })
+
+	log := newSinkLogger(t, sink)
+	log.Warn("Job failed: password hunter22", "job", "backup", "error", `exit "1" ]`)
+
+	msg := string(readDatagram(t, conn))
+	// daemon (3) * 8 + warning (4)
+	pattern := `^<28>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ go-toy ` + strconv.Itoa(os.Getpid()) + ` - `
+	if !regexp.MustCompile(pattern).MatchString(msg) {
+		t.Errorf("header of %q does not match %s", msg, pattern)
+	}
+	want := ` - [gotoy@32473 job="backup" error="exit \"1\" \]"] Job failed: password [REDACTED]`
+	if !strings.HasSuffix(msg, want) {
+		t.Errorf("message = %q, want suffix %q", msg, want)
+	}
+}
+
+func TestSyslogSinkNoFields(t *testing.T) {
+	conn, path := listenDatagram(t, "log.sock")
+	sink, err := NewSyslogSink(path, "local3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSinkLogger(t, sink).Debug("Tick")
+
+	// local3 (19) * 8 + debug (7)
+	msg := string(readDatagram(t, conn))
+	if !strings.HasPrefix(msg, "<159>1 ") || !strings.HasSuffix(msg, " - - Tick") {
+		t.Errorf("message = %q", msg)
+	}
+}
+
+func TestSyslogSinkTruncates(t *testing.T) {
+	conn, path := listenDatagram(t, "log.sock")
+	sink, err := NewSyslogSink(path, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSinkLogger(t, sink).Info(strings.Repeat("€", syslogMaxMessage))
+
+	msg := readDatagram(t, conn)
+	if len(msg) > syslogMaxMessage || len(msg) < syslogMaxMessage-utf8.UTFMax {
+		t.Errorf("message is %d bytes, want just under %d", len(msg), syslogMaxMessage)
+	}
+	if !utf8.Valid(msg) {
+		t.Error("truncated message is not valid UTF-8")
+	}
+}
+
+func TestSyslogSinkErrors(t *testing.T) {
+	if _, err := NewSyslogSink("/nonexistent/log.sock", "user"); err == nil {
+		t.Error("connecting to a missing socket succeeded")
+	}
+	_, path := listenDatagram(t, "log.sock")
+	if _, err := NewSyslogSink(path, "local9"); err == nil || !strings.Contains(err.Error(), "unknown syslog facility") {
+		t.Errorf("error = %v, want unknown facility", err)
+	}
+}
@@ -0,0 +1,5 @@
+package shared
+
+// Version is the application version. Release builds can override it with
+// -ldflags "-X go-toy/internal/shared.Version=<version>".
+var Version = "1.0.0"
@@ -20,7 +20,7 @@ func main() {
 	// If invoked with service commands, run as the background task runner.
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
-		case "run", "install", "uninstall", "start", "stop", "status":
+		case "run", "install", "uninstall", "start", "stop", "status", "check-config", "pause", "resume", "next-runs", "secret", "notify-test", "job-types", "log-level", "limit-exec":
 			service.Run()
 			return
 		}